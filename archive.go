@@ -0,0 +1,120 @@
+package dexec
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/continuity/fs"
+)
+
+// tarPath returns a tar stream of the file or directory at src. The root of the
+// archive is named name, so extracting it into a directory creates dir/name.
+func tarPath(src, name string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTar(pw, src, name))
+	}()
+	return pr
+}
+
+func writeTar(w io.Writer, src, name string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(filepath.Join(name, rel))
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// untar extracts the tar stream r into the directory dst. Entry names are
+// resolved with dst as their root so an archive cannot write outside of it.
+func untar(dst string, r io.Reader) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target, err := fs.RootPath(dst, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if err = extractEntry(tr, hdr, target); err != nil {
+			return fmt.Errorf("error extracting %s: %w", hdr.Name, err)
+		}
+	}
+}
+
+func extractEntry(r io.Reader, hdr *tar.Header, target string) error {
+	mode := hdr.FileInfo().Mode()
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(target, mode.Perm()); err != nil {
+			return err
+		}
+		return os.Chmod(target, mode.Perm())
+	case tar.TypeReg:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode.Perm())
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(f, r); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	case tar.TypeSymlink:
+		_ = os.Remove(target)
+		return os.Symlink(hdr.Linkname, target)
+	default:
+		// devices, fifos and hard links are not needed to ship inputs and outputs
+		return nil
+	}
+}
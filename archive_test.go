@@ -0,0 +1,39 @@
+package dexec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_tarPath_untar(t *testing.T) {
+	src := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(src, "nested"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "nested", "data.txt"), []byte("data"), 0600))
+	assert.NoError(t, os.Symlink("nested/data.txt", filepath.Join(src, "link")))
+
+	dst := t.TempDir()
+	assert.NoError(t, untar(dst, tarPath(src, "copy")))
+
+	b, err := os.ReadFile(filepath.Join(dst, "copy", "nested", "data.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(b))
+	info, err := os.Stat(filepath.Join(dst, "copy", "nested", "data.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	link, err := os.Readlink(filepath.Join(dst, "copy", "link"))
+	assert.NoError(t, err)
+	assert.Equal(t, "nested/data.txt", link)
+}
+
+func Test_untar_StaysInsideDestination(t *testing.T) {
+	src := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "escape.txt"), []byte("data"), 0644))
+
+	dst := t.TempDir()
+	assert.NoError(t, untar(dst, tarPath(filepath.Join(src, "escape.txt"), "../../escape.txt")))
+
+	_, err := os.Stat(filepath.Join(dst, "escape.txt"))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,98 @@
+package dexec
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/containerd/continuity/fs"
+)
+
+// ErrArtifactsTooLarge is returned by Wait when the collected artifacts exceed Artifacts.MaxBytes
+var ErrArtifactsTooLarge = errors.New("dexec: artifacts exceed size limit")
+
+// Artifacts lists files to collect from a container after the command exits and
+// before the container is removed.
+type Artifacts struct {
+	// Paths are the files or directories in the container to collect. Each path is
+	// stored in the result under its base name.
+	Paths []string
+	// Output, if not nil, receives a single tar stream containing every path.
+	Output io.Writer
+	// Dir, if not empty, is a host directory the paths are extracted into.
+	Dir string
+	// MaxBytes limits the total size of the collected files. Zero means no limit.
+	MaxBytes int64
+}
+
+func (g *GenericCmd[T]) collectArtifacts() error {
+	sink := &artifactSink{dir: g.Artifacts.Dir, maxBytes: g.Artifacts.MaxBytes}
+	if g.Artifacts.Output != nil {
+		sink.tw = tar.NewWriter(g.Artifacts.Output)
+	}
+	for _, src := range g.Artifacts.Paths {
+		if err := g.collectArtifact(src, sink); err != nil {
+			return fmt.Errorf("dexec: failed to collect artifact %s: %w", src, err)
+		}
+	}
+	if sink.tw != nil {
+		return sink.tw.Close()
+	}
+	return nil
+}
+
+func (g *GenericCmd[T]) collectArtifact(src string, sink *artifactSink) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(g.Method.copyOut(g.client, src, pw))
+	}()
+	// closing the reader unblocks copyOut if we stop reading early
+	defer pr.Close()
+
+	tr := tar.NewReader(pr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = sink.add(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// artifactSink writes collected entries to a tar stream, a host directory, or both
+type artifactSink struct {
+	tw       *tar.Writer
+	dir      string
+	maxBytes int64
+	size     int64
+}
+
+func (s *artifactSink) add(hdr *tar.Header, r io.Reader) error {
+	s.size += hdr.Size
+	if s.maxBytes > 0 && s.size > s.maxBytes {
+		return ErrArtifactsTooLarge
+	}
+	if s.tw != nil {
+		if err := s.tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		r = io.TeeReader(r, s.tw)
+	}
+	if s.dir != "" {
+		target, err := fs.RootPath(s.dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if err = extractEntry(r, hdr, target); err != nil {
+			return err
+		}
+	}
+	_, err := io.Copy(io.Discard, r)
+	return err
+}
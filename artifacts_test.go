@@ -0,0 +1,89 @@
+package dexec
+
+import (
+	"archive/tar"
+	"bytes"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+// rootfsExecution is an Execution whose container filesystem is a host directory
+type rootfsExecution struct {
+	root string
+}
+
+func (e *rootfsExecution) create(Docker, []string) error                     { return nil }
+func (e *rootfsExecution) run(Docker, io.Reader, io.Writer, io.Writer) error { return nil }
+func (e *rootfsExecution) wait(_ Docker, beforeRemove func() error) (int, error) {
+	if beforeRemove != nil {
+		return 0, beforeRemove()
+	}
+	return 0, nil
+}
+func (e *rootfsExecution) copyIn(_ Docker, dst string, content io.Reader) error {
+	return untar(filepath.Join(e.root, dst), content)
+}
+func (e *rootfsExecution) copyOut(_ Docker, src string, w io.Writer) error {
+	return writeTar(w, filepath.Join(e.root, src), path.Base(src))
+}
+func (e *rootfsExecution) setEnv([]string) error                { return nil }
+func (e *rootfsExecution) setDir(string) error                  { return nil }
+func (e *rootfsExecution) getID() string                        { return "rootfs" }
+func (e *rootfsExecution) imageDigest() string                  { return "" }
+func (e *rootfsExecution) kill(Docker) error                    { return nil }
+func (e *rootfsExecution) cleanup(Docker) error                 { return nil }
+func (e *rootfsExecution) setTransaction(*newrelic.Transaction) {}
+
+func newRootfsCmd(t *testing.T) *DockerCmd {
+	root := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "out", "reports"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "out", "result.json"), []byte(`{"ok":true}`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "out", "reports", "report.txt"), []byte("report"), 0644))
+	return Docker{}.Command(&rootfsExecution{root: root}, "true")
+}
+
+func TestGenericCmd_Artifacts_Dir(t *testing.T) {
+	cmd := newRootfsCmd(t)
+	dir := t.TempDir()
+	cmd.Artifacts = Artifacts{Paths: []string{"/out/result.json", "/out/reports"}, Dir: dir}
+
+	assert.NoError(t, cmd.Run())
+	b, err := os.ReadFile(filepath.Join(dir, "result.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"ok":true}`, string(b))
+	b, err = os.ReadFile(filepath.Join(dir, "reports", "report.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "report", string(b))
+}
+
+func TestGenericCmd_Artifacts_Output(t *testing.T) {
+	cmd := newRootfsCmd(t)
+	var out bytes.Buffer
+	cmd.Artifacts = Artifacts{Paths: []string{"/out/result.json", "/out/reports"}, Output: &out}
+
+	assert.NoError(t, cmd.Run())
+	var names []string
+	tr := tar.NewReader(&out)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+	assert.Equal(t, []string{"result.json", "reports/", "reports/report.txt"}, names)
+}
+
+func TestGenericCmd_Artifacts_MaxBytes(t *testing.T) {
+	cmd := newRootfsCmd(t)
+	cmd.Artifacts = Artifacts{Paths: []string{"/out"}, Dir: t.TempDir(), MaxBytes: 12}
+
+	err := cmd.Run()
+	assert.ErrorIs(t, err, ErrArtifactsTooLarge)
+}
@@ -0,0 +1,137 @@
+package dexec
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	refdocker "github.com/containerd/containerd/reference/docker"
+	"github.com/containerd/containerd/remotes"
+	dockerremote "github.com/containerd/containerd/remotes/docker"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+const dockerHubRegistry = "docker.io"
+
+// Credentials are the credentials for a single registry. Either Username and Password
+// or Token should be set.
+type Credentials struct {
+	Username string
+	Password string
+	// Token is an identity token, as stored by `docker login` for registries that issue them.
+	Token string
+}
+
+// RegistryAuth provides the credentials used to pull images. Credentials are looked up
+// per registry host: Helper is tried first, then the static credentials, then ConfigPath.
+type RegistryAuth struct {
+	// Username, Password and Token are static credentials. They are used for every
+	// registry unless ServerAddress is set.
+	Username string
+	Password string
+	Token    string
+	// ServerAddress restricts the static credentials to a single registry host
+	ServerAddress string
+	// ConfigPath is the path of a Docker config.json with an "auths" section
+	ConfigPath string
+	// Helper, if not nil, is called with the registry host and returns its credentials.
+	// Returning empty Credentials falls back to the other sources.
+	Helper func(host string) (Credentials, error)
+}
+
+// credentials returns the credentials for the registry host, which are empty if none are configured
+func (a *RegistryAuth) credentials(host string) (Credentials, error) {
+	if a == nil {
+		return Credentials{}, nil
+	}
+	host = normalizeRegistryHost(host)
+	if a.Helper != nil {
+		creds, err := a.Helper(host)
+		if err != nil {
+			return Credentials{}, fmt.Errorf("dexec: credential helper failed for %s: %w", host, err)
+		}
+		if creds != (Credentials{}) {
+			return creds, nil
+		}
+	}
+	static := Credentials{Username: a.Username, Password: a.Password, Token: a.Token}
+	if static != (Credentials{}) && (a.ServerAddress == "" || normalizeRegistryHost(a.ServerAddress) == host) {
+		return static, nil
+	}
+	if a.ConfigPath != "" {
+		configs, err := docker.NewAuthConfigurationsFromFile(a.ConfigPath)
+		if err != nil {
+			return Credentials{}, fmt.Errorf("dexec: failed to read registry auth from %s: %w", a.ConfigPath, err)
+		}
+		for server, conf := range configs.Configs {
+			if normalizeRegistryHost(server) == host {
+				return Credentials{Username: conf.Username, Password: conf.Password, Token: conf.IdentityToken}, nil
+			}
+		}
+	}
+	return Credentials{}, nil
+}
+
+// dockerAuth returns the credentials for image in the form expected by the Docker API
+func (a *RegistryAuth) dockerAuth(image string) (docker.AuthConfiguration, error) {
+	host, err := registryHost(image)
+	if err != nil {
+		return docker.AuthConfiguration{}, err
+	}
+	creds, err := a.credentials(host)
+	if err != nil || creds == (Credentials{}) {
+		return docker.AuthConfiguration{}, err
+	}
+	return docker.AuthConfiguration{
+		Username:      creds.Username,
+		Password:      creds.Password,
+		IdentityToken: creds.Token,
+		ServerAddress: host,
+	}, nil
+}
+
+// resolver returns a containerd resolver that authorizes registry requests with the credentials of a. Registries on
+// localhost are accessed over plain HTTP, as Docker does
+func (a *RegistryAuth) resolver() remotes.Resolver {
+	authorizer := dockerremote.NewDockerAuthorizer(dockerremote.WithAuthCreds(func(host string) (string, string, error) {
+		creds, err := a.credentials(host)
+		if err != nil {
+			return "", "", err
+		}
+		if creds.Token != "" {
+			// containerd treats a secret without a username as an identity token
+			return "", creds.Token, nil
+		}
+		return creds.Username, creds.Password, nil
+	}))
+	return dockerremote.NewResolver(dockerremote.ResolverOptions{
+		Hosts: dockerremote.ConfigureDefaultRegistries(
+			dockerremote.WithAuthorizer(authorizer),
+			dockerremote.WithPlainHTTP(dockerremote.MatchLocalhost),
+		),
+	})
+}
+
+// registryHost returns the registry host of an image reference, e.g. docker.io for busybox
+func registryHost(image string) (string, error) {
+	named, err := refdocker.ParseDockerRef(image)
+	if err != nil {
+		return "", fmt.Errorf("dexec: error parsing image reference: %w", err)
+	}
+	return refdocker.Domain(named), nil
+}
+
+// normalizeRegistryHost maps the different names used for a registry, such as the keys of config.json or the hosts
+// contacted by containerd, to the host of its image references
+func normalizeRegistryHost(server string) string {
+	host := server
+	if u, err := url.Parse(server); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	host = strings.TrimSuffix(strings.SplitN(host, "/", 2)[0], ":443")
+	switch host {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return dockerHubRegistry
+	}
+	return host
+}
@@ -0,0 +1,190 @@
+package dexec
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testRegistry is an in-process stand-in for an OCI registry that serves manifests behind basic auth
+type testRegistry struct {
+	*httptest.Server
+	username  string
+	password  string
+	manifests map[string][]byte // keyed by "repository:tag"
+}
+
+func newTestRegistry(t *testing.T, username, password string) *testRegistry {
+	r := &testRegistry{username: username, password: password, manifests: map[string][]byte{}}
+	r.Server = httptest.NewServer(r)
+	t.Cleanup(r.Close)
+	return r
+}
+
+// Host returns the host of the registry as used in image references
+func (r *testRegistry) Host() string {
+	return strings.TrimPrefix(r.URL, "http://")
+}
+
+// AddManifest stores a manifest for repository:tag and returns its digest
+func (r *testRegistry) AddManifest(repository, tag string) digest.Digest {
+	b, _ := json.Marshal(ocispec.Manifest{MediaType: ocispec.MediaTypeImageManifest, Annotations: map[string]string{"tag": tag}})
+	r.manifests[repository+":"+tag] = b
+	return digest.FromBytes(b)
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if user, pass, ok := req.BasicAuth(); !ok || user != r.username || pass != r.password {
+		w.Header().Set("WWW-Authenticate", `Basic realm="dexec-test"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if req.URL.Path == "/v2/" {
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/v2/"), "/manifests/", 2)
+	if len(parts) != 2 {
+		http.NotFound(w, req)
+		return
+	}
+	for key, b := range r.manifests {
+		dgst := digest.FromBytes(b)
+		if key == parts[0]+":"+parts[1] || strings.HasPrefix(key, parts[0]+":") && dgst.String() == parts[1] {
+			w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+			w.Header().Set("Docker-Content-Digest", dgst.String())
+			w.Header().Set("Content-Length", fmt.Sprint(len(b)))
+			if req.Method == http.MethodGet {
+				w.Write(b)
+			}
+			return
+		}
+	}
+	http.NotFound(w, req)
+}
+
+func writeDockerConfig(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	auth := func(userpass string) string { return base64.StdEncoding.EncodeToString([]byte(userpass)) }
+	config := fmt.Sprintf(`{"auths": {
+		"https://index.docker.io/v1/": {"auth": %q},
+		"registry.example.com": {"auth": %q, "identitytoken": "refresh-token"}
+	}}`, auth("hub-user:hub-pass"), auth("example-user:"))
+	assert.NoError(t, os.WriteFile(path, []byte(config), 0600))
+	return path
+}
+
+func TestRegistryAuth_credentials(t *testing.T) {
+	configPath := writeDockerConfig(t)
+	helper := func(host string) (Credentials, error) {
+		if host == "helper.example.com" {
+			return Credentials{Username: "helper-user", Password: "helper-pass"}, nil
+		}
+		return Credentials{}, nil
+	}
+	tests := []struct {
+		name     string
+		auth     *RegistryAuth
+		host     string
+		expected Credentials
+	}{
+		{name: "nil auth", host: "docker.io"},
+		{
+			name:     "static",
+			auth:     &RegistryAuth{Username: "user", Password: "pass"},
+			host:     "registry.example.com",
+			expected: Credentials{Username: "user", Password: "pass"},
+		},
+		{
+			name:     "static for server",
+			auth:     &RegistryAuth{Username: "user", Password: "pass", ServerAddress: "https://registry.example.com"},
+			host:     "registry.example.com",
+			expected: Credentials{Username: "user", Password: "pass"},
+		},
+		{
+			name: "static for other server",
+			auth: &RegistryAuth{Username: "user", Password: "pass", ServerAddress: "other.example.com"},
+			host: "registry.example.com",
+		},
+		{
+			name:     "config.json docker hub",
+			auth:     &RegistryAuth{ConfigPath: configPath},
+			host:     "registry-1.docker.io",
+			expected: Credentials{Username: "hub-user", Password: "hub-pass"},
+		},
+		{
+			name:     "config.json identity token",
+			auth:     &RegistryAuth{ConfigPath: configPath},
+			host:     "registry.example.com",
+			expected: Credentials{Username: "example-user", Token: "refresh-token"},
+		},
+		{
+			name:     "helper",
+			auth:     &RegistryAuth{Helper: helper, ConfigPath: configPath},
+			host:     "helper.example.com",
+			expected: Credentials{Username: "helper-user", Password: "helper-pass"},
+		},
+		{
+			name:     "helper falls back",
+			auth:     &RegistryAuth{Helper: helper, ConfigPath: configPath},
+			host:     "docker.io",
+			expected: Credentials{Username: "hub-user", Password: "hub-pass"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := tt.auth.credentials(tt.host)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestRegistryAuth_credentials_HelperError(t *testing.T) {
+	expectedErr := errors.New("unit-test")
+	auth := &RegistryAuth{Helper: func(string) (Credentials, error) { return Credentials{}, expectedErr }}
+	_, err := auth.credentials("docker.io")
+	assert.ErrorIs(t, err, expectedErr)
+}
+
+func TestRegistryAuth_resolver(t *testing.T) {
+	registry := newTestRegistry(t, "user", "secret")
+	expected := registry.AddManifest("team/app", "1.0")
+	ref := registry.Host() + "/team/app:1.0"
+
+	auth := &RegistryAuth{Username: "user", Password: "secret"}
+	_, desc, err := auth.resolver().Resolve(context.Background(), ref)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, desc.Digest)
+
+	auth = &RegistryAuth{Username: "user", Password: "wrong"}
+	_, _, err = auth.resolver().Resolve(context.Background(), ref)
+	assert.Error(t, err)
+}
+
+func Test_createContainer_pullImage_Auth(t *testing.T) {
+	var auth docker.AuthConfiguration
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := base64.URLEncoding.DecodeString(r.Header.Get("X-Registry-Auth"))
+		_ = json.Unmarshal(b, &auth)
+	}))
+	defer server.Close()
+	client, err := docker.NewClient(server.URL)
+	assert.NoError(t, err)
+
+	exec, _ := ByCreatingContainer(docker.CreateContainerOptions{Config: &docker.Config{Image: "registry.example.com/team/app:1.0"}},
+		WithImageOptions(ImageOptions{PullPolicy: PullAlways, Auth: &RegistryAuth{Username: "user", Password: "secret"}}))
+	assert.NoError(t, exec.(*createContainer).pullImage(Docker{Client: client}))
+	assert.Equal(t, docker.AuthConfiguration{Username: "user", Password: "secret", ServerAddress: "registry.example.com"}, auth)
+}
@@ -0,0 +1,211 @@
+package dexec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// ErrUnsupportedClient is returned by a BackendFactory given a client of another backend.
+var ErrUnsupportedClient = errors.New("dexec: unsupported client")
+
+// ErrNotSupported is returned by Backend methods the runtime cannot implement.
+var ErrNotSupported = errors.New("dexec: not supported by backend")
+
+// Backend runs a command with a container runtime other than Docker and containerd.
+// It is the exported counterpart of Execution: a Backend runs a single command, and
+// BackendCmd calls its methods in the same order and with the same semantics as the
+// built-in executions, so it provides pipes, Output, artifacts and tracing.
+type Backend interface {
+	// Create creates the container running cmd without starting it
+	Create(cmd []string) error
+	// Start starts the created container with its standard streams connected to
+	// stdin, stdout and stderr. It does not wait for the command to exit.
+	Start(stdin io.Reader, stdout, stderr io.Writer) error
+	// Wait waits for the command to exit, removes its container and returns its exit
+	// code. beforeRemove, if not nil, is called after the command exits and before
+	// its container is removed.
+	Wait(beforeRemove func() error) (int, error)
+	// CopyIn extracts the tar archive read from content into the directory dst of
+	// the created container
+	CopyIn(dst string, content io.Reader) error
+	// CopyOut writes a tar archive of the path src in the container to w
+	CopyOut(src string, w io.Writer) error
+	// SetEnv and SetDir are called before Create when Cmd.Env or Cmd.Dir are set
+	SetEnv(env []string) error
+	SetDir(dir string) error
+	// ID returns the identifier of the container once it is created
+	ID() string
+	// Kill stops the running command. Killing a command whose container was
+	// removed is not an error.
+	Kill() error
+	// Cleanup removes any resources created for the command. Cleaning up a
+	// command whose container was removed is not an error.
+	Cleanup() error
+}
+
+// ImageDigester is implemented by backends that pin images to digests. The digest is
+// reported in ProcessState.ImageDigest.
+type ImageDigester interface {
+	ImageDigest() string
+}
+
+// BackendFactory returns the Backend running the command described by config with
+// client. It returns ErrUnsupportedClient if client is not a client of the backend.
+type BackendFactory func(client interface{}, config Config) (Backend, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]BackendFactory)
+)
+
+// RegisterBackend makes a backend available to Command under name. It is intended
+// to be called from the init function of the package implementing the backend, and
+// panics if factory is nil or name is already registered.
+func RegisterBackend(name string, factory BackendFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if factory == nil {
+		panic("dexec: RegisterBackend factory is nil")
+	}
+	if _, dup := backends[name]; dup {
+		panic("dexec: RegisterBackend called twice for backend " + name)
+	}
+	backends[name] = factory
+}
+
+// Backends returns the sorted names of the registered backends.
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	return backendNames()
+}
+
+func backendNames() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newBackend returns the backend named by config.Backend, or else the first registered
+// backend, by name, that supports client
+func newBackend(client interface{}, config Config) (string, Backend, error) {
+	backendsMu.RLock()
+	names := []string{config.Backend}
+	if config.Backend == "" {
+		names = backendNames()
+	}
+	factories := make([]BackendFactory, len(names))
+	for i, name := range names {
+		factories[i] = backends[name]
+	}
+	backendsMu.RUnlock()
+
+	if config.Backend != "" {
+		if factories[0] == nil {
+			return "", nil, fmt.Errorf("dexec: unknown backend %q", config.Backend)
+		}
+		backend, err := factories[0](client, config)
+		return config.Backend, backend, err
+	}
+	for i, factory := range factories {
+		backend, err := factory(client, config)
+		if errors.Is(err, ErrUnsupportedClient) {
+			continue
+		}
+		return names[i], backend, err
+	}
+	return "", nil, fmt.Errorf("unsupported client type: %v", client)
+}
+
+// BackendClient is the ContainerClient of commands run by a Backend. Name is the name
+// the backend was registered with, if any.
+type BackendClient struct {
+	Name string
+}
+
+// BackendCmd represents a command run by a Backend.
+type BackendCmd struct {
+	*GenericCmd[BackendClient]
+}
+
+// Command returns the Cmd struct to execute the named program with given arguments
+// using backend.
+//
+// For each new Cmd, you should create a new Backend.
+func (b BackendClient) Command(backend Backend, name string, arg ...string) *BackendCmd {
+	return &BackendCmd{
+		GenericCmd: &GenericCmd[BackendClient]{
+			Path:   name,
+			Args:   arg,
+			Method: &backendExecution{backend: backend},
+			client: b,
+		},
+	}
+}
+
+// backendExecution adapts a Backend to Execution
+type backendExecution struct {
+	backend     Backend
+	transaction *newrelic.Transaction
+}
+
+func (e *backendExecution) create(_ BackendClient, cmd []string) error {
+	return e.backend.Create(cmd)
+}
+
+func (e *backendExecution) run(_ BackendClient, stdin io.Reader, stdout, stderr io.Writer) error {
+	return e.backend.Start(stdin, stdout, stderr)
+}
+
+func (e *backendExecution) wait(_ BackendClient, beforeRemove func() error) (int, error) {
+	return e.backend.Wait(beforeRemove)
+}
+
+func (e *backendExecution) copyIn(_ BackendClient, dst string, content io.Reader) error {
+	defer e.transaction.StartSegment("copyIn").End()
+	return e.backend.CopyIn(dst, content)
+}
+
+func (e *backendExecution) copyOut(_ BackendClient, src string, w io.Writer) error {
+	defer e.transaction.StartSegment("copyOut").End()
+	return e.backend.CopyOut(src, w)
+}
+
+func (e *backendExecution) setEnv(env []string) error {
+	return e.backend.SetEnv(env)
+}
+
+func (e *backendExecution) setDir(dir string) error {
+	return e.backend.SetDir(dir)
+}
+
+func (e *backendExecution) getID() string {
+	return e.backend.ID()
+}
+
+func (e *backendExecution) imageDigest() string {
+	if d, ok := e.backend.(ImageDigester); ok {
+		return d.ImageDigest()
+	}
+	return ""
+}
+
+func (e *backendExecution) kill(BackendClient) error {
+	return e.backend.Kill()
+}
+
+func (e *backendExecution) cleanup(BackendClient) error {
+	return e.backend.Cleanup()
+}
+
+func (e *backendExecution) setTransaction(txn *newrelic.Transaction) {
+	e.transaction = txn
+}
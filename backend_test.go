@@ -0,0 +1,113 @@
+package dexec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// echoClient is the client of echoBackend
+type echoClient struct{}
+
+// echoBackend writes the command and its environment to stdout
+type echoBackend struct {
+	env    []string
+	cmd    []string
+	id     string
+	exited chan struct{}
+}
+
+func (b *echoBackend) Create(cmd []string) error {
+	b.cmd = cmd
+	b.id = "echo-1"
+	return nil
+}
+
+func (b *echoBackend) Start(_ io.Reader, stdout, _ io.Writer) error {
+	b.exited = make(chan struct{})
+	go func() {
+		defer close(b.exited)
+		fmt.Fprint(stdout, strings.Join(append(b.env, b.cmd...), " "))
+	}()
+	return nil
+}
+
+func (b *echoBackend) Wait(beforeRemove func() error) (int, error) {
+	<-b.exited
+	if beforeRemove != nil {
+		return 0, beforeRemove()
+	}
+	return 0, nil
+}
+
+func (b *echoBackend) CopyIn(string, io.Reader) error  { return ErrNotSupported }
+func (b *echoBackend) CopyOut(string, io.Writer) error { return ErrNotSupported }
+func (b *echoBackend) SetEnv(env []string) error       { b.env = env; return nil }
+func (b *echoBackend) SetDir(string) error             { return ErrNotSupported }
+func (b *echoBackend) ID() string                      { return b.id }
+func (b *echoBackend) ImageDigest() string             { return "sha256:echo" }
+func (b *echoBackend) Kill() error                     { return nil }
+func (b *echoBackend) Cleanup() error                  { return nil }
+
+func init() {
+	RegisterBackend("echo", func(client interface{}, config Config) (Backend, error) {
+		if _, ok := client.(*echoClient); !ok {
+			return nil, ErrUnsupportedClient
+		}
+		if config.ContainerConfig.Image == "" {
+			return nil, errors.New("image is required")
+		}
+		return &echoBackend{}, nil
+	})
+}
+
+func TestRegisterBackend(t *testing.T) {
+	assert.Contains(t, Backends(), "echo")
+	assert.Panics(t, func() {
+		RegisterBackend("echo", func(interface{}, Config) (Backend, error) { return nil, nil })
+	})
+	assert.Panics(t, func() {
+		RegisterBackend("nil", nil)
+	})
+}
+
+func TestCommand_Backend(t *testing.T) {
+	config := Config{
+		ContainerConfig: ContainerConfig{Image: "busybox"},
+		TaskConfig:      TaskConfig{Executable: "echo", Args: []string{"hello"}},
+	}
+	cmd := Command(&echoClient{}, config)
+	assert.IsType(t, &BackendCmd{}, cmd)
+	assert.Equal(t, "echo", cmd.(*BackendCmd).client.Name)
+
+	cmd.(*BackendCmd).Env = []string{"A=1"}
+	out, err := cmd.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "A=1 echo hello", string(out))
+	assert.Equal(t, &ProcessState{ID: "echo-1", ImageDigest: "sha256:echo", Exited: true, ExitCode: 0}, cmd.(ProcessStateGetter).GetProcessState())
+
+	// unsupported operations fail the command
+	cmd = Command(&echoClient{}, config)
+	assert.NoError(t, cmd.(InputCopier).CopyIn("/", strings.NewReader("")))
+	assert.ErrorIs(t, cmd.Run(), ErrNotSupported)
+
+	// the factory validates the config
+	assert.PanicsWithError(t, "image is required", func() {
+		Command(&echoClient{}, Config{})
+	})
+}
+
+func TestCommand_BackendByName(t *testing.T) {
+	config := Config{ContainerConfig: ContainerConfig{Image: "busybox"}, Backend: "echo"}
+	assert.PanicsWithError(t, ErrUnsupportedClient.Error(), func() {
+		Command(&fakeClient{}, config)
+	})
+	config.Backend = "missing"
+	assert.PanicsWithError(t, `dexec: unknown backend "missing"`, func() {
+		Command(&echoClient{}, config)
+	})
+}
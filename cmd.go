@@ -2,11 +2,15 @@ package dexec
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"github.com/newrelic/go-agent/v3/newrelic"
 	"github.com/sirupsen/logrus"
 	"io"
 	"io/ioutil"
+	"os"
+	"path"
 	"time"
 )
 
@@ -71,6 +75,43 @@ type Cmd interface {
 	Cleanup() error
 }
 
+// InputCopier is implemented by the commands that can copy inputs into their container
+// before it is started. All the commands returned by dexec implement it:
+//
+//	if c, ok := cmd.(dexec.InputCopier); ok {
+//		err = c.CopyFileIn("testdata", "/input")
+//	}
+type InputCopier interface {
+	// CopyIn extracts the tar archive read from content into the directory dstPath
+	// of the container. The copy happens after the container is created and before
+	// it is started, so CopyIn must be called before Start.
+	CopyIn(dstPath string, content io.Reader) error
+	// CopyFileIn copies the host file or directory src to the path dst in the
+	// container. Like CopyIn, it must be called before Start.
+	CopyFileIn(src, dst string) error
+}
+
+// ArtifactCollector is implemented by the commands that can collect files from their
+// container after it exits. All the commands returned by dexec implement it.
+type ArtifactCollector interface {
+	// SetArtifacts sets the files collected from the container after the command exits
+	SetArtifacts(artifacts Artifacts)
+}
+
+// ProcessStateGetter is implemented by the commands that report the state of their
+// process. All the commands returned by dexec implement it.
+type ProcessStateGetter interface {
+	// GetProcessState returns the state of the command, or nil if it has not been started
+	GetProcessState() *ProcessState
+}
+
+var (
+	_ Cmd                = (*GenericCmd[Docker])(nil)
+	_ InputCopier        = (*GenericCmd[Docker])(nil)
+	_ ArtifactCollector  = (*GenericCmd[Docker])(nil)
+	_ ProcessStateGetter = (*GenericCmd[Docker])(nil)
+)
+
 type GenericCmd[T ContainerClient] struct {
 	// Path is the path or name of the command in the container.
 	Path string
@@ -99,17 +140,28 @@ type GenericCmd[T ContainerClient] struct {
 	//
 	// Run will not close the underlying handles if they are *os.File differently
 	// than os/exec.
-	Stdout         io.Writer
-	Stderr         io.Writer
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Artifacts lists files collected from the container after the command exits
+	// and before Wait removes the container.
+	Artifacts Artifacts
+
+	// ProcessState contains information about the command once it is started.
+	// Its exit code is set by Wait.
+	ProcessState *ProcessState
+
 	started        bool
 	Method         Execution[T]
 	closeAfterWait []io.Closer
+	copies         []pendingCopy
 	client         T
 	NewRelic       *newrelic.Application
+	secrets        *secretFiles
 }
 
 // Start starts the specified command but does not wait for it to complete.
-func (g *GenericCmd[T]) Start() error {
+func (g *GenericCmd[T]) Start() (err error) {
 	txn := g.NewRelic.StartTransaction("CommandStart")
 	defer txn.End()
 
@@ -135,6 +187,12 @@ func (g *GenericCmd[T]) Start() error {
 		return errors.New("dexec: already started")
 	}
 	g.started = true
+	defer func() {
+		// the secrets are not needed by a command that failed to start
+		if err != nil {
+			g.secrets.wipe()
+		}
+	}()
 
 	if g.Stdin == nil {
 		g.Stdin = empty
@@ -146,10 +204,21 @@ func (g *GenericCmd[T]) Start() error {
 		g.Stderr = ioutil.Discard
 	}
 
+	if err := g.secrets.write(context.Background()); err != nil {
+		return err
+	}
 	cmd := append([]string{g.Path}, g.Args...)
 	if err := g.create(txn, cmd); err != nil {
 		return err
 	}
+	g.ProcessState = &ProcessState{
+		ID:          g.Method.getID(),
+		ImageDigest: g.Method.imageDigest(),
+		ExitCode:    -1,
+	}
+	if err := g.copyIn(txn); err != nil {
+		return err
+	}
 	if err := g.run(txn); err != nil {
 		return err
 	}
@@ -161,6 +230,21 @@ func (g *GenericCmd[T]) create(txn *newrelic.Transaction, cmd []string) error {
 	return g.Method.create(g.client, cmd)
 }
 
+func (g *GenericCmd[T]) copyIn(txn *newrelic.Transaction) error {
+	defer txn.StartSegment("copyIn").End()
+	for _, c := range g.copies {
+		content := c.open()
+		err := g.Method.copyIn(g.client, c.dst, content)
+		if rc, ok := content.(io.Closer); ok {
+			rc.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("dexec: failed to copy content to %s: %w", c.dst, err)
+		}
+	}
+	return nil
+}
+
 func (g *GenericCmd[T]) run(txn *newrelic.Transaction) error {
 	defer txn.StartSegment("run").End()
 	return g.Method.run(g.client, g.Stdin, g.Stdout, g.Stderr)
@@ -180,7 +264,18 @@ func (g *GenericCmd[T]) Wait() error {
 	if !g.started {
 		return errors.New("dexec: not started")
 	}
-	ec, err := g.Method.wait(g.client)
+	var beforeRemove func() error
+	if len(g.Artifacts.Paths) > 0 {
+		beforeRemove = g.collectArtifacts
+	}
+	ec, err := g.Method.wait(g.client, beforeRemove)
+	if werr := g.secrets.wipe(); err == nil {
+		err = werr
+	}
+	if ec >= 0 && g.ProcessState != nil {
+		g.ProcessState.Exited = true
+		g.ProcessState.ExitCode = ec
+	}
 	if err != nil {
 		return err
 	}
@@ -300,6 +395,11 @@ func (g *GenericCmd[T]) GetPID() string {
 	return ""
 }
 
+// GetProcessState returns the state of the command, or nil if it has not been started
+func (g *GenericCmd[T]) GetProcessState() *ProcessState {
+	return g.ProcessState
+}
+
 // SetDir sets the working directory for the command
 func (g *GenericCmd[T]) SetDir(dir string) {
 	g.Dir = dir
@@ -310,6 +410,11 @@ func (g *GenericCmd[T]) SetStderr(writer io.Writer) {
 	g.Stderr = writer
 }
 
+// SetArtifacts sets the files collected from the container after the command exits
+func (g *GenericCmd[T]) SetArtifacts(artifacts Artifacts) {
+	g.Artifacts = artifacts
+}
+
 // Kill will stop a running container
 func (g *GenericCmd[T]) Kill() error {
 	if g.started {
@@ -319,9 +424,43 @@ func (g *GenericCmd[T]) Kill() error {
 	return nil
 }
 
+// CopyIn extracts the tar archive read from content into the directory dstPath
+// of the container. The copy happens after the container is created and before
+// it is started, so CopyIn must be called before Start.
+func (g *GenericCmd[T]) CopyIn(dstPath string, content io.Reader) error {
+	if g.started {
+		return errors.New("dexec: already started")
+	}
+	g.copies = append(g.copies, pendingCopy{
+		dst:  dstPath,
+		open: func() io.Reader { return content },
+	})
+	return nil
+}
+
+// CopyFileIn copies the host file or directory src to the path dst in the
+// container. Like CopyIn, it must be called before Start.
+func (g *GenericCmd[T]) CopyFileIn(src, dst string) error {
+	if g.started {
+		return errors.New("dexec: already started")
+	}
+	if _, err := os.Lstat(src); err != nil {
+		return err
+	}
+	g.copies = append(g.copies, pendingCopy{
+		dst:  path.Dir(dst),
+		open: func() io.Reader { return tarPath(src, path.Base(dst)) },
+	})
+	return nil
+}
+
 // Cleanup cleans up any resources that were created for the command
 func (g *GenericCmd[T]) Cleanup() error {
-	return g.Method.cleanup(g.client)
+	err := g.Method.cleanup(g.client)
+	if werr := g.secrets.wipe(); err == nil {
+		err = werr
+	}
+	return err
 }
 
 func closeFds(l []io.Closer) {
@@ -330,6 +469,12 @@ func closeFds(l []io.Closer) {
 	}
 }
 
+// pendingCopy is content queued by CopyIn or CopyFileIn until the container is created
+type pendingCopy struct {
+	dst  string
+	open func() io.Reader
+}
+
 type emptyReader struct{}
 
 func (r *emptyReader) Read(b []byte) (int, error) { return 0, io.EOF }
@@ -6,53 +6,278 @@ import (
 	"fmt"
 	"github.com/containerd/containerd"
 	docker "github.com/fsouza/go-dockerclient"
+	"github.com/newrelic/go-agent/v3/newrelic"
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
 )
 
+// Command returns the Cmd running the command described by config with client, which is a
+// *docker.Client, a *containerd.Client or the client of a registered Backend. It panics if
+// the command cannot be created; use NewCommand to validate config and get an error instead.
 func Command(client interface{}, config Config) Cmd {
+	if _, ok := client.(*containerd.Client); ok && config.Backend == "" && config.Namespace == "" {
+		panic(errors.New("config must must have namespace set"))
+	}
+	cmd, err := newCommand(client, config)
+	if err != nil {
+		panic(err)
+	}
+	return cmd
+}
+
+// Option sets a field of the Config of a command created with NewCommand.
+type Option func(*Config)
+
+// NewCommand returns the Cmd running the command described by the options with client,
+// like Command. The Config is validated first: if any field is invalid, the error is
+// ValidationErrors listing every invalid field.
+func NewCommand(client interface{}, opts ...Option) (Cmd, error) {
+	var config Config
+	for _, o := range opts {
+		o(&config)
+	}
+	if err := config.validate(client); err != nil {
+		return nil, err
+	}
+	return newCommand(client, config)
+}
+
+// WithConfig replaces the whole Config. Options after it override its fields.
+func WithConfig(config Config) Option {
+	return func(c *Config) {
+		*c = config
+	}
+}
+
+// WithImage sets ContainerConfig.Image.
+func WithImage(image string) Option {
+	return func(c *Config) {
+		c.ContainerConfig.Image = image
+	}
+}
+
+// WithCommand sets the executable and arguments of the command.
+func WithCommand(executable string, args ...string) Option {
+	return func(c *Config) {
+		c.TaskConfig.Executable = executable
+		c.TaskConfig.Args = args
+	}
+}
+
+// WithUser sets ContainerConfig.User.
+func WithUser(user string) Option {
+	return func(c *Config) {
+		c.ContainerConfig.User = user
+	}
+}
+
+// WithEnv adds KEY=VALUE pairs to ContainerConfig.Env.
+func WithEnv(env ...string) Option {
+	return func(c *Config) {
+		c.ContainerConfig.Env = append(c.ContainerConfig.Env, env...)
+	}
+}
+
+// WithMounts adds mounts to ContainerConfig.Mounts.
+func WithMounts(mounts ...Mount) Option {
+	return func(c *Config) {
+		c.ContainerConfig.Mounts = append(c.ContainerConfig.Mounts, mounts...)
+	}
+}
+
+// WithWorkingDir sets TaskConfig.WorkingDir.
+func WithWorkingDir(dir string) Option {
+	return func(c *Config) {
+		c.TaskConfig.WorkingDir = dir
+	}
+}
+
+// WithTimeout sets TaskConfig.Timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.TaskConfig.Timeout = timeout
+	}
+}
+
+// WithNamespace sets the containerd namespace.
+func WithNamespace(namespace string) Option {
+	return func(c *Config) {
+		c.Namespace = namespace
+	}
+}
+
+// WithBackend sets the name of the Backend running the command.
+func WithBackend(name string) Option {
+	return func(c *Config) {
+		c.Backend = name
+	}
+}
+
+// WithPolicy sets the Policy the config must comply with.
+func WithPolicy(policy Policy) Option {
+	return func(c *Config) {
+		c.Policy = policy
+	}
+}
+
+// WithArtifacts sets the files collected after the command exits.
+func WithArtifacts(artifacts Artifacts) Option {
+	return func(c *Config) {
+		c.Artifacts = artifacts
+	}
+}
+
+// WithWorkspace sets the scratch directory of the command.
+func WithWorkspace(workspace Workspace) Option {
+	return func(c *Config) {
+		c.Workspace = &workspace
+	}
+}
+
+// WithLogger sets the logger of the command.
+func WithLogger(logger *logrus.Entry) Option {
+	return func(c *Config) {
+		c.Logger = logger
+	}
+}
+
+// WithNewRelic sets the New Relic application the command is traced with.
+func WithNewRelic(app *newrelic.Application) Option {
+	return func(c *Config) {
+		c.NewRelic = app
+	}
+}
+
+func newCommand(client interface{}, config Config) (Cmd, error) {
+	config, err := loadEnvFiles(config)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkPolicy(config); err != nil {
+		return nil, err
+	}
+	secrets, mounts := newSecretFiles(config.Secrets)
+	config.ContainerConfig.Mounts = append(append([]Mount(nil), config.ContainerConfig.Mounts...), mounts...)
+	if config.Backend != "" {
+		return getBackendCommand(client, config, secrets)
+	}
 	switch c := client.(type) {
 	case *docker.Client:
-		dc := Docker{Client: c}
-		execution := getDockerExecution(config)
+		if secrets != nil && !isLocalEndpoint(c.Endpoint()) {
+			return nil, errRemoteDockerSecrets
+		}
+		dc := Docker{Client: c, IDs: config.IDGenerator}
+		execution, err := getDockerExecution(config)
+		if err != nil {
+			return nil, err
+		}
 		cmd := dc.Command(execution, config.TaskConfig.Executable, config.TaskConfig.Args...)
 		cmd.NewRelic = config.NewRelic
-		return cmd
+		cmd.Artifacts = config.Artifacts
+		cmd.secrets = secrets
+		cmd.copies = config.Workspace.copies()
+		return cmd, nil
 	case *containerd.Client:
-		if config.Namespace == "" {
-			panic(errors.New("config must must have namespace set"))
+		cdc := Containerd{ContainerdClient: c, Namespace: config.Namespace, IDs: config.IDGenerator}
+		execution, err := getContainerdExecution(config)
+		if err != nil {
+			return nil, err
 		}
-		cdc := Containerd{ContainerdClient: c, Namespace: config.Namespace}
-		execution := getContainerdExecution(config)
 		cmd := cdc.Command(execution, config.TaskConfig.Executable, config.TaskConfig.Args...)
 		cmd.NewRelic = config.NewRelic
-		return cmd
+		cmd.Artifacts = config.Artifacts
+		cmd.secrets = secrets
+		cmd.copies = config.Workspace.copies()
+		return cmd, nil
 	default:
-		panic(fmt.Errorf("unsupported client type: %v", c))
+		return getBackendCommand(c, config, secrets)
 	}
 }
 
-func getDockerExecution(config Config) Execution[Docker] {
-	exec, _ := ByCreatingContainer(docker.CreateContainerOptions{
+func getBackendCommand(client interface{}, config Config, secrets *secretFiles) (Cmd, error) {
+	if config.Workspace != nil {
+		return nil, errWorkspaceUnsupported
+	}
+	name, backend, err := newBackend(client, config)
+	if err != nil {
+		return nil, err
+	}
+	cmd := BackendClient{Name: name}.Command(backend, config.TaskConfig.Executable, config.TaskConfig.Args...)
+	cmd.NewRelic = config.NewRelic
+	cmd.Artifacts = config.Artifacts
+	cmd.secrets = secrets
+	return cmd, nil
+}
+
+func getImageOptions(config Config) ImageOptions {
+	return ImageOptions{
+		PullPolicy:    config.ContainerConfig.PullPolicy,
+		Progress:      config.ContainerConfig.PullProgress,
+		Auth:          config.ContainerConfig.RegistryAuth,
+		Digest:        config.ContainerConfig.ImageDigest,
+		ResolveDigest: config.ContainerConfig.ResolveDigest,
+		RequireDigest: config.ContainerConfig.RequireDigest,
+	}
+}
+
+func getDockerExecution(config Config) (Execution[Docker], error) {
+	if config.ContainerConfig.RuntimeOptions != (RuntimeOptions{}) {
+		return nil, errRuntimeOptionsUnsupported
+	}
+	if len(config.ContainerConfig.Annotations) > 0 {
+		return nil, errAnnotationsUnsupported
+	}
+	err := checkMounts(config.ContainerConfig.Mounts, mountSupport{volumes: true, relabel: true, noCopy: true})
+	if err != nil {
+		return nil, err
+	}
+	mounts, binds := dockerMounts(config.ContainerConfig.Mounts)
+	var prefix string
+	if config.NameTemplate != "" {
+		if prefix, err = configNamePrefix(config); err != nil {
+			return nil, err
+		}
+	}
+	return ByCreatingContainer(docker.CreateContainerOptions{
 		Config: &docker.Config{
 			Image:        config.ContainerConfig.Image,
 			AttachStdout: true,
 			AttachStderr: true,
 			User:         config.ContainerConfig.User,
 			Env:          config.ContainerConfig.Env,
+			WorkingDir:   config.TaskConfig.WorkingDir,
+			Labels:       commandLabels(config.ContainerConfig.Labels, config.ContainerConfig.Owner, config.Metadata, config.CommandDetails),
 		},
 		HostConfig: &docker.HostConfig{
 			DNS:        config.NetworkConfig.DNS,
 			DNSSearch:  config.NetworkConfig.DNSSearch,
 			DNSOptions: config.NetworkConfig.DNSOptions,
-			Mounts:     convertMounts[docker.HostMount](config.ContainerConfig.Mounts),
+			Mounts:     mounts,
+			Binds:      binds,
+
+			CapAdd:         config.ContainerConfig.Security.CapAdd,
+			CapDrop:        config.ContainerConfig.Security.CapDrop,
+			SecurityOpt:    config.ContainerConfig.Security.dockerSecurityOpt(),
+			ReadonlyRootfs: config.ContainerConfig.Security.ReadOnlyRootfs,
+			MaskedPaths:    config.ContainerConfig.Security.maskedPaths(),
+			Runtime:        config.ContainerConfig.Runtime,
 		},
 		Context: context.Background(),
-	})
-	return exec
+	}, WithImageOptions(getImageOptions(config)), WithEnvMerge(config.ContainerConfig.EnvMerge), WithDirMerge(config.TaskConfig.DirMerge),
+		WithWorkspaceVolume(config.Workspace), WithNamePrefix(prefix), WithCommandTimeout(config.TaskConfig.Timeout))
 }
 
-func getContainerdExecution(config Config) Execution[Containerd] {
-	exec, _ := ByCreatingTask(CreateTaskOptions{
+func getContainerdExecution(config Config) (Execution[Containerd], error) {
+	if err := checkMounts(config.ContainerConfig.Mounts, mountSupport{volumes: true}); err != nil {
+		return nil, err
+	}
+	prefix, err := configNamePrefix(config)
+	if err != nil {
+		return nil, err
+	}
+	return ByCreatingTask(CreateTaskOptions{
 		Image:          config.ContainerConfig.Image,
 		Mounts:         convertMounts[specs.Mount](config.ContainerConfig.Mounts),
 		User:           config.ContainerConfig.User,
@@ -60,8 +285,19 @@ func getContainerdExecution(config Config) Execution[Containerd] {
 		CommandTimeout: config.TaskConfig.Timeout,
 		WorkingDir:     config.TaskConfig.WorkingDir,
 		CommandDetails: config.CommandDetails,
+		Metadata:       config.Metadata,
+		NamePrefix:     prefix,
+		ImageOptions:   getImageOptions(config),
+		EnvMerge:       config.ContainerConfig.EnvMerge,
+		DirMerge:       config.TaskConfig.DirMerge,
+		Security:       config.ContainerConfig.Security,
+		Runtime:        config.ContainerConfig.Runtime,
+		RuntimeOptions: config.ContainerConfig.RuntimeOptions,
+		Labels:         config.ContainerConfig.Labels,
+		Annotations:    config.ContainerConfig.Annotations,
+		Owner:          config.ContainerConfig.Owner,
+		Workspace:      config.Workspace,
 	}, config.Logger)
-	return exec
 }
 
 type mountable interface {
@@ -76,6 +312,7 @@ func convertMounts[T mountable](ms []Mount) []T {
 	return mounts
 }
 func convertMount[T mountable](m Mount) T {
+	m = m.resolved()
 	var res T
 	switch v := any(&res).(type) {
 	case *docker.HostMount:
@@ -83,24 +320,47 @@ func convertMount[T mountable](m Mount) T {
 			Type:     m.Type,
 			Source:   m.Source,
 			Target:   m.Destination,
-			ReadOnly: isReadOnly(m),
+			ReadOnly: m.ReadOnly,
+		}
+		if m.Propagation != "" {
+			v.BindOptions = &docker.BindOptions{Propagation: m.Propagation}
+		}
+		if m.NoCopy {
+			v.VolumeOptions = &docker.VolumeOptions{NoCopy: true}
+		}
+		if m.TmpfsSize != 0 || m.TmpfsMode != 0 {
+			v.TempfsOptions = &docker.TempfsOptions{SizeBytes: m.TmpfsSize, Mode: int(m.TmpfsMode)}
 		}
 	case *specs.Mount:
 		*v = specs.Mount{
 			Type:        m.Type,
 			Source:      m.Source,
 			Destination: m.Destination,
-			Options:     m.Options,
+			Options:     m.ociOptions(),
+		}
+		if m.Type == MountTmpfs {
+			v.Source = MountTmpfs
 		}
 	}
 	return res
 }
 
-func isReadOnly(m Mount) bool {
-	for _, opt := range m.Options {
-		if opt == "ro" {
-			return true
+// dockerMounts converts mounts to the mounts of a Docker container. Bind mounts relabeled for SELinux are
+// returned as binds, since the mounts of the Docker API cannot be relabeled.
+func dockerMounts(ms []Mount) ([]docker.HostMount, []string) {
+	mounts := []docker.HostMount{}
+	var binds []string
+	for _, m := range ms {
+		if r := m.resolved(); r.SELinuxRelabel != "" {
+			r.Options = nil
+			binds = append(binds, fmt.Sprintf("%s:%s:%s", r.Source, r.Destination, strings.Join(r.ociOptions(), ",")))
+			continue
 		}
+		mounts = append(mounts, convertMount[docker.HostMount](m))
 	}
-	return false
+	return mounts, binds
+}
+
+func isReadOnly(m Mount) bool {
+	return m.resolved().ReadOnly
 }
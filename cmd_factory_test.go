@@ -1,6 +1,7 @@
 package dexec
 
 import (
+	"errors"
 	"github.com/containerd/containerd"
 	docker "github.com/fsouza/go-dockerclient"
 	"github.com/opencontainers/runtime-spec/specs-go"
@@ -50,3 +51,27 @@ func TestCommand(t *testing.T) {
 		Command(&fakeClient{}, Config{})
 	})
 }
+
+func TestNewCommand(t *testing.T) {
+	cmd, err := NewCommand(&docker.Client{}, WithImage("busybox"), WithCommand("echo", "hello"), WithEnv("A=1"), WithWorkingDir("/work"))
+	assert.NoError(t, err)
+	assert.IsType(t, &DockerCmd{}, cmd)
+	assert.Equal(t, "echo", cmd.(*DockerCmd).Path)
+	assert.Equal(t, []string{"hello"}, cmd.(*DockerCmd).Args)
+
+	cmd, err = NewCommand(&containerd.Client{}, WithConfig(Config{Namespace: "unit-test"}), WithImage("busybox"), WithCommand("echo"))
+	assert.NoError(t, err)
+	assert.IsType(t, &ContainerdCmd{}, cmd)
+
+	cmd, err = NewCommand(&Local{}, WithCommand("echo"), WithWorkingDir("."))
+	assert.NoError(t, err)
+	assert.IsType(t, &BackendCmd{}, cmd)
+
+	_, err = NewCommand(&containerd.Client{}, WithImage("busybox"), WithCommand("echo"))
+	var verrs ValidationErrors
+	assert.True(t, errors.As(err, &verrs))
+	assert.Equal(t, "Namespace", verrs[0].Field)
+
+	_, err = NewCommand(&fakeClient{}, WithImage("busybox"), WithCommand("echo"))
+	assert.EqualError(t, err, "unsupported client type: &{}")
+}
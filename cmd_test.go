@@ -41,7 +41,7 @@ type CmdTestSuite struct {
 }
 
 func (s *CmdTestSuite) SetUpSuite(c *C) {
-	s.d = dexec.Docker{testDocker(c)}
+	s.d = dexec.Docker{Client: testDocker(c)}
 	err := s.d.PullImage(docker.PullImageOptions{Repository: "busybox", Tag: "latest"}, docker.AuthConfiguration{})
 	c.Assert(err, IsNil)
 	cleanupContainers(c, s.d)
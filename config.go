@@ -2,7 +2,9 @@ package dexec
 
 import (
 	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/opencontainers/go-digest"
 	"github.com/sirupsen/logrus"
+	"io"
 	"time"
 )
 
@@ -11,23 +13,118 @@ type Config struct {
 	NetworkConfig   NetworkConfig
 	TaskConfig      TaskConfig
 	CommandDetails  CommandDetails
+	Artifacts       Artifacts
 	Logger          *logrus.Entry
 	NewRelic        *newrelic.Application
 	Namespace       string
+	// Metadata describes the command more generally than CommandDetails, e.g. with
+	// the IDs of the application running it. Each pair is a label of the container,
+	// with its key prefixed with MetadataLabelPrefix, which GetStatsBy groups
+	// containers by.
+	Metadata map[string]string
+	// NameTemplate is the text/template of the name of the container, executed with
+	// Metadata and the executorId, chainExecutorId and resultId of CommandDetails. The
+	// name is sanitized to the name rules of the backends and a random suffix is
+	// appended. If empty, DefaultNameTemplate names containerd containers, and Docker and
+	// Podman leave their containers unnamed.
+	NameTemplate string
+	// IDGenerator generates the unique suffix of the name of the container, or of the
+	// bundle of runc and the process of Local. If nil, DefaultIDGenerator is used.
+	IDGenerator IDGenerator
+	// Secrets are passed to the command as files. See Secrets.
+	Secrets Secrets
+	// Workspace, if not nil, is a scratch directory of the command. See Workspace.
+	Workspace *Workspace
+	// Policy, if not nil, decides whether the command may be created with the config.
+	// See PolicyRules.
+	Policy Policy
+	// Backend is the name of the registered Backend running the command. If empty, the
+	// backend is chosen by the type of the client. See RegisterBackend.
+	Backend string
 }
 
+// Mount is a mount of the container of a command. Type is MountBind, the default,
+// MountVolume or MountTmpfs. Source is the host path of a bind mount and the name of a
+// volume. Options are mount options passed as is to containerd, Podman and runc; the
+// options that have a field below, like "ro" or "rshared", are translated for every
+// backend. Docker ignores the other options, like it always did: its bind mounts are
+// always recursive, so "bind" and "rbind" make no difference.
 type Mount struct {
 	Type        string
 	Source      string
 	Destination string
 	Options     []string
+	// ReadOnly mounts the mount read-only, like the "ro" option
+	ReadOnly bool
+	// Propagation is the propagation of a bind mount: private, rprivate, shared,
+	// rshared, slave or rslave
+	Propagation string
+	// SELinuxRelabel relabels the source of a bind mount, "z" to share it between
+	// containers or "Z" to make it private to the container
+	SELinuxRelabel string
+	// NoCopy does not copy the content of the image at the destination into a new volume
+	NoCopy bool
+	// TmpfsSize is the size of a tmpfs mount in bytes, unlimited if zero
+	TmpfsSize int64
+	// TmpfsMode is the mode of the root of a tmpfs mount in octal, e.g. 01777. The
+	// default of the backend is used if zero.
+	TmpfsMode uint32
 }
 
 type ContainerConfig struct {
-	Image  string
-	User   string
-	Env    []string
-	Mounts []Mount
+	Image string
+	// User is the user[:group] running the command, as names or numeric IDs resolved
+	// from the /etc/passwd and /etc/group of the image like Docker does. If empty, the
+	// user of the image is used.
+	User string
+	Env  []string
+	// EnvFiles are env files whose variables are added to Env when the command is
+	// created. Variables of Env override them. See ReadEnvFile.
+	EnvFiles []string
+	// EnvMerge determines how Cmd.Env is merged with Env. See MergeMode.
+	EnvMerge MergeMode
+	Mounts   []Mount
+	// PullPolicy determines when Image is pulled. See ImageOptions.
+	PullPolicy PullPolicy
+	// PullProgress, if not nil, receives progress messages while Image is pulled
+	PullProgress io.Writer
+	// RegistryAuth, if not nil, provides the credentials used to pull Image
+	RegistryAuth *RegistryAuth
+	// ImageDigest, ResolveDigest and RequireDigest pin Image to a digest. See ImageOptions.
+	ImageDigest   digest.Digest
+	ResolveDigest bool
+	RequireDigest bool
+	// Resources limits the resources of the container. It is applied by the runc backend.
+	Resources Resources
+	// Security hardens the container. See Security and SandboxedSecurity.
+	Security Security
+	// Runtime is the OCI runtime running the container. Docker and Podman use the runtime
+	// configured with this name, e.g. runsc for gVisor. containerd passes it to
+	// `nerdctl create --runtime`, so it is a shim like io.containerd.kata.v2 or a runc
+	// compatible binary like crun. runc runs it instead of Runc.Binary. If empty, the
+	// default runtime of the backend is used. See RuntimeOptions.
+	Runtime string
+	// RuntimeOptions are options specific to Runtime
+	RuntimeOptions RuntimeOptions
+	// Labels are added to the labels of the container. The labels set by dexec, like
+	// the owner label, cannot be overridden.
+	Labels map[string]string
+	// Annotations are OCI annotations of the container, passed to the runtime. Docker
+	// fails to create commands with annotations.
+	Annotations map[string]string
+	// Owner is the value of the wk/owner label of the container, which GetStats
+	// counts containers by. If empty, DefaultOwner is used.
+	Owner string
+}
+
+// Resources are the resource limits of a container. Zero values mean no limit.
+type Resources struct {
+	// Memory is the memory limit in bytes
+	Memory int64
+	// CPUs is the number of CPUs the container may use, e.g. 1.5
+	CPUs float64
+	// PidsLimit is the maximum number of processes in the container
+	PidsLimit int64
 }
 
 type TaskConfig struct {
@@ -35,6 +132,8 @@ type TaskConfig struct {
 	Args       []string
 	Timeout    time.Duration
 	WorkingDir string
+	// DirMerge determines how Cmd.Dir is merged with WorkingDir. See MergeMode.
+	DirMerge MergeMode
 }
 
 type NetworkConfig struct {
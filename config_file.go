@@ -0,0 +1,565 @@
+package dexec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFile is a file of named profiles of Config, written in YAML or JSON:
+//
+//	defaults:
+//	  namespace: builds
+//	  container:
+//	    image: golang:1.21
+//	    env: ["GOFLAGS=-mod=mod"]
+//	profiles:
+//	  test:
+//	    task:
+//	      executable: go
+//	      args: ["test", "./..."]
+//	      timeout: 10m
+//	  race:
+//	    extends: test
+//	    task:
+//	      args: ["test", "-race", "./..."]
+//
+// A profile inherits the defaults and the profile it extends, and overrides the fields
+// it sets; lists replace the inherited lists and maps are merged with the inherited
+// maps. String values may refer to environment variables as $VAR, ${VAR} or
+// ${VAR:-default}, and $$ is a literal $. Unknown fields are an error. See ConfigSchema
+// for the JSON schema of the file.
+type ConfigFile struct {
+	// Defaults are inherited by every profile
+	Defaults ConfigProfile `json:"defaults"`
+	// Profiles are the profiles by name
+	Profiles map[string]ConfigProfile `json:"profiles"`
+}
+
+// ConfigProfile is a profile of a ConfigFile. Fields that are nil are inherited.
+type ConfigProfile struct {
+	// Extends is the name of the profile this profile inherits from
+	Extends   string           `json:"extends,omitempty"`
+	Namespace *string          `json:"namespace,omitempty"`
+	Backend   *string          `json:"backend,omitempty"`
+	Container ContainerProfile `json:"container"`
+	Network   NetworkProfile   `json:"network"`
+	Task      TaskProfile      `json:"task"`
+	// Metadata and NameTemplate are Config.Metadata and Config.NameTemplate
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	NameTemplate *string           `json:"nameTemplate,omitempty"`
+	// Secrets is Config.Secrets
+	Secrets SecretsProfile `json:"secrets"`
+	// Workspace is Config.Workspace, set when any of its fields is set
+	Workspace WorkspaceProfile `json:"workspace"`
+}
+
+// ContainerProfile is the ContainerConfig of a ConfigProfile
+type ContainerProfile struct {
+	Image         *string           `json:"image,omitempty"`
+	User          *string           `json:"user,omitempty"`
+	Env           []string          `json:"env,omitempty"`
+	EnvFiles      []string          `json:"envFiles,omitempty"`
+	EnvMerge      *MergeMode        `json:"envMerge,omitempty"`
+	Mounts        []MountProfile    `json:"mounts,omitempty"`
+	PullPolicy    *PullPolicy       `json:"pullPolicy,omitempty"`
+	ImageDigest   *digest.Digest    `json:"imageDigest,omitempty"`
+	ResolveDigest *bool             `json:"resolveDigest,omitempty"`
+	RequireDigest *bool             `json:"requireDigest,omitempty"`
+	Resources     ResourcesProfile  `json:"resources"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+	Owner         *string           `json:"owner,omitempty"`
+	// Security is ContainerConfig.Security
+	Security SecurityProfile `json:"security"`
+	// Runtime and RuntimeOptions are ContainerConfig.Runtime and ContainerConfig.RuntimeOptions
+	Runtime        *string               `json:"runtime,omitempty"`
+	RuntimeOptions RuntimeOptionsProfile `json:"runtimeOptions"`
+}
+
+// MountProfile is a Mount of a ContainerProfile. The size and mode of a tmpfs mount are
+// set with the size and mode options, e.g. size=64m and mode=1777.
+type MountProfile struct {
+	Type           string   `json:"type,omitempty"`
+	Source         string   `json:"source,omitempty"`
+	Destination    string   `json:"destination"`
+	Options        []string `json:"options,omitempty"`
+	ReadOnly       bool     `json:"readOnly,omitempty"`
+	Propagation    string   `json:"propagation,omitempty"`
+	SELinuxRelabel string   `json:"selinuxRelabel,omitempty"`
+	NoCopy         bool     `json:"noCopy,omitempty"`
+}
+
+// ResourcesProfile is the Resources of a ContainerProfile
+type ResourcesProfile struct {
+	Memory    *int64   `json:"memory,omitempty"`
+	CPUs      *float64 `json:"cpus,omitempty"`
+	PidsLimit *int64   `json:"pidsLimit,omitempty"`
+}
+
+// SecurityProfile is the Security of a ContainerProfile. The seccomp profile is the
+// content of the profile, which may be written as a YAML block scalar.
+type SecurityProfile struct {
+	CapAdd          []string `json:"capAdd,omitempty"`
+	CapDrop         []string `json:"capDrop,omitempty"`
+	SeccompProfile  *string  `json:"seccompProfile,omitempty"`
+	AppArmorProfile *string  `json:"appArmorProfile,omitempty"`
+	NoNewPrivileges *bool    `json:"noNewPrivileges,omitempty"`
+	ReadOnlyRootfs  *bool    `json:"readOnlyRootfs,omitempty"`
+	MaskedPaths     []string `json:"maskedPaths,omitempty"`
+}
+
+// RuntimeOptionsProfile is the RuntimeOptions of a ContainerProfile
+type RuntimeOptionsProfile struct {
+	ConfigPath    *string `json:"configPath,omitempty"`
+	SystemdCgroup *bool   `json:"systemdCgroup,omitempty"`
+}
+
+// NetworkProfile is the NetworkConfig of a ConfigProfile
+type NetworkProfile struct {
+	DNS        []string `json:"dns,omitempty"`
+	DNSSearch  []string `json:"dnsSearch,omitempty"`
+	DNSOptions []string `json:"dnsOptions,omitempty"`
+}
+
+// TaskProfile is the TaskConfig of a ConfigProfile
+type TaskProfile struct {
+	Executable *string    `json:"executable,omitempty"`
+	Args       []string   `json:"args,omitempty"`
+	Timeout    *Duration  `json:"timeout,omitempty"`
+	WorkingDir *string    `json:"workingDir,omitempty"`
+	DirMerge   *MergeMode `json:"dirMerge,omitempty"`
+}
+
+// SecretsProfile is the Secrets of a ConfigProfile. The secrets are read from the
+// environment variables named EnvPrefix followed by their name, see EnvSecrets. Other
+// SecretSources cannot be written in a file: leave EnvPrefix unset and set
+// Config.Secrets.Source once the Config is loaded.
+type SecretsProfile struct {
+	Files     []SecretProfile `json:"files,omitempty"`
+	EnvPrefix *string         `json:"envPrefix,omitempty"`
+	Dir       *string         `json:"dir,omitempty"`
+}
+
+// SecretProfile is a Secret of a SecretsProfile
+type SecretProfile struct {
+	Name string   `json:"name"`
+	Path string   `json:"path"`
+	Mode FileMode `json:"mode,omitempty"`
+	UID  int      `json:"uid,omitempty"`
+	GID  int      `json:"gid,omitempty"`
+}
+
+// WorkspaceProfile is the Workspace of a ConfigProfile. The Content of a Workspace is
+// a stream, so it cannot be written in a file.
+type WorkspaceProfile struct {
+	Path   *string `json:"path,omitempty"`
+	Size   *int64  `json:"size,omitempty"`
+	Source *string `json:"source,omitempty"`
+}
+
+// FileMode is an os.FileMode written as an octal string like "0440" in a ConfigFile
+type FileMode os.FileMode
+
+func (m FileMode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("%#o", uint32(m)))
+}
+
+func (m *FileMode) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("mode must be an octal string like \"0440\": %w", err)
+	}
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || v > 0777 {
+		return fmt.Errorf("invalid mode %q: must be an octal string like \"0440\"", s)
+	}
+	*m = FileMode(v)
+	return nil
+}
+
+// Duration is a time.Duration written as a string like "1m30s" in a ConfigFile
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"1m30s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// LoadConfig reads the ConfigFile at path and returns the Config of profile. If profile
+// is empty, the Config has only the defaults of the file.
+func LoadConfig(path, profile string) (Config, error) {
+	f, err := ReadConfigFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	return f.Config(profile)
+}
+
+// ReadConfigFile reads and parses the ConfigFile at path
+func ReadConfigFile(path string) (*ConfigFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("dexec: failed to read config file: %w", err)
+	}
+	f, err := ParseConfigFile(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w (%s)", err, path)
+	}
+	return f, nil
+}
+
+// ParseConfigFile parses a ConfigFile written in YAML or JSON and interpolates the
+// environment variables in its string values.
+func ParseConfigFile(data []byte) (*ConfigFile, error) {
+	// JSON is YAML, so both are decoded as YAML and checked against the JSON tags of ConfigFile
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("dexec: invalid config file: %w", err)
+	}
+	doc, err := interpolate(doc, "")
+	if err != nil {
+		return nil, fmt.Errorf("dexec: invalid config file: %w", err)
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("dexec: invalid config file: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	var f ConfigFile
+	if err = dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("dexec: invalid config file: %w", err)
+	}
+	return &f, nil
+}
+
+// interpolate expands the environment variables in the string values of doc, at path
+func interpolate(doc interface{}, path string) (interface{}, error) {
+	switch v := doc.(type) {
+	case string:
+		s, err := expandEnv(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", strings.TrimPrefix(path, "."), err)
+		}
+		return s, nil
+	case []interface{}:
+		for i := range v {
+			e, err := interpolate(v[i], fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			v[i] = e
+		}
+	case map[string]interface{}:
+		for k := range v {
+			e, err := interpolate(v[k], path+"."+k)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = e
+		}
+	}
+	return doc, nil
+}
+
+// expandEnv expands $VAR, ${VAR} and ${VAR:-default} in s. A variable that is not set
+// and has no default is an error.
+func expandEnv(s string) (string, error) {
+	var err error
+	expanded := os.Expand(s, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		name, def, hasDef := strings.Cut(name, ":-")
+		if v, ok := os.LookupEnv(name); ok && (v != "" || !hasDef) {
+			return v
+		}
+		if hasDef {
+			return def
+		}
+		if err == nil {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return ""
+	})
+	return expanded, err
+}
+
+// Config returns the Config of profile, which inherits the defaults of the file and the
+// profiles it extends. If profile is empty, the Config has only the defaults.
+func (f *ConfigFile) Config(profile string) (Config, error) {
+	p := f.Defaults
+	if profile != "" {
+		resolved, err := f.resolve(profile, nil)
+		if err != nil {
+			return Config{}, err
+		}
+		p = mergeProfiles(p, resolved)
+	}
+	var c Config
+	p.apply(&c)
+	return c, nil
+}
+
+// resolve returns profile merged with the profiles it extends. chain is the profiles extending it.
+func (f *ConfigFile) resolve(profile string, chain []string) (ConfigProfile, error) {
+	for _, name := range chain {
+		if name == profile {
+			return ConfigProfile{}, fmt.Errorf("dexec: profiles extend each other: %s -> %s", strings.Join(chain, " -> "), profile)
+		}
+	}
+	p, ok := f.Profiles[profile]
+	if !ok {
+		return ConfigProfile{}, fmt.Errorf("dexec: unknown profile %q", profile)
+	}
+	if p.Extends == "" {
+		return p, nil
+	}
+	base, err := f.resolve(p.Extends, append(chain, profile))
+	if err != nil {
+		return ConfigProfile{}, err
+	}
+	return mergeProfiles(base, p), nil
+}
+
+// mergeProfiles returns base with the fields set in p
+func mergeProfiles(base, p ConfigProfile) ConfigProfile {
+	override(&base.Namespace, p.Namespace)
+	override(&base.Backend, p.Backend)
+	base.Metadata = mergeMap(base.Metadata, p.Metadata)
+	override(&base.NameTemplate, p.NameTemplate)
+	overrideList(&base.Secrets.Files, p.Secrets.Files)
+	override(&base.Secrets.EnvPrefix, p.Secrets.EnvPrefix)
+	override(&base.Secrets.Dir, p.Secrets.Dir)
+	override(&base.Workspace.Path, p.Workspace.Path)
+	override(&base.Workspace.Size, p.Workspace.Size)
+	override(&base.Workspace.Source, p.Workspace.Source)
+
+	bc, pc := &base.Container, p.Container
+	override(&bc.Image, pc.Image)
+	override(&bc.User, pc.User)
+	overrideList(&bc.Env, pc.Env)
+	overrideList(&bc.EnvFiles, pc.EnvFiles)
+	override(&bc.EnvMerge, pc.EnvMerge)
+	overrideList(&bc.Mounts, pc.Mounts)
+	override(&bc.PullPolicy, pc.PullPolicy)
+	override(&bc.ImageDigest, pc.ImageDigest)
+	override(&bc.ResolveDigest, pc.ResolveDigest)
+	override(&bc.RequireDigest, pc.RequireDigest)
+	override(&bc.Resources.Memory, pc.Resources.Memory)
+	override(&bc.Resources.CPUs, pc.Resources.CPUs)
+	override(&bc.Resources.PidsLimit, pc.Resources.PidsLimit)
+	bc.Labels = mergeMap(bc.Labels, pc.Labels)
+	bc.Annotations = mergeMap(bc.Annotations, pc.Annotations)
+	override(&bc.Owner, pc.Owner)
+	bs, ps := &bc.Security, pc.Security
+	overrideList(&bs.CapAdd, ps.CapAdd)
+	overrideList(&bs.CapDrop, ps.CapDrop)
+	override(&bs.SeccompProfile, ps.SeccompProfile)
+	override(&bs.AppArmorProfile, ps.AppArmorProfile)
+	override(&bs.NoNewPrivileges, ps.NoNewPrivileges)
+	override(&bs.ReadOnlyRootfs, ps.ReadOnlyRootfs)
+	overrideList(&bs.MaskedPaths, ps.MaskedPaths)
+	override(&bc.Runtime, pc.Runtime)
+	override(&bc.RuntimeOptions.ConfigPath, pc.RuntimeOptions.ConfigPath)
+	override(&bc.RuntimeOptions.SystemdCgroup, pc.RuntimeOptions.SystemdCgroup)
+
+	overrideList(&base.Network.DNS, p.Network.DNS)
+	overrideList(&base.Network.DNSSearch, p.Network.DNSSearch)
+	overrideList(&base.Network.DNSOptions, p.Network.DNSOptions)
+
+	bt, pt := &base.Task, p.Task
+	override(&bt.Executable, pt.Executable)
+	overrideList(&bt.Args, pt.Args)
+	override(&bt.Timeout, pt.Timeout)
+	override(&bt.WorkingDir, pt.WorkingDir)
+	override(&bt.DirMerge, pt.DirMerge)
+	return base
+}
+
+func override[T any](dst **T, src *T) {
+	if src != nil {
+		*dst = src
+	}
+}
+
+func overrideList[T any](dst *[]T, src []T) {
+	if src != nil {
+		*dst = src
+	}
+}
+
+// apply sets the fields of c that are set in p
+func (p ConfigProfile) apply(c *Config) {
+	set(&c.Namespace, p.Namespace)
+	set(&c.Backend, p.Backend)
+	c.Metadata = p.Metadata
+	set(&c.NameTemplate, p.NameTemplate)
+	for _, s := range p.Secrets.Files {
+		c.Secrets.Files = append(c.Secrets.Files, Secret{Name: s.Name, Path: s.Path, Mode: os.FileMode(s.Mode), UID: s.UID, GID: s.GID})
+	}
+	if p.Secrets.EnvPrefix != nil {
+		c.Secrets.Source = EnvSecrets{Prefix: *p.Secrets.EnvPrefix}
+	}
+	set(&c.Secrets.Dir, p.Secrets.Dir)
+	if w := p.Workspace; w.Path != nil || w.Size != nil || w.Source != nil {
+		c.Workspace = &Workspace{}
+		set(&c.Workspace.Path, w.Path)
+		set(&c.Workspace.Size, w.Size)
+		set(&c.Workspace.Source, w.Source)
+	}
+
+	cc, pc := &c.ContainerConfig, p.Container
+	set(&cc.Image, pc.Image)
+	set(&cc.User, pc.User)
+	cc.Env = pc.Env
+	cc.EnvFiles = pc.EnvFiles
+	set(&cc.EnvMerge, pc.EnvMerge)
+	for _, m := range pc.Mounts {
+		cc.Mounts = append(cc.Mounts, Mount{
+			Type:           m.Type,
+			Source:         m.Source,
+			Destination:    m.Destination,
+			Options:        m.Options,
+			ReadOnly:       m.ReadOnly,
+			Propagation:    m.Propagation,
+			SELinuxRelabel: m.SELinuxRelabel,
+			NoCopy:         m.NoCopy,
+		})
+	}
+	set(&cc.PullPolicy, pc.PullPolicy)
+	set(&cc.ImageDigest, pc.ImageDigest)
+	set(&cc.ResolveDigest, pc.ResolveDigest)
+	set(&cc.RequireDigest, pc.RequireDigest)
+	set(&cc.Resources.Memory, pc.Resources.Memory)
+	set(&cc.Resources.CPUs, pc.Resources.CPUs)
+	set(&cc.Resources.PidsLimit, pc.Resources.PidsLimit)
+	cc.Labels = pc.Labels
+	cc.Annotations = pc.Annotations
+	set(&cc.Owner, pc.Owner)
+	cs, ps := &cc.Security, pc.Security
+	cs.CapAdd = ps.CapAdd
+	cs.CapDrop = ps.CapDrop
+	set(&cs.SeccompProfile, ps.SeccompProfile)
+	set(&cs.AppArmorProfile, ps.AppArmorProfile)
+	set(&cs.NoNewPrivileges, ps.NoNewPrivileges)
+	set(&cs.ReadOnlyRootfs, ps.ReadOnlyRootfs)
+	cs.MaskedPaths = ps.MaskedPaths
+	set(&cc.Runtime, pc.Runtime)
+	set(&cc.RuntimeOptions.ConfigPath, pc.RuntimeOptions.ConfigPath)
+	set(&cc.RuntimeOptions.SystemdCgroup, pc.RuntimeOptions.SystemdCgroup)
+
+	c.NetworkConfig = NetworkConfig{DNS: p.Network.DNS, DNSSearch: p.Network.DNSSearch, DNSOptions: p.Network.DNSOptions}
+
+	tc, pt := &c.TaskConfig, p.Task
+	set(&tc.Executable, pt.Executable)
+	tc.Args = pt.Args
+	if pt.Timeout != nil {
+		tc.Timeout = time.Duration(*pt.Timeout)
+	}
+	set(&tc.WorkingDir, pt.WorkingDir)
+	set(&tc.DirMerge, pt.DirMerge)
+}
+
+// mergeMap returns a new map with the pairs of base and then of m, or base if m is nil
+func mergeMap(base, m map[string]string) map[string]string {
+	if m == nil {
+		return base
+	}
+	res := make(map[string]string, len(base)+len(m))
+	for key, value := range base {
+		res[key] = value
+	}
+	for key, value := range m {
+		res[key] = value
+	}
+	return res
+}
+
+func set[T any](dst *T, src *T) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+// durationPattern matches the durations accepted by time.ParseDuration
+const durationPattern = `^[-+]?(0|([0-9]+(\.[0-9]*)?|\.[0-9]+)(ns|us|µs|ms|s|m|h))+$`
+
+// ConfigSchema returns the JSON schema of a ConfigFile, so that config files can be
+// validated by editors and in review.
+func ConfigSchema() ([]byte, error) {
+	schema := schemaOf(reflect.TypeOf(ConfigFile{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "dexec config file"
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func schemaOf(t reflect.Type) map[string]interface{} {
+	switch t {
+	case reflect.TypeOf(Duration(0)):
+		return map[string]interface{}{"type": "string", "pattern": durationPattern}
+	case reflect.TypeOf(FileMode(0)):
+		return map[string]interface{}{"type": "string", "pattern": "^0?[0-7]{1,3}$"}
+	case reflect.TypeOf(PullPolicy("")):
+		return map[string]interface{}{"type": "string", "enum": []PullPolicy{PullAlways, PullIfNotPresent, PullNever}}
+	case reflect.TypeOf(MergeMode("")):
+		return map[string]interface{}{"type": "string", "enum": []MergeMode{MergeStrict, MergeReplace, MergeAppend, MergeOverride}}
+	case reflect.TypeOf(digest.Digest("")):
+		return map[string]interface{}{"type": "string", "pattern": `^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			name, opts, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			properties[name] = schemaOf(t.Field(i).Type)
+			if t.Field(i).Type.Kind() == reflect.String && opts != "omitempty" {
+				required = append(required, name)
+			}
+		}
+		schema := map[string]interface{}{"type": "object", "properties": properties, "additionalProperties": false}
+		if len(required) > 0 {
+			sort.Strings(required)
+			schema["required"] = required
+		}
+		return schema
+	}
+	panic(fmt.Sprintf("dexec: no schema for %s", t))
+}
@@ -0,0 +1,225 @@
+package dexec
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfigFile = `
+defaults:
+  namespace: builds
+  container:
+    image: golang:${GO_VERSION:-1.21}
+    env: ["GOFLAGS=-mod=mod", "HOME=$DEXEC_TEST_HOME"]
+    resources:
+      memory: 1073741824
+    labels:
+      team: builds
+  network:
+    dns: ["8.8.8.8"]
+profiles:
+  test:
+    container:
+      mounts:
+        - type: bind
+          source: ${DEXEC_TEST_HOME}/src
+          destination: /src
+      security:
+        capAdd: [NET_BIND_SERVICE]
+        readOnlyRootfs: true
+    task:
+      executable: go
+      args: ["test", "./..."]
+      timeout: 10m
+      workingDir: /src
+  race:
+    extends: test
+    container:
+      pullPolicy: Always
+      resources:
+        cpus: 2
+      labels:
+        race: "true"
+      owner: ci
+      security:
+        capDrop: [ALL]
+        noNewPrivileges: true
+    task:
+      args: ["test", "-race", "./..."]
+      timeout: 30m
+  gvisor:
+    extends: test
+    container:
+      runtime: io.containerd.runsc.v1
+      runtimeOptions:
+        configPath: /etc/containerd/runsc.toml
+  deploy:
+    workspace:
+      path: /work
+      size: 1048576
+    secrets:
+      envPrefix: DEXEC_TEST_SECRET_
+      files:
+        - name: token
+          path: /run/secrets/token
+          mode: "0440"
+          uid: 1000
+  script:
+    task:
+      executable: sh
+      args: ["-c", "echo $$1", "sh"]
+`
+
+func TestParseConfigFile(t *testing.T) {
+	t.Setenv("DEXEC_TEST_HOME", "/home/test")
+	f, err := ParseConfigFile([]byte(testConfigFile))
+	assert.NoError(t, err)
+
+	c, err := f.Config("")
+	assert.NoError(t, err)
+	assert.Equal(t, Config{
+		Namespace: "builds",
+		ContainerConfig: ContainerConfig{
+			Image:     "golang:1.21",
+			Env:       []string{"GOFLAGS=-mod=mod", "HOME=/home/test"},
+			Resources: Resources{Memory: 1 << 30},
+			Labels:    map[string]string{"team": "builds"},
+		},
+		NetworkConfig: NetworkConfig{DNS: []string{"8.8.8.8"}},
+	}, c)
+
+	c, err = f.Config("race")
+	assert.NoError(t, err)
+	assert.Equal(t, Config{
+		Namespace: "builds",
+		ContainerConfig: ContainerConfig{
+			Image:      "golang:1.21",
+			Env:        []string{"GOFLAGS=-mod=mod", "HOME=/home/test"},
+			Mounts:     []Mount{{Type: "bind", Source: "/home/test/src", Destination: "/src"}},
+			PullPolicy: PullAlways,
+			Resources:  Resources{Memory: 1 << 30, CPUs: 2},
+			Labels:     map[string]string{"team": "builds", "race": "true"},
+			Owner:      "ci",
+			Security:   Security{CapAdd: []string{"NET_BIND_SERVICE"}, CapDrop: []string{"ALL"}, NoNewPrivileges: true, ReadOnlyRootfs: true},
+		},
+		NetworkConfig: NetworkConfig{DNS: []string{"8.8.8.8"}},
+		TaskConfig: TaskConfig{
+			Executable: "go",
+			Args:       []string{"test", "-race", "./..."},
+			Timeout:    30 * time.Minute,
+			WorkingDir: "/src",
+		},
+	}, c)
+
+	c, err = f.Config("gvisor")
+	assert.NoError(t, err)
+	assert.Equal(t, "io.containerd.runsc.v1", c.ContainerConfig.Runtime)
+	assert.Equal(t, RuntimeOptions{ConfigPath: "/etc/containerd/runsc.toml"}, c.ContainerConfig.RuntimeOptions)
+
+	c, err = f.Config("deploy")
+	assert.NoError(t, err)
+	assert.Equal(t, Secrets{
+		Files:  []Secret{{Name: "token", Path: "/run/secrets/token", Mode: 0440, UID: 1000}},
+		Source: EnvSecrets{Prefix: "DEXEC_TEST_SECRET_"},
+	}, c.Secrets)
+	assert.Equal(t, &Workspace{Path: "/work", Size: 1 << 20}, c.Workspace)
+
+	c, err = f.Config("script")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"-c", "echo $1", "sh"}, c.TaskConfig.Args)
+	assert.Nil(t, c.ContainerConfig.Mounts)
+
+	t.Setenv("GO_VERSION", "1.22")
+	f, err = ParseConfigFile([]byte(testConfigFile))
+	assert.NoError(t, err)
+	c, err = f.Config("test")
+	assert.NoError(t, err)
+	assert.Equal(t, "golang:1.22", c.ContainerConfig.Image)
+	assert.Equal(t, 10*time.Minute, c.TaskConfig.Timeout)
+}
+
+func TestParseConfigFile_JSON(t *testing.T) {
+	f, err := ParseConfigFile([]byte(`{"profiles": {"echo": {"container": {"image": "busybox"}, "task": {"executable": "echo", "args": ["hello"]}}}}`))
+	assert.NoError(t, err)
+	c, err := f.Config("echo")
+	assert.NoError(t, err)
+	assert.Equal(t, Config{
+		ContainerConfig: ContainerConfig{Image: "busybox"},
+		TaskConfig:      TaskConfig{Executable: "echo", Args: []string{"hello"}},
+	}, c)
+}
+
+func TestParseConfigFile_Errors(t *testing.T) {
+	tests := map[string]string{
+		"unknown field":    "defaults:\n  container:\n    imgae: busybox\n",
+		"unset variable":   "defaults:\n  container:\n    image: ${DEXEC_TEST_UNSET}\n",
+		"invalid duration": "defaults:\n  task:\n    timeout: 10 minutes\n",
+		"invalid mode":     "defaults:\n  secrets:\n    files:\n      - {name: a, path: /a, mode: \"0999\"}\n",
+		"invalid yaml":     "defaults: [",
+	}
+	errs := map[string]string{
+		"unknown field":    `dexec: invalid config file: json: unknown field "imgae"`,
+		"unset variable":   "dexec: invalid config file: defaults.container.image: environment variable DEXEC_TEST_UNSET is not set",
+		"invalid duration": `dexec: invalid config file: time: unknown unit " minutes" in duration "10 minutes"`,
+		"invalid mode":     `dexec: invalid config file: invalid mode "0999": must be an octal string like "0440"`,
+		"invalid yaml":     "dexec: invalid config file: yaml:",
+	}
+	for name, data := range tests {
+		_, err := ParseConfigFile([]byte(data))
+		assert.ErrorContains(t, err, errs[name], name)
+	}
+}
+
+func TestConfigFile_Config_Errors(t *testing.T) {
+	f, err := ParseConfigFile([]byte("profiles:\n  a:\n    extends: b\n  b:\n    extends: a\n  c:\n    extends: d\n"))
+	assert.NoError(t, err)
+
+	_, err = f.Config("a")
+	assert.EqualError(t, err, "dexec: profiles extend each other: a -> b -> a")
+	_, err = f.Config("c")
+	assert.EqualError(t, err, `dexec: unknown profile "d"`)
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dexec.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("defaults:\n  backend: local\n  task:\n    executable: \"true\"\n"), 0644))
+
+	c, err := LoadConfig(path, "")
+	assert.NoError(t, err)
+	assert.Equal(t, Config{Backend: LocalBackend, TaskConfig: TaskConfig{Executable: "true"}}, c)
+
+	_, err = LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"), "")
+	assert.ErrorContains(t, err, "dexec: failed to read config file")
+}
+
+func TestConfigSchema(t *testing.T) {
+	b, err := ConfigSchema()
+	assert.NoError(t, err)
+	var schema map[string]interface{}
+	assert.NoError(t, json.Unmarshal(b, &schema))
+	property := func(schema interface{}, names ...string) map[string]interface{} {
+		for _, name := range names {
+			schema = schema.(map[string]interface{})["properties"].(map[string]interface{})[name]
+		}
+		return schema.(map[string]interface{})
+	}
+
+	assert.Equal(t, false, schema["additionalProperties"])
+	profile := schema["properties"].(map[string]interface{})["profiles"].(map[string]interface{})["additionalProperties"]
+	assert.Equal(t, false, property(profile, "container")["additionalProperties"])
+	assert.Equal(t, map[string]interface{}{"type": "string", "pattern": durationPattern}, property(profile, "task", "timeout"))
+	assert.Equal(t, []interface{}{"Always", "IfNotPresent", "Never"}, property(profile, "container", "pullPolicy")["enum"])
+	assert.Equal(t, "integer", property(profile, "container", "resources", "memory")["type"])
+	assert.Equal(t, "boolean", property(profile, "container", "security", "noNewPrivileges")["type"])
+	mounts := property(profile, "container", "mounts")
+	assert.Equal(t, "array", mounts["type"])
+	assert.Equal(t, []interface{}{"destination"}, mounts["items"].(map[string]interface{})["required"])
+	files := property(profile, "secrets", "files")["items"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"name", "path"}, files["required"])
+	assert.Equal(t, "string", property(files, "mode")["type"])
+}
@@ -0,0 +1,177 @@
+package dexec_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/Workiva/go-dexec"
+	"github.com/Workiva/go-dexec/dexectest"
+	docker "github.com/fsouza/go-dockerclient"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// dockerHarness runs the conformance suite against ByCreatingContainer on a fake Docker server
+type dockerHarness struct {
+	server *dexec.FakeDockerServer
+}
+
+func (h *dockerHarness) Command(t *testing.T, p dexectest.Program) dexec.Cmd {
+	execution, err := dexec.ByCreatingContainer(docker.CreateContainerOptions{Config: &docker.Config{Image: "busybox"}})
+	assert.NoError(t, err)
+	argv := p.Shell()
+	return h.server.Client(t).Command(execution, argv[0], argv[1:]...)
+}
+
+func (h *dockerHarness) Exists(_ *testing.T, pid string) bool {
+	for _, id := range h.server.Containers() {
+		if id == pid {
+			return true
+		}
+	}
+	return false
+}
+
+// containerdHarness runs the conformance suite against ByCreatingTask on a fake containerd with a fake nerdctl
+type containerdHarness struct {
+	fake *dexec.FakeContainerd
+}
+
+func (h *containerdHarness) Command(t *testing.T, p dexectest.Program) dexec.Cmd {
+	execution, err := dexec.ByCreatingTask(dexec.CreateTaskOptions{Image: "busybox"}, logrus.NewEntry(logrus.New()))
+	assert.NoError(t, err)
+	argv := p.Shell()
+	return h.fake.Client().Command(execution, argv[0], argv[1:]...)
+}
+
+func (h *containerdHarness) Exists(_ *testing.T, pid string) bool {
+	for _, id := range h.fake.ContainerIDs() {
+		if id == pid {
+			return true
+		}
+	}
+	return false
+}
+
+// podmanHarness runs the conformance suite against the Podman backend on a fake libpod server
+type podmanHarness struct {
+	server *dexec.FakePodmanServer
+}
+
+func (h *podmanHarness) Command(_ *testing.T, p dexectest.Program) dexec.Cmd {
+	argv := p.Shell()
+	return dexec.Command(h.server.Podman(), dexec.Config{
+		ContainerConfig: dexec.ContainerConfig{Image: "busybox"},
+		TaskConfig:      dexec.TaskConfig{Executable: argv[0], Args: argv[1:]},
+	})
+}
+
+func (h *podmanHarness) Exists(_ *testing.T, pid string) bool {
+	for _, id := range h.server.Containers() {
+		if id == pid {
+			return true
+		}
+	}
+	return false
+}
+
+// localHarness runs the conformance suite against the local backend
+type localHarness struct {
+	local *dexec.Local
+}
+
+func (h *localHarness) Command(_ *testing.T, p dexectest.Program) dexec.Cmd {
+	argv := p.Shell()
+	return dexec.Command(h.local, dexec.Config{
+		TaskConfig: dexec.TaskConfig{Executable: argv[0], Args: argv[1:]},
+	})
+}
+
+func (h *localHarness) Exists(_ *testing.T, pid string) bool {
+	for _, id := range h.local.Running() {
+		if id == pid {
+			return true
+		}
+	}
+	return false
+}
+
+// runcHarness runs the conformance suite against the runc backend with a fake runc
+type runcHarness struct {
+	runc *dexec.Runc
+}
+
+func (h *runcHarness) Command(_ *testing.T, p dexectest.Program) dexec.Cmd {
+	argv := p.Shell()
+	return dexec.Command(h.runc, dexec.Config{
+		ContainerConfig: dexec.ContainerConfig{Image: "busybox:1.36"},
+		TaskConfig:      dexec.TaskConfig{Executable: argv[0], Args: argv[1:]},
+	})
+}
+
+func (h *runcHarness) Exists(_ *testing.T, pid string) bool {
+	_, err := os.Stat(filepath.Join(h.runc.BundleDir, pid))
+	return err == nil
+}
+
+// shellProcess runs the command of a container with the shell of the host
+func shellProcess(p *dexec.FakeProcess) int {
+	cmd := exec.Command("sh", p.Args[1:]...)
+	cmd.Stdin = p.Stdin
+	cmd.Stdout = p.Stdout
+	cmd.Stderr = p.Stderr
+	if err := cmd.Start(); err != nil {
+		return 127
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	var err error
+	select {
+	case err = <-done:
+	case <-p.Stopped:
+		cmd.Process.Kill()
+		err = <-done
+	}
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		return ee.ExitCode()
+	}
+	return 0
+}
+
+func TestDocker_Conformance(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	dexectest.RunConformance(t, &dockerHarness{server: dexec.NewFakeDockerServer(t, shellProcess)})
+}
+
+func TestContainerd_Conformance(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	dexectest.RunConformance(t, &containerdHarness{fake: dexec.NewFakeContainerd(t, shellProcess)})
+}
+
+func TestPodman_Conformance(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	dexectest.RunConformance(t, &podmanHarness{server: dexec.NewFakePodmanServer(t, shellProcess)})
+}
+
+func TestLocal_Conformance(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	dexectest.RunConformance(t, &localHarness{local: &dexec.Local{}})
+}
+
+func TestRunc_Conformance(t *testing.T) {
+	runc := dexec.NewFakeRunc(t, ocispec.ImageConfig{}, map[string]string{"bin/.keep": ""})
+	dexectest.RunConformance(t, &runcHarness{runc: runc})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/leases"
+	"github.com/containerd/containerd/snapshots"
 )
 
 // ContainerdClient is the interface spec of all the calls we use
@@ -15,11 +16,18 @@ type ContainerdClient interface {
 	LoadContainer(context.Context, string) (containerd.Container, error)
 	Containers(context.Context, ...string) ([]containerd.Container, error)
 	Reconnect() error
+	GetImage(context.Context, string) (containerd.Image, error)
+	Pull(context.Context, string, ...containerd.RemoteOpt) (containerd.Image, error)
+	SnapshotService(snapshotterName string) snapshots.Snapshotter
 }
 
 type Containerd struct {
 	ContainerdClient
 	Namespace string
+	// Owner is the owner label value of the containers counted by GetStats, DefaultOwner if empty
+	Owner string
+	// IDs generates the unique part of the names of containers, DefaultIDGenerator if nil
+	IDs IDGenerator
 }
 
 type ContainerdCmd struct {
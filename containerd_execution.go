@@ -3,20 +3,29 @@ package dexec
 import (
 	"bytes"
 	"context"
-	"errors"
 	"fmt"
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/cio"
 	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/mount"
 	"github.com/containerd/containerd/namespaces"
+	runtimeoptions "github.com/containerd/containerd/pkg/runtimeoptions/v1"
+	refdocker "github.com/containerd/containerd/reference/docker"
+	runcopts "github.com/containerd/containerd/runtime/v2/runc/options"
+	"github.com/containerd/continuity/fs"
+	"github.com/containerd/typeurl"
 	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sirupsen/logrus"
 	"io"
-	"math"
+	"os"
 	"os/exec"
-	"strconv"
+	"path"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -31,6 +40,20 @@ const (
 	commandExecutorIdLabel = "chains/commandExecutorId"
 	chainExecutorIdLabel   = "chains/chainExecutorId"
 	commandResultIdLabel   = "chains/commandResultId"
+	imageDigestLabel       = "dexec/imageDigest"
+)
+
+// withTempMount is a variable so that tests can run without mounting snapshots
+var withTempMount = mount.WithTempMount
+
+// taskFIFODir is the directory of the FIFOs of the processes, the default of containerd if empty. It is a variable so
+// that tests can run without writing to the default directory.
+var taskFIFODir string
+
+// mountWorkspace and unmountWorkspace are variables so that tests can run without mounting workspaces
+var (
+	mountWorkspace   = mount.All
+	unmountWorkspace = func(target string) error { return mount.UnmountAll(target, 0) }
 )
 
 type CreateTaskOptions struct {
@@ -41,6 +64,28 @@ type CreateTaskOptions struct {
 	CommandTimeout time.Duration
 	WorkingDir     string
 	CommandDetails CommandDetails
+	ImageOptions   ImageOptions
+	// EnvMerge and DirMerge determine how Cmd.Env and Cmd.Dir are merged with Env and WorkingDir
+	EnvMerge MergeMode
+	DirMerge MergeMode
+	// Security hardens the container
+	Security Security
+	// Runtime and RuntimeOptions select the OCI runtime of the container
+	Runtime        string
+	RuntimeOptions RuntimeOptions
+	// Labels are added to the labels of the container, Annotations are its OCI annotations and Owner is the value
+	// of its owner label, DefaultOwner if empty
+	Labels      map[string]string
+	Annotations map[string]string
+	Owner       string
+	// Metadata describes the command, see Config.Metadata
+	Metadata map[string]string
+	// NamePrefix prefixes the random suffix of the name of the container. If empty, it is DefaultNameTemplate
+	// executed with CommandDetails and Metadata.
+	NamePrefix string
+	// Workspace, if not nil, is a scratch directory of the container backed by a dedicated snapshot, or a tmpfs
+	// when its size is limited. It is mounted on the host and bind mounted into the container.
+	Workspace *Workspace
 }
 
 func ByCreatingTask(opts CreateTaskOptions, logger *logrus.Entry) (Execution[Containerd], error) {
@@ -50,6 +95,7 @@ func ByCreatingTask(opts CreateTaskOptions, logger *logrus.Entry) (Execution[Con
 type createTask struct {
 	opts        CreateTaskOptions
 	image       containerd.Image
+	digest      digest.Digest
 	container   containerd.Container
 	task        containerd.Task
 	cmd         []string
@@ -60,6 +106,34 @@ type createTask struct {
 	deadline    time.Time
 	transaction *newrelic.Transaction
 	namespace   string
+	seccompFile string
+	// user is the user of the process resolved from the image, nil to keep the user of the container
+	user      *specs.User
+	workspace *taskWorkspace
+}
+
+// taskWorkspace is the Workspace of a container, mounted on the host
+type taskWorkspace struct {
+	path        string // path in the container
+	dir         string // mount point on the host
+	snapshotter string
+	snapshot    string // key of the snapshot, empty for a tmpfs
+	mounted     bool
+}
+
+// root returns the directory on the host and the relative path of p if p is in the workspace
+func (w *taskWorkspace) root(p string) (string, string, bool) {
+	if w == nil {
+		return "", "", false
+	}
+	p = path.Clean(p)
+	if p == w.path {
+		return w.dir, "/", true
+	}
+	if prefix := strings.TrimSuffix(w.path, "/") + "/"; strings.HasPrefix(p, prefix) {
+		return w.dir, strings.TrimPrefix(p, prefix), true
+	}
+	return "", "", false
 }
 
 func (t *createTask) setTransaction(txn *newrelic.Transaction) {
@@ -73,6 +147,13 @@ func (t *createTask) create(c Containerd, cmd []string) error {
 	t.deadline = time.Now().Add(expiration)
 	t.namespace = c.Namespace
 
+	if err := t.ensureImage(c); err != nil {
+		return err
+	}
+	if err := t.createWorkspace(c); err != nil {
+		return err
+	}
+
 	t.buildLabels()
 
 	var err error
@@ -85,6 +166,146 @@ func (t *createTask) create(c Containerd, cmd []string) error {
 	return nil
 }
 
+// createWorkspace mounts a new snapshot, or a tmpfs when the size of the workspace is limited, on the host and
+// adds a bind mount of it to the container. Anyone can write to the workspace, like /tmp, since it is private to
+// the container.
+func (t *createTask) createWorkspace(c Containerd) error {
+	w := t.opts.Workspace
+	if w == nil {
+		return nil
+	}
+	defer t.transaction.StartSegment("createWorkspace").End()
+	dir, err := os.MkdirTemp("", "dexec-workspace-")
+	if err != nil {
+		return fmt.Errorf("error creating workspace: %w", err)
+	}
+	t.workspace = &taskWorkspace{path: path.Clean(w.Path), dir: dir}
+	mounts := []mount.Mount{{Type: "tmpfs", Source: "tmpfs", Options: []string{fmt.Sprintf("size=%d", w.Size)}}}
+	if w.Size == 0 {
+		t.workspace.snapshotter, t.workspace.snapshot = containerd.DefaultSnapshotter, workspaceName()
+		mounts, err = c.SnapshotService(t.workspace.snapshotter).Prepare(t.newNewrelicContext(), t.workspace.snapshot, "")
+		if err != nil {
+			return fmt.Errorf("error creating workspace snapshot: %w", err)
+		}
+	}
+	if err = mountWorkspace(mounts, dir); err != nil {
+		return fmt.Errorf("error mounting workspace: %w", err)
+	}
+	t.workspace.mounted = true
+	if err = os.Chmod(dir, 0777|os.ModeSticky); err != nil {
+		return fmt.Errorf("error creating workspace: %w", err)
+	}
+	t.opts.Mounts = append(t.opts.Mounts, specs.Mount{Type: MountBind, Source: dir, Destination: w.Path})
+	return nil
+}
+
+// removeWorkspace unmounts the workspace and removes its snapshot
+func (t *createTask) removeWorkspace(c Containerd) error {
+	w := t.workspace
+	if w == nil {
+		return nil
+	}
+	if w.mounted {
+		if err := unmountWorkspace(w.dir); err != nil {
+			return fmt.Errorf("error unmounting workspace: %w", err)
+		}
+		w.mounted = false
+	}
+	if err := os.RemoveAll(w.dir); err != nil {
+		return fmt.Errorf("error removing workspace: %w", err)
+	}
+	if w.snapshot != "" {
+		err := c.SnapshotService(w.snapshotter).Remove(t.newNewrelicContext(), w.snapshot)
+		if err != nil && !errdefs.IsNotFound(err) {
+			return fmt.Errorf("error removing workspace snapshot: %w", err)
+		}
+	}
+	t.workspace = nil
+	return nil
+}
+
+// ensureImage pins the image and makes it available in the namespace according to the pull policy. Pulling through
+// the client instead of leaving it to nerdctl allows us to report progress and return typed errors
+func (t *createTask) ensureImage(c Containerd) error {
+	defer t.transaction.StartSegment("ensureImage").End()
+	ctx := t.newNewrelicContext()
+	image, pin, err := pinImage(ctx, t.opts.Image, t.opts.ImageOptions)
+	if err != nil {
+		return err
+	}
+	if pin != "" {
+		// a pinned image is looked up and pulled by digest, so that the image nerdctl creates the container from is
+		// the one verified below and not whatever the tag refers to by then
+		if image, err = digestedReference(image, pin); err != nil {
+			return err
+		}
+	}
+	t.opts.Image = image
+	t.digest = pin
+	policy := t.opts.ImageOptions.PullPolicy
+	if policy == "" && pin != "" {
+		policy = PullIfNotPresent
+	}
+	if policy == "" {
+		return nil
+	}
+	named, err := refdocker.ParseDockerRef(image)
+	if err != nil {
+		return fmt.Errorf("error parsing image reference: %w", err)
+	}
+	ref := named.String()
+	if policy != PullAlways {
+		t.image, err = c.GetImage(ctx, ref)
+		if err != nil && !errdefs.IsNotFound(err) {
+			return fmt.Errorf("error getting image: %w", err)
+		}
+		if err != nil && policy == PullNever {
+			return &ImageNotFoundError{Image: image, Err: err}
+		}
+	}
+	if policy == PullAlways || err != nil {
+		if err = t.pullImage(c, ref); err != nil {
+			return err
+		}
+	}
+	if pin == "" {
+		return nil
+	}
+	if actual := t.image.Target().Digest; actual != pin {
+		return &DigestMismatchError{Image: image, Expected: pin, Actual: actual}
+	}
+	return nil
+}
+
+func (t *createTask) pullImage(c Containerd, ref string) (err error) {
+	defer t.transaction.StartSegment("pullImage").End()
+	defer func(start time.Time) {
+		if err == nil {
+			dur := time.Now().Sub(start).Milliseconds()
+			t.logger.WithField("duration", dur).Debugf("dexec: pulled image '%s' in %d ms", ref, dur)
+		}
+	}(time.Now())
+	opts := []containerd.RemoteOpt{containerd.WithPullUnpack}
+	if t.opts.ImageOptions.Auth != nil {
+		opts = append(opts, containerd.WithResolver(t.opts.ImageOptions.Auth.resolver()))
+	}
+	if w := t.opts.ImageOptions.Progress; w != nil {
+		opts = append(opts, containerd.WithImageHandler(images.HandlerFunc(
+			func(_ context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+				fmt.Fprintf(w, "%s: pulling %s %s (%d bytes)\n", ref, desc.MediaType, desc.Digest, desc.Size)
+				return nil, nil
+			})))
+	}
+	t.image, err = c.Pull(t.newNewrelicContext(), ref, opts...)
+	if errdefs.IsNotFound(err) {
+		return &ImageNotFoundError{Image: t.opts.Image, Err: err}
+	}
+	if err != nil {
+		return fmt.Errorf("error pulling image: %w", err)
+	}
+	return nil
+}
+
 // createContainer creates a running container on the containerd host but does not start it. Containerd is different
 // from Docker in that the client is a fat client. When making calls on the socket, some actions happen on the running
 // container, while others happen on the host. By default, if you create a container using the socket, there is NO
@@ -100,13 +321,98 @@ func (t *createTask) createContainer(c Containerd) (containerd.Container, error)
 		dur := time.Now().Sub(start).Milliseconds()
 		t.logger.WithField("duration", dur).Debugf("dexec: entire create container operation took: %d ms", dur)
 	}(time.Now())
-	nerdctlArgs := t.buildCreateContainerArgs(c)
-	containerId, err := t.executeCreateContainer(nerdctlArgs...)
+	// nerdctl reads seccomp profiles from files and copies them to the spec of the container
+	seccompFile, removeSeccompFile, err := t.opts.Security.writeSeccompProfile()
+	if err != nil {
+		return nil, err
+	}
+	t.seccompFile = seccompFile
+	var containerId string
+	for attempt := 1; ; attempt++ {
+		containerId, err = t.executeCreateContainer(t.buildCreateContainerArgs(c)...)
+		if err == nil || !isNameConflict(err) || attempt == maxNameAttempts {
+			break
+		}
+		t.logger.Debugf("dexec: container name is already used, retrying with a new name: %v", err)
+	}
+	removeSeccompFile()
 	if err != nil {
 		return nil, fmt.Errorf("nerdctl: error creating container: %w", err)
 	}
 
-	return t.loadContainer(c, containerId)
+	container, err := t.loadContainer(c, containerId)
+	if err != nil {
+		return nil, err
+	}
+	if err = t.maskPaths(container); err != nil {
+		return nil, err
+	}
+	if err = t.setRuntimeOptions(container); err != nil {
+		return nil, err
+	}
+	return container, nil
+}
+
+// setRuntimeOptions sets the runtime options of the container, since nerdctl has no flags for them. The options
+// nerdctl set for the runc shim, like the binary of the runtime, are kept.
+func (t *createTask) setRuntimeOptions(container containerd.Container) error {
+	opts := t.opts.RuntimeOptions
+	if opts == (RuntimeOptions{}) {
+		return nil
+	}
+	ctx := t.newNewrelicContext()
+	info, err := container.Info(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting container info: %w", err)
+	}
+	var options interface{}
+	if isRuncShim(info.Runtime.Name) {
+		if opts.ConfigPath != "" {
+			return fmt.Errorf("dexec: RuntimeOptions.ConfigPath is not supported by %s", info.Runtime.Name)
+		}
+		runcOptions := &runcopts.Options{}
+		if info.Runtime.Options != nil {
+			v, err := typeurl.UnmarshalAny(info.Runtime.Options)
+			if err != nil {
+				return fmt.Errorf("error reading runtime options: %w", err)
+			}
+			if o, ok := v.(*runcopts.Options); ok {
+				runcOptions = o
+			}
+		}
+		runcOptions.SystemdCgroup = opts.SystemdCgroup
+		options = runcOptions
+	} else {
+		if opts.SystemdCgroup {
+			return fmt.Errorf("dexec: RuntimeOptions.SystemdCgroup is not supported by %s", info.Runtime.Name)
+		}
+		options = &runtimeoptions.Options{ConfigPath: opts.ConfigPath}
+	}
+	if err = container.Update(ctx, containerd.UpdateContainerOpts(containerd.WithRuntime(info.Runtime.Name, options))); err != nil {
+		return fmt.Errorf("error setting runtime options of container: %w", err)
+	}
+	return nil
+}
+
+// maskPaths adds the masked paths of the security options to the spec of the container, since nerdctl has no flag for them
+func (t *createTask) maskPaths(container containerd.Container) error {
+	paths := t.opts.Security.maskedPaths()
+	if paths == nil {
+		return nil
+	}
+	ctx := t.newNewrelicContext()
+	spec, err := container.Spec(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting spec from container: %w", err)
+	}
+	if spec.Linux == nil {
+		spec.Linux = &specs.Linux{}
+	}
+	spec.Linux.MaskedPaths = paths
+	if err = container.Update(ctx, containerd.UpdateContainerOpts(containerd.WithSpec(spec))); err != nil {
+		return fmt.Errorf("error masking paths of container: %w", err)
+	}
+	return nil
 }
 
 func (t *createTask) executeCreateContainer(args ...string) (containerId string, err error) {
@@ -143,58 +449,69 @@ func (t *createTask) loadContainer(c Containerd, containerId string) (container
 }
 func (t *createTask) buildCreateContainerArgs(c Containerd) []string {
 	defer t.transaction.StartSegment("buildCreateContainerArgs").End()
-	args := []string{"--namespace", c.Namespace, "create", "--name", t.generateContainerName(), "--user", t.opts.User}
+	args := []string{"--namespace", c.Namespace, "create", "--name", t.generateContainerName(c), "--user", t.opts.User}
 	for _, m := range t.opts.Mounts {
-		mountString := fmt.Sprintf("%s:%s", m.Source, m.Destination)
+		flag, mountString := "-v", fmt.Sprintf("%s:%s", m.Source, m.Destination)
+		if m.Type == MountTmpfs {
+			flag, mountString = "--tmpfs", m.Destination
+		}
 		if len(m.Options) > 0 {
 			opts := strings.Join(m.Options, ",")
 			mountString = fmt.Sprintf("%s:%s", mountString, opts)
 		}
-		args = append(args, "-v", mountString)
+		args = append(args, flag, mountString)
 	}
 	for _, e := range t.opts.Env {
 		args = append(args, "-e", e)
 	}
-	for key, value := range t.labels {
-		args = append(args, "--label", fmt.Sprintf("%s=%s", key, value))
+	for _, label := range keyValues(t.labels) {
+		args = append(args, "--label", label)
+	}
+	for _, annotation := range keyValues(t.opts.Annotations) {
+		args = append(args, "--annotation", annotation)
+	}
+	if t.opts.ImageOptions.PullPolicy != "" {
+		// the image has already been made available by ensureImage
+		args = append(args, "--pull", "never")
+	}
+	args = append(args, t.opts.Security.nerdctlArgs(t.seccompFile)...)
+	if t.opts.Runtime != "" {
+		args = append(args, "--runtime", t.opts.Runtime)
 	}
 	args = append(args, t.opts.Image)
 	return args
 }
 
-func (t *createTask) generateContainerName() string {
+func (t *createTask) generateContainerName(c Containerd) string {
 	// AA: in order to prevent errors such as being unable to re-run a command due to a failure
 	// or timing issue when cleaning up a prior attempt, append a random suffix to the end to make
 	// sure we can always create the container
-	suffix := RandomString(randomSuffixLength)
-	details := t.opts.CommandDetails
-	// IDs/names can't have two hyphens in a row, so we use abs to generate a compliant id for the health check containers
-	return fmt.Sprintf("chains-%d-%d-%d-%s", abs(details.ChainExecutorId), abs(details.ExecutorId), abs(details.ResultId), suffix)
+	prefix := t.opts.NamePrefix
+	if prefix == "" {
+		// DefaultNameTemplate cannot fail
+		prefix, _ = namePrefix("", t.opts.Metadata, t.opts.CommandDetails)
+	}
+	return containerName(prefix, newID(c.IDs))
 }
 
-func (t *createTask) buildLabels() {
-	labels := make(map[string]string)
+// isNameConflict returns whether nerdctl failed to create a container because its name is used by another container
+func isNameConflict(err error) bool {
+	return strings.Contains(err.Error(), "is already used by")
+}
 
-	labels[ownerLabel] = chains
-	labels[commandExecutorIdLabel] = strconv.FormatInt(t.opts.CommandDetails.ExecutorId, 10)
-	labels[chainExecutorIdLabel] = strconv.FormatInt(t.opts.CommandDetails.ChainExecutorId, 10)
-	labels[commandResultIdLabel] = strconv.FormatInt(t.opts.CommandDetails.ResultId, 10)
+func (t *createTask) buildLabels() {
+	labels := commandLabels(t.opts.Labels, t.opts.Owner, t.opts.Metadata, t.opts.CommandDetails)
 
 	if !t.deadline.IsZero() {
 		labels[deadlineLabel] = t.deadline.Format(time.RFC3339)
 	}
+	if t.digest != "" {
+		labels[imageDigestLabel] = t.digest.String()
+	}
 
 	t.labels = labels
 }
 
-func abs(v int64) int64 {
-	if v >= 0 {
-		return v
-	}
-	f := math.Abs(float64(v))
-	return int64(f)
-}
-
 func (t *createTask) run(c Containerd, stdin io.Reader, stdout, stderr io.Writer) error {
 	var err error
 	// gRPC only sends keepalive pings while gRPC calls are active. Since we use nerdctl
@@ -204,6 +521,9 @@ func (t *createTask) run(c Containerd, stdin io.Reader, stdout, stderr io.Writer
 	if err = t.ensureConnection(c); err != nil {
 		return err
 	}
+	if err = t.resolveUser(c); err != nil {
+		return err
+	}
 	t.task, err = t.createTask()
 	if err != nil {
 		return fmt.Errorf("error creating task: %w", err)
@@ -214,7 +534,12 @@ func (t *createTask) run(c Containerd, stdin io.Reader, stdout, stderr io.Writer
 		return fmt.Errorf("error creating process spec: %w", err)
 	}
 	taskId := fmt.Sprintf("%s-task", t.container.ID())
-	opts := []cio.Opt{cio.WithStreams(stdin, stdout, stderr)}
+	if stdout != nil && sameWriter(stdout, stderr) {
+		// cio copies the standard output and error concurrently, e.g. to the buffer of CombinedOutput
+		w := &lockedWriter{w: stdout}
+		stdout, stderr = w, w
+	}
+	opts := []cio.Opt{cio.WithStreams(stdin, stdout, stderr), cio.WithFIFODir(taskFIFODir)}
 	ctx := t.newNewrelicContext()
 	t.process, err = t.task.Exec(ctx, taskId, spec, cio.NewCreator(opts...))
 	if err != nil {
@@ -233,6 +558,28 @@ func (t *createTask) run(c Containerd, stdin io.Reader, stdout, stderr io.Writer
 	return nil
 }
 
+// lockedWriter serializes the writes to w
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// sameWriter returns whether a and b are the same writer, without panicking on writers that are not comparable
+func sameWriter(a, b io.Writer) (same bool) {
+	defer func() {
+		if recover() != nil {
+			same = false
+		}
+	}()
+	return a == b
+}
+
 // ensureConnection makes sure the connection is still alive for gRPC calls. If we get
 // an error or false back from the client on IsServing, we attempt to reconnect. If
 // we cannot reconnect, we return the error received from the reconnect attempt
@@ -265,13 +612,30 @@ func (t *createTask) createProcessSpec() (*specs.Process, error) {
 
 	spec.Process.Args = t.cmd
 	spec.Process.Cwd = t.opts.WorkingDir
-	if uid, err := strconv.ParseInt(t.opts.User, 10, 64); err == nil {
-		spec.Process.User.UID = uint32(uid)
+	if t.user != nil {
+		spec.Process.User = *t.user
 	}
 	return spec.Process, nil
 }
 
-func (t *createTask) wait(c Containerd) (int, error) {
+// resolveUser resolves the user of the options from the /etc/passwd and /etc/group of the container's root
+// filesystem, before the task is created so that the snapshot is not mounted while the task runs
+func (t *createTask) resolveUser(c Containerd) error {
+	if t.opts.User == "" {
+		return nil
+	}
+	defer t.transaction.StartSegment("resolveUser").End()
+	return t.withRootfs(c, func(root string) error {
+		u, err := resolveUser(root, t.opts.User)
+		if err != nil {
+			return err
+		}
+		t.user = &u
+		return nil
+	})
+}
+
+func (t *createTask) wait(c Containerd, beforeRemove func() error) (int, error) {
 	defer t.cleanup(c)
 
 	ctx, cancel := context.WithDeadline(t.newContext(), t.deadline)
@@ -279,24 +643,86 @@ func (t *createTask) wait(c Containerd) (int, error) {
 
 	select {
 	case exitStatus := <-t.exitChan:
-		return int(exitStatus.ExitCode()), exitStatus.Error()
+		if err := exitStatus.Error(); err != nil || beforeRemove == nil {
+			return int(exitStatus.ExitCode()), err
+		}
+		// the task is deleted first so that its rootfs is no longer mounted while beforeRemove reads the snapshot
+		if _, err := t.task.Delete(t.newNewrelicContext()); err != nil && !errdefs.IsNotFound(err) {
+			return int(exitStatus.ExitCode()), fmt.Errorf("error deleting task: %w", err)
+		}
+		return int(exitStatus.ExitCode()), beforeRemove()
 	case <-ctx.Done():
 		t.logger.Warn("time expired before receiving exit status from container/task")
 		return -1, ctx.Err()
 	}
 }
 
+// copyIn extracts content into the container's root filesystem. The container is created but not started, so its
+// snapshot is mounted on the host and written to directly
+func (t *createTask) copyIn(c Containerd, dst string, content io.Reader) error {
+	defer t.transaction.StartSegment("copyIn").End()
+	return t.withPath(c, dst, func(target string) error {
+		return untar(target, content)
+	})
+}
+
+// copyOut writes a tar archive of src in the container's root filesystem to w
+func (t *createTask) copyOut(c Containerd, src string, w io.Writer) error {
+	defer t.transaction.StartSegment("copyOut").End()
+	return t.withPath(c, src, func(target string) error {
+		if _, err := os.Lstat(target); err != nil {
+			return fmt.Errorf("error reading %s: %w", src, err)
+		}
+		return writeTar(w, target, path.Base(src))
+	})
+}
+
+// withPath calls f with the path on the host of the path p of the container, in the workspace or in the root
+// filesystem
+func (t *createTask) withPath(c Containerd, p string, f func(target string) error) error {
+	if root, rel, ok := t.workspace.root(p); ok {
+		target, err := fs.RootPath(root, rel)
+		if err != nil {
+			return err
+		}
+		return f(target)
+	}
+	return t.withRootfs(c, func(root string) error {
+		target, err := fs.RootPath(root, p)
+		if err != nil {
+			return err
+		}
+		return f(target)
+	})
+}
+
+// withRootfs mounts the container's snapshot to a temporary directory on the host and calls f with its path
+func (t *createTask) withRootfs(c Containerd, f func(root string) error) error {
+	ctx := t.newNewrelicContext()
+	info, err := t.container.Info(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting container info: %w", err)
+	}
+	mounts, err := c.SnapshotService(info.Snapshotter).Mounts(ctx, info.SnapshotKey)
+	if err != nil {
+		return fmt.Errorf("error getting snapshot mounts: %w", err)
+	}
+	return withTempMount(ctx, mounts, f)
+}
+
 func (t *createTask) setEnv(env []string) error {
-	if len(t.opts.Env) > 0 {
-		return errors.New("dexec: Config.Env already set")
+	env, err := mergeEnv(t.opts.EnvMerge, t.opts.Env, env)
+	if err != nil {
+		return err
 	}
 	t.opts.Env = env
 	return nil
 }
 
 func (t *createTask) setDir(dir string) error {
-	if t.opts.WorkingDir != "" {
-		return errors.New("dexec: Config.WorkingDir already set")
+	dir, err := mergeDir(t.opts.DirMerge, t.opts.WorkingDir, dir)
+	if err != nil {
+		return err
 	}
 	t.opts.WorkingDir = dir
 	return nil
@@ -306,25 +732,42 @@ func (t *createTask) getID() string {
 	return t.container.ID()
 }
 
+func (t *createTask) imageDigest() string {
+	return t.digest.String()
+}
+
 // kill kills the running task and cleans up any resources that were created to run it. For all intents and purposes
 // kill is identical to cleanup
 func (t *createTask) kill(c Containerd) error {
 	return t.cleanup(c)
 }
 
-// cleanup kills any tasks that are still running, deletes them, and deletes the container that ran the task. if the
-// api returns a NotFound error, the error is ignored and we will return nil. otherwise, any errors encountered during
-// the cleanup operations will be returned
-func (t *createTask) cleanup(Containerd) error {
+// cleanup kills any tasks that are still running, deletes them, and deletes the container that ran the task and its
+// workspace. if the api returns a NotFound error, the error is ignored and we will return nil. otherwise, any errors
+// encountered during the cleanup operations will be returned
+func (t *createTask) cleanup(c Containerd) error {
+	err := t.deleteContainer()
+	if werr := t.removeWorkspace(c); err == nil {
+		err = werr
+	}
+	return err
+}
+
+func (t *createTask) deleteContainer() error {
 	ctx := t.newNewrelicContext()
-	_, err := t.task.Delete(ctx, containerd.WithProcessKill)
-	if err != nil && !errdefs.IsNotFound(err) {
-		return fmt.Errorf("error deleting task: %w", err)
+	if t.task != nil {
+		_, err := t.task.Delete(ctx, containerd.WithProcessKill)
+		if err != nil && !errdefs.IsNotFound(err) {
+			return fmt.Errorf("error deleting task: %w", err)
+		}
 	}
-	if err = t.container.Delete(ctx, containerd.WithSnapshotCleanup); err == nil || errdefs.IsNotFound(err) {
+	if t.container == nil {
 		return nil
 	}
-	return fmt.Errorf("error deleting container: %w", err)
+	if err := t.container.Delete(ctx, containerd.WithSnapshotCleanup); err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("error deleting container: %w", err)
+	}
+	return nil
 }
 
 func (t *createTask) newContext() context.Context {
@@ -1,16 +1,26 @@
 package dexec
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/containers"
 	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/mount"
 	"github.com/containerd/containerd/oci"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"io"
+	"os"
+	"path/filepath"
 	"regexp"
 	"testing"
+	"time"
 )
 
 func Test_createTask_run(t *testing.T) {
@@ -56,7 +66,7 @@ func Test_createTask_generateContainerName(t *testing.T) {
 		},
 	}
 	expectedRegex := "chains-1-2-3-[a-zA-Z]{6}"
-	containerId := ct.generateContainerName()
+	containerId := ct.generateContainerName(Containerd{})
 	assert.Regexp(t, regexp.MustCompile(expectedRegex), containerId)
 }
 
@@ -69,6 +79,7 @@ func Test_createTask_createProcessSpec(t *testing.T) {
 			User:       "61000",
 			WorkingDir: "/go/src",
 		},
+		user: &specs.User{UID: 61000, GID: 61000, AdditionalGids: []uint32{10}},
 	}
 
 	spec := &oci.Spec{Process: &specs.Process{}}
@@ -77,12 +88,48 @@ func Test_createTask_createProcessSpec(t *testing.T) {
 		Return(spec, nil)
 
 	ps, _ := ct.createProcessSpec()
-	assert.Equal(t, uint32(61000), ps.User.UID)
+	assert.Equal(t, specs.User{UID: 61000, GID: 61000, AdditionalGids: []uint32{10}}, ps.User)
 	assert.Equal(t, ct.opts.WorkingDir, ps.Cwd)
 	assert.Equal(t, ps.Args, ct.cmd)
 	mockContainer.AssertExpectations(t)
 }
 
+func Test_createTask_resolveUser(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"etc/passwd": "root:x:0:0:root:/root:/bin/sh\napp:x:1000:1000::/home/app:/bin/sh\n",
+		"etc/group":  "root:x:0:\napp:x:1000:\ndocker:x:999:app\n",
+	})
+	mounts := []mount.Mount{{Type: "overlay", Source: "overlay"}}
+	defer func(orig func(context.Context, []mount.Mount, func(string) error) error) { withTempMount = orig }(withTempMount)
+	withTempMount = func(_ context.Context, m []mount.Mount, f func(string) error) error {
+		assert.Equal(t, mounts, m)
+		return f(root)
+	}
+
+	mockContainer := new(container)
+	mockContainer.
+		On("Info", mock.Anything).
+		Return(containers.Container{Snapshotter: "overlayfs", SnapshotKey: "unit-test"}, nil)
+	mockSnapshotter := new(snapshotter)
+	mockSnapshotter.On("Mounts", mock.Anything, "unit-test").Return(mounts, nil)
+	client := new(client)
+	client.On("SnapshotService", "overlayfs").Return(mockSnapshotter)
+
+	ct := &createTask{container: mockContainer, opts: CreateTaskOptions{User: "app"}}
+	assert.NoError(t, ct.resolveUser(Containerd{ContainerdClient: client}))
+	assert.Equal(t, &specs.User{UID: 1000, GID: 1000, AdditionalGids: []uint32{999}}, ct.user)
+	mockContainer.AssertExpectations(t)
+
+	ct = &createTask{container: mockContainer, opts: CreateTaskOptions{User: "nobody"}}
+	assert.EqualError(t, ct.resolveUser(Containerd{ContainerdClient: client}), `dexec: failed to resolve user "nobody": unable to find user nobody: no matching entries in passwd file`)
+
+	// the snapshot is not mounted without a user
+	ct = &createTask{container: new(container)}
+	assert.NoError(t, ct.resolveUser(Containerd{}))
+	assert.Nil(t, ct.user)
+}
+
 func Test_createTask_cleanup_NotFoundErrIgnoredOnTaskDelete(t *testing.T) {
 	mockContainer := new(container)
 	mockTask := new(task)
@@ -200,3 +247,168 @@ func Test_createTask_buildCreateContainerArgs(t *testing.T) {
 		assertion(t, firstArg, secondArg)
 	}
 }
+
+func Test_createTask_copyIn(t *testing.T) {
+	root := t.TempDir()
+	mounts := []mount.Mount{{Type: "overlay", Source: "overlay"}}
+	defer func(orig func(context.Context, []mount.Mount, func(string) error) error) { withTempMount = orig }(withTempMount)
+	withTempMount = func(_ context.Context, m []mount.Mount, f func(string) error) error {
+		assert.Equal(t, mounts, m)
+		return f(root)
+	}
+
+	mockContainer := new(container)
+	mockContainer.
+		On("Info", mock.Anything).
+		Return(containers.Container{Snapshotter: "overlayfs", SnapshotKey: "unit-test"}, nil)
+	mockSnapshotter := new(snapshotter)
+	mockSnapshotter.On("Mounts", mock.Anything, "unit-test").Return(mounts, nil)
+	client := new(client)
+	client.On("SnapshotService", "overlayfs").Return(mockSnapshotter)
+
+	src := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "input.txt"), []byte("hello"), 0644))
+
+	ct := &createTask{container: mockContainer}
+	err := ct.copyIn(Containerd{ContainerdClient: client}, "/go/src", tarPath(src, "inputs"))
+	assert.NoError(t, err)
+
+	b, err := os.ReadFile(filepath.Join(root, "go", "src", "inputs", "input.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+	mockContainer.AssertExpectations(t)
+	mockSnapshotter.AssertExpectations(t)
+	client.AssertExpectations(t)
+}
+
+func Test_createTask_wait_BeforeRemoveAfterTaskDelete(t *testing.T) {
+	mockContainer := new(container)
+	mockTask := new(task)
+	exitChan := make(chan containerd.ExitStatus, 1)
+	exitChan <- *containerd.NewExitStatus(3, time.Now(), nil)
+	ct := &createTask{
+		container: mockContainer,
+		task:      mockTask,
+		exitChan:  exitChan,
+		deadline:  time.Now().Add(time.Minute),
+	}
+
+	var calls []string
+	mockTask.
+		On("Delete", mock.Anything).
+		Run(func(mock.Arguments) { calls = append(calls, "deleteTask") }).
+		Return(nil, nil).Once()
+	mockTask.
+		On("Delete", mock.Anything, mock.Anything).
+		Return(nil, errdefs.ErrNotFound)
+	mockContainer.
+		On("Delete", mock.Anything, mock.Anything).
+		Run(func(mock.Arguments) { calls = append(calls, "deleteContainer") }).
+		Return(nil)
+
+	ec, err := ct.wait(Containerd{}, func() error {
+		calls = append(calls, "beforeRemove")
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, ec)
+	assert.Equal(t, []string{"deleteTask", "beforeRemove", "deleteContainer"}, calls)
+}
+
+func Test_createTask_ensureImage(t *testing.T) {
+	const ref = "docker.io/library/busybox:latest"
+	notFound := fmt.Errorf("image %q: %w", ref, errdefs.ErrNotFound)
+	tests := []struct {
+		name      string
+		policy    PullPolicy
+		getErr    error
+		pullErr   error
+		pulled    bool
+		notFound  bool
+		getCalled bool
+	}{
+		{name: "no policy"},
+		{name: "if not present, present", policy: PullIfNotPresent, getCalled: true},
+		{name: "if not present, missing", policy: PullIfNotPresent, getErr: notFound, getCalled: true, pulled: true},
+		{name: "if not present, missing in registry", policy: PullIfNotPresent, getErr: notFound, pullErr: notFound, getCalled: true, pulled: true, notFound: true},
+		{name: "never, present", policy: PullNever, getCalled: true},
+		{name: "never, missing", policy: PullNever, getErr: notFound, getCalled: true, notFound: true},
+		{name: "always", policy: PullAlways, pulled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := new(image)
+			client := new(client)
+			client.On("GetImage", mock.Anything, ref).Return(img, tt.getErr)
+			client.On("Pull", mock.Anything, ref).Return(img, tt.pullErr)
+			ct := &createTask{
+				opts:   CreateTaskOptions{Image: "busybox", ImageOptions: ImageOptions{PullPolicy: tt.policy}},
+				logger: logrus.NewEntry(logrus.New()),
+			}
+
+			err := ct.ensureImage(Containerd{ContainerdClient: client})
+			var infe *ImageNotFoundError
+			assert.Equal(t, tt.notFound, errors.As(err, &infe))
+			if !tt.notFound {
+				assert.NoError(t, err)
+			}
+			if tt.getCalled {
+				client.AssertCalled(t, "GetImage", mock.Anything, ref)
+			} else {
+				client.AssertNotCalled(t, "GetImage", mock.Anything, mock.Anything)
+			}
+			if tt.pulled {
+				client.AssertCalled(t, "Pull", mock.Anything, ref)
+			} else {
+				client.AssertNotCalled(t, "Pull", mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func Test_createTask_buildCreateContainerArgs_PullPolicy(t *testing.T) {
+	task := &createTask{
+		opts: CreateTaskOptions{
+			Image:        "busybox",
+			ImageOptions: ImageOptions{PullPolicy: PullIfNotPresent},
+		},
+	}
+	args := task.buildCreateContainerArgs(Containerd{Namespace: "unit-test"})
+	assert.Equal(t, []string{"--pull", "never", "busybox"}, args[len(args)-3:])
+}
+
+func Test_createTask_ensureImage_Digest(t *testing.T) {
+	pinned := digest.FromString("pinned")
+	ref := "docker.io/library/busybox@" + pinned.String()
+	tests := []struct {
+		name     string
+		image    string
+		options  ImageOptions
+		actual   digest.Digest
+		mismatch bool
+	}{
+		{name: "match", image: "busybox@" + pinned.String(), actual: pinned},
+		{name: "mismatch", image: "busybox@" + pinned.String(), actual: digest.FromString("retagged"), mismatch: true},
+		{name: "pinned tag", image: "busybox:1.36", options: ImageOptions{Digest: pinned}, actual: pinned},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := new(image)
+			img.On("Target").Return(ocispec.Descriptor{Digest: tt.actual})
+			client := new(client)
+			client.On("GetImage", mock.Anything, ref).Return(img, nil)
+			ct := &createTask{opts: CreateTaskOptions{Image: tt.image, ImageOptions: tt.options}}
+
+			err := ct.ensureImage(Containerd{ContainerdClient: client})
+			var dme *DigestMismatchError
+			assert.Equal(t, tt.mismatch, errors.As(err, &dme))
+			if !tt.mismatch {
+				assert.NoError(t, err)
+				ct.buildLabels()
+				assert.Equal(t, pinned.String(), ct.labels[imageDigestLabel])
+				assert.Equal(t, pinned.String(), ct.imageDigest())
+				assert.Equal(t, "busybox@"+pinned.String(), ct.opts.Image)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package dexec
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/oci"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// fakeNerdctl is a nerdctl that creates containers by printing their name as their ID
+const fakeNerdctl = `#!/bin/sh
+while [ $# -gt 0 ]; do
+	[ "$1" = --name ] && { echo "$2"; exit 0; }
+	shift
+done
+echo "missing --name" >&2
+exit 1
+`
+
+// fakeContainerd is an in-memory stand-in for containerd covering the lifecycle used by ByCreatingTask: containers
+// are created by a fake nerdctl on the PATH and loaded from the client, and the processes of their tasks run
+// Process over the FIFOs of the task like a shim does. Only the methods of ContainerdClient used by that lifecycle
+// are implemented.
+type fakeContainerd struct {
+	ContainerdClient
+	// Process is run by the processes of the tasks and returns their exit code
+	Process func(p *fakeProcess) int
+
+	mu         sync.Mutex
+	containers map[string]*fakeTaskContainer
+}
+
+func newFakeContainerd(t *testing.T, process func(p *fakeProcess) int) *fakeContainerd {
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, nerdctlBinary), []byte(fakeNerdctl), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	orig := taskFIFODir
+	taskFIFODir = t.TempDir()
+	t.Cleanup(func() { taskFIFODir = orig })
+	return &fakeContainerd{Process: process, containers: map[string]*fakeTaskContainer{}}
+}
+
+// Client returns the Containerd client of the fake in the namespace unit-test
+func (f *fakeContainerd) Client() Containerd {
+	return Containerd{ContainerdClient: f, Namespace: "unit-test"}
+}
+
+// ContainerIDs returns the IDs of the containers that have not been deleted
+func (f *fakeContainerd) ContainerIDs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ids []string
+	for id := range f.containers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (f *fakeContainerd) IsServing(context.Context) (bool, error) {
+	return true, nil
+}
+
+// LoadContainer returns the container created by the fake nerdctl with the ID
+func (f *fakeContainerd) LoadContainer(_ context.Context, id string) (containerd.Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.containers[id]
+	if !ok {
+		c = &fakeTaskContainer{fake: f, id: id}
+		f.containers[id] = c
+	}
+	return c, nil
+}
+
+type fakeTaskContainer struct {
+	containerd.Container
+	fake *fakeContainerd
+	id   string
+}
+
+func (c *fakeTaskContainer) ID() string {
+	return c.id
+}
+
+func (c *fakeTaskContainer) Spec(context.Context) (*oci.Spec, error) {
+	return &oci.Spec{Process: &specs.Process{Cwd: "/"}}, nil
+}
+
+func (c *fakeTaskContainer) NewTask(context.Context, cio.Creator, ...containerd.NewTaskOpts) (containerd.Task, error) {
+	return &fakeTask{container: c}, nil
+}
+
+func (c *fakeTaskContainer) Delete(context.Context, ...containerd.DeleteOpts) error {
+	c.fake.mu.Lock()
+	defer c.fake.mu.Unlock()
+	if _, ok := c.fake.containers[c.id]; !ok {
+		return errdefs.ErrNotFound
+	}
+	delete(c.fake.containers, c.id)
+	return nil
+}
+
+type fakeTask struct {
+	containerd.Task
+	container *fakeTaskContainer
+
+	mu        sync.Mutex
+	processes []*fakeTaskProcess
+	deleted   bool
+}
+
+// Exec creates the IO of the process like containerd does, the process opens the other ends of its FIFOs when it
+// starts
+func (t *fakeTask) Exec(_ context.Context, id string, spec *specs.Process, creator cio.Creator) (containerd.Process, error) {
+	io, err := creator(id)
+	if err != nil {
+		return nil, err
+	}
+	p := &fakeTaskProcess{
+		task:   t,
+		io:     io,
+		args:   spec.Args,
+		env:    spec.Env,
+		dir:    spec.Cwd,
+		exit:   make(chan containerd.ExitStatus, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		status: containerd.Created,
+	}
+	t.mu.Lock()
+	t.processes = append(t.processes, p)
+	t.mu.Unlock()
+	return p, nil
+}
+
+// Delete kills the processes of the task, whether or not containerd.WithProcessKill is set, and closes their IO
+func (t *fakeTask) Delete(context.Context, ...containerd.ProcessDeleteOpts) (*containerd.ExitStatus, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.deleted {
+		return nil, errdefs.ErrNotFound
+	}
+	t.deleted = true
+	for _, p := range t.processes {
+		p.kill()
+		p.io.Close()
+	}
+	return containerd.NewExitStatus(0, time.Now(), nil), nil
+}
+
+type fakeTaskProcess struct {
+	containerd.Process
+	task *fakeTask
+	io   cio.IO
+	args []string
+	env  []string
+	dir  string
+	exit chan containerd.ExitStatus
+	stop chan struct{}
+	done chan struct{}
+
+	mu      sync.Mutex
+	status  containerd.ProcessStatus
+	stopped bool
+}
+
+func (p *fakeTaskProcess) Wait(context.Context) (<-chan containerd.ExitStatus, error) {
+	return p.exit, nil
+}
+
+// Start opens the FIFOs of the process and runs Process with them. The exit status is sent once the output has been
+// copied to the streams of the command, since the FIFOs are closed when the process exits.
+func (p *fakeTaskProcess) Start(context.Context) error {
+	p.mu.Lock()
+	p.status = containerd.Running
+	p.mu.Unlock()
+	config := p.io.Config()
+	process := &fakeProcess{Args: p.args, Env: p.env, Dir: p.dir, Stopped: p.stop}
+	var files []*os.File
+	open := func(path string, flag int) *os.File {
+		f, err := os.OpenFile(path, flag, 0)
+		if err != nil {
+			return nil
+		}
+		files = append(files, f)
+		return f
+	}
+	if config.Stdin != "" {
+		if f := open(config.Stdin, syscall.O_RDONLY); f != nil {
+			process.Stdin = f
+		}
+	}
+	if config.Stdout != "" {
+		if f := open(config.Stdout, syscall.O_WRONLY); f != nil {
+			process.Stdout = f
+		}
+	}
+	if config.Stderr != "" {
+		if f := open(config.Stderr, syscall.O_WRONLY); f != nil {
+			process.Stderr = f
+		}
+	}
+	go func() {
+		code := p.task.container.fake.Process(process)
+		if code < 0 {
+			// killed, like the exit code of a shell
+			code = 128 + int(syscall.SIGKILL)
+		}
+		for _, f := range files {
+			f.Close()
+		}
+		p.io.Wait()
+		p.mu.Lock()
+		p.status = containerd.Stopped
+		p.mu.Unlock()
+		p.exit <- *containerd.NewExitStatus(uint32(code), time.Now(), nil)
+		close(p.done)
+	}()
+	return nil
+}
+
+// kill stops the process if it is running and waits for it to exit
+func (p *fakeTaskProcess) kill() {
+	p.mu.Lock()
+	running := p.status == containerd.Running
+	if !p.stopped {
+		p.stopped = true
+		close(p.stop)
+	}
+	p.mu.Unlock()
+	if running {
+		<-p.done
+	}
+}
@@ -7,34 +7,56 @@ import (
 	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/namespaces"
 	"github.com/sirupsen/logrus"
-	"time"
 )
 
 func getContainerdStats(c Containerd) (Stats, error) {
 	ctx := namespaces.WithNamespace(context.Background(), c.Namespace)
+	containers, err := ownedContainers(ctx, c)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	return processContainers(ctx, containers), nil
+}
+
+func getContainerdStatsBy(c Containerd, key string) (map[string]Stats, error) {
+	ctx := namespaces.WithNamespace(context.Background(), c.Namespace)
+	containers, err := ownedContainers(ctx, c)
+	if err != nil {
+		return nil, err
+	}
 
-	filters := fmt.Sprintf(`labels."%s"==%s`, ownerLabel, chains)
+	groups := make(map[string][]containerd.Container)
+	for _, container := range containers {
+		// containers whose labels cannot be read are counted as errors of the "" group by processContainers
+		labels, _ := container.Labels(ctx)
+		value := labels[MetadataLabelPrefix+key]
+		groups[value] = append(groups[value], container)
+	}
+	stats := make(map[string]Stats, len(groups))
+	for value, group := range groups {
+		stats[value] = processContainers(ctx, group)
+	}
+	return stats, nil
+}
+
+// ownedContainers returns the containers of the owner of c
+func ownedContainers(ctx context.Context, c Containerd) ([]containerd.Container, error) {
+	// the owner is quoted so that owners with spaces or operators of the filter syntax match too
+	filters := fmt.Sprintf(`labels.%q==%q`, ownerLabel, ownerOrDefault(c.Owner))
 	containers, err := c.Containers(ctx, filters)
 	if err != nil {
 		logrus.Warnf("stats: unable to get containers: %v", err)
-		return Stats{}, fmt.Errorf("error getting stats: %w", err)
+		return nil, fmt.Errorf("error getting stats: %w", err)
 	}
-
-	return processContainers(ctx, containers), nil
+	return containers, nil
 }
 
 func processContainers(ctx context.Context, containers []containerd.Container) Stats {
 	stats := Stats{}
 	for _, container := range containers {
 		if labels, err := container.Labels(ctx); err == nil {
-			if deadline, ok := labels[deadlineLabel]; ok {
-				if deadlineTime, err := time.Parse(time.RFC3339, deadline); err == nil && time.Now().After(deadlineTime) {
-					stats.DeadlineExceeded += 1
-				} else if err != nil {
-					logrus.Warnf("stats: error parsing time: %v", err)
-					stats.Errors += 1
-				}
-			}
+			stats.addDeadline(labels)
 		} else {
 			stats.Errors += 1
 		}
@@ -4,7 +4,11 @@ import (
 	"context"
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/mount"
 	"github.com/containerd/containerd/oci"
+	"github.com/containerd/containerd/snapshots"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/stretchr/testify/mock"
 )
@@ -23,6 +27,86 @@ func (c *client) IsServing(ctx context.Context) (bool, error) {
 	return false, err
 }
 
+func (c *client) SnapshotService(snapshotterName string) snapshots.Snapshotter {
+	args := c.Called(snapshotterName)
+	if s, ok := args.Get(0).(snapshots.Snapshotter); ok {
+		return s
+	}
+	return nil
+}
+
+func (c *client) GetImage(ctx context.Context, ref string) (containerd.Image, error) {
+	args := c.Called(ctx, ref)
+	err := args.Error(1)
+	if img, ok := args.Get(0).(containerd.Image); ok {
+		return img, err
+	}
+	return nil, err
+}
+
+func (c *client) Pull(ctx context.Context, ref string, opts ...containerd.RemoteOpt) (containerd.Image, error) {
+	args := c.Called(ctx, ref)
+	err := args.Error(1)
+	if img, ok := args.Get(0).(containerd.Image); ok {
+		return img, err
+	}
+	return nil, err
+}
+
+func (c *client) Containers(ctx context.Context, filters ...string) ([]containerd.Container, error) {
+	args := c.Called(ctx, filters)
+	err := args.Error(1)
+	if containers, ok := args.Get(0).([]containerd.Container); ok {
+		return containers, err
+	}
+	return nil, err
+}
+
+func (c *client) LoadContainer(ctx context.Context, id string) (containerd.Container, error) {
+	args := c.Called(ctx, id)
+	err := args.Error(1)
+	if container, ok := args.Get(0).(containerd.Container); ok {
+		return container, err
+	}
+	return nil, err
+}
+
+type image struct {
+	mock.Mock
+	containerd.Image
+}
+
+func (i *image) Target() ocispec.Descriptor {
+	return i.Called().Get(0).(ocispec.Descriptor)
+}
+
+type snapshotter struct {
+	mock.Mock
+	snapshots.Snapshotter
+}
+
+func (s *snapshotter) Mounts(ctx context.Context, key string) ([]mount.Mount, error) {
+	args := s.Called(ctx, key)
+	err := args.Error(1)
+	if mounts, ok := args.Get(0).([]mount.Mount); ok {
+		return mounts, err
+	}
+	return nil, err
+}
+
+func (s *snapshotter) Prepare(ctx context.Context, key, parent string, opts ...snapshots.Opt) ([]mount.Mount, error) {
+	args := s.Called(ctx, key, parent)
+	err := args.Error(1)
+	if mounts, ok := args.Get(0).([]mount.Mount); ok {
+		return mounts, err
+	}
+	return nil, err
+}
+
+func (s *snapshotter) Remove(ctx context.Context, key string) error {
+	return s.Called(ctx, key).Error(0)
+}
+
 type container struct {
 	mock.Mock
 	containerd.Container
@@ -74,6 +158,15 @@ func (c *container) Delete(ctx context.Context, opts ...containerd.DeleteOpts) e
 	return args.Error(0)
 }
 
+func (c *container) Info(ctx context.Context, opts ...containerd.InfoOpts) (containers.Container, error) {
+	args := c.Called(ctx)
+	err := args.Error(1)
+	if info, ok := args.Get(0).(containers.Container); ok {
+		return info, err
+	}
+	return containers.Container{}, err
+}
+
 func (c *container) ID() string {
 	return c.Called().String(0)
 }
@@ -133,3 +226,8 @@ func (p *process) Start(ctx context.Context) error {
 	args := p.Called(ctx)
 	return args.Error(0)
 }
+
+func (c *container) Update(ctx context.Context, opts ...containerd.UpdateContainerOpts) error {
+	args := c.Called(ctx, opts)
+	return args.Error(0)
+}
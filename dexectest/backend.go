@@ -0,0 +1,227 @@
+package dexectest
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Workiva/go-dexec"
+)
+
+// BackendName is the name the fake backend is registered with.
+const BackendName = "dexectest"
+
+// exitCodeKilled is the exit code of a killed command, as reported for SIGKILL
+const exitCodeKilled = 137
+
+func init() {
+	dexec.RegisterBackend(BackendName, func(client interface{}, config dexec.Config) (dexec.Backend, error) {
+		c, ok := client.(*Client)
+		if !ok {
+			return nil, dexec.ErrUnsupportedClient
+		}
+		return &backend{client: c, config: config}, nil
+	})
+}
+
+// backend plays the script of a command
+type backend struct {
+	client   *Client
+	config   dexec.Config
+	env      []string
+	dir      string
+	script   *Script
+	run      *Run
+	killOnce sync.Once
+	killed   chan struct{}
+	done     chan struct{}
+	exitCode int
+}
+
+func (b *backend) SetEnv(env []string) error {
+	b.env = env
+	return nil
+}
+
+func (b *backend) SetDir(dir string) error {
+	b.dir = dir
+	return nil
+}
+
+func (b *backend) Create(argv []string) error {
+	image := b.config.ContainerConfig.Image
+	script := b.client.match(image, argv)
+	if script == nil {
+		return fmt.Errorf("dexectest: no script for %s %q", image, argv)
+	}
+	if err := script.failures[PhaseCreate]; err != nil {
+		return err
+	}
+	b.script = script
+
+	env := b.env
+	if env == nil {
+		env = b.config.ContainerConfig.Env
+	}
+	dir := b.dir
+	if dir == "" {
+		dir = b.config.TaskConfig.WorkingDir
+	}
+	b.run = &Run{Config: b.config, Image: image, Argv: argv, Env: env, Dir: dir, CopiedIn: map[string][]byte{}}
+	b.client.record(b.run)
+	return nil
+}
+
+func (b *backend) CopyIn(dst string, content io.Reader) error {
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	b.client.update(b.run, func(r *Run) {
+		r.CopiedIn[dst] = append(r.CopiedIn[dst], data...)
+	})
+	return nil
+}
+
+func (b *backend) Start(stdin io.Reader, stdout, stderr io.Writer) error {
+	if err := b.script.failures[PhaseStart]; err != nil {
+		return err
+	}
+	b.killed = make(chan struct{})
+	b.done = make(chan struct{})
+	go b.execute(stdin, stdout, stderr)
+	return nil
+}
+
+// execute plays the script of the command
+func (b *backend) execute(stdin io.Reader, stdout, stderr io.Writer) {
+	defer close(b.done)
+
+	inc := make(chan []byte, 1)
+	go func() {
+		in, _ := ioutil.ReadAll(stdin)
+		inc <- in
+	}()
+	var in []byte
+	select {
+	case in = <-inc:
+		b.client.update(b.run, func(r *Run) { r.Stdin = in })
+	case <-b.killed:
+		b.exitCode = exitCodeKilled
+		return
+	}
+
+	if b.script.delay > 0 {
+		timer := time.NewTimer(b.script.delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-b.killed:
+			b.exitCode = exitCodeKilled
+			return
+		}
+	}
+	stdout.Write(b.script.stdout)
+	if b.script.echo {
+		stdout.Write(in)
+	}
+	stderr.Write(b.script.stderr)
+	b.exitCode = b.script.exitCode
+}
+
+func (b *backend) Wait(beforeRemove func() error) (int, error) {
+	if b.done == nil {
+		return -1, errors.New("dexectest: command is not running")
+	}
+	<-b.done
+	ec := b.exitCode
+	b.client.update(b.run, func(r *Run) {
+		r.Exited = true
+		r.ExitCode = ec
+	})
+	defer b.client.update(b.run, func(r *Run) { r.Removed = true })
+	if beforeRemove != nil {
+		if err := beforeRemove(); err != nil {
+			return ec, err
+		}
+	}
+	return ec, b.script.failures[PhaseWait]
+}
+
+// CopyOut writes the scripted files at or below src
+func (b *backend) CopyOut(src string, w io.Writer) error {
+	src = path.Clean(src)
+	var names []string
+	for name := range b.script.files {
+		if name = path.Clean(name); name == src || strings.HasPrefix(name, strings.TrimSuffix(src, "/")+"/") {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("dexectest: no such file %s", src)
+	}
+	sort.Strings(names)
+	tw := tar.NewWriter(w)
+	for _, name := range names {
+		content := b.script.files[name]
+		hdr := &tar.Header{
+			Name:     strings.TrimPrefix(strings.TrimPrefix(name, path.Dir(src)), "/"),
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func (b *backend) ID() string {
+	if b.run == nil {
+		return ""
+	}
+	return b.run.ID
+}
+
+// Kill stops the command, which then exits with code 137
+func (b *backend) Kill() error {
+	if b.run == nil {
+		return nil
+	}
+	if err := b.script.failures[PhaseKill]; err != nil {
+		return err
+	}
+	if b.killed == nil {
+		return nil
+	}
+	b.killOnce.Do(func() {
+		b.client.update(b.run, func(r *Run) { r.Killed = true })
+		close(b.killed)
+	})
+	return nil
+}
+
+func (b *backend) Cleanup() error {
+	if b.run == nil {
+		return nil
+	}
+	if err := b.script.failures[PhaseCleanup]; err != nil {
+		return err
+	}
+	b.client.update(b.run, func(r *Run) {
+		r.Removed = true
+		r.Cleaned = true
+	})
+	return nil
+}
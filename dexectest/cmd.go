@@ -0,0 +1,19 @@
+package dexectest
+
+import (
+	"github.com/Workiva/go-dexec"
+)
+
+// Cmd is a fake dexec.Cmd whose behavior is scripted on its Client. It runs the
+// command with the backend of the Client, so Path, Args, Env, Dir, Stdin, Stdout,
+// Stderr, Artifacts and ProcessState have the same meaning as on dexec.GenericCmd.
+type Cmd struct {
+	*dexec.BackendCmd
+}
+
+var (
+	_ dexec.Cmd                = (*Cmd)(nil)
+	_ dexec.InputCopier        = (*Cmd)(nil)
+	_ dexec.ArtifactCollector  = (*Cmd)(nil)
+	_ dexec.ProcessStateGetter = (*Cmd)(nil)
+)
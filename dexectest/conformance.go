@@ -0,0 +1,314 @@
+package dexectest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Workiva/go-dexec"
+)
+
+// Program describes what a command run by the conformance suite does.
+type Program struct {
+	// Stdout and Stderr are written to the standard output and error
+	Stdout string
+	Stderr string
+	// Cat copies the standard input to the standard output after Stdout is written
+	Cat bool
+	// Sleep makes the program run until it is killed
+	Sleep bool
+	// ExitCode is the exit code of the program when it does not Sleep
+	ExitCode int
+}
+
+// Shell returns the argv of a POSIX shell running p, for harnesses that run the
+// suite against real containers, e.g. with the busybox image.
+func (p Program) Shell() []string {
+	var script []string
+	if p.Stdout != "" {
+		script = append(script, "printf '%s' "+shellQuote(p.Stdout))
+	}
+	if p.Stderr != "" {
+		script = append(script, "printf '%s' "+shellQuote(p.Stderr)+" >&2")
+	}
+	if p.Cat {
+		script = append(script, "cat")
+	}
+	if p.Sleep {
+		// exec so that killing the shell kills the sleep
+		script = append(script, "exec sleep 3600")
+	} else {
+		script = append(script, fmt.Sprintf("exit %d", p.ExitCode))
+	}
+	return []string{"sh", "-c", strings.Join(script, "; ")}
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Harness adapts a backend to the conformance suite.
+type Harness interface {
+	// Command returns a command that is not started and runs program
+	Command(t *testing.T, program Program) dexec.Cmd
+	// Exists reports whether the container of the command with the given PID
+	// still exists
+	Exists(t *testing.T, pid string) bool
+}
+
+// RunConformance checks that the commands of h follow the contract documented
+// on dexec.Cmd. Every backend is expected to pass it.
+func RunConformance(t *testing.T, h Harness) {
+	t.Run("Run", func(t *testing.T) { testRun(t, h) })
+	t.Run("Output", func(t *testing.T) { testOutput(t, h) })
+	t.Run("OutputExitError", func(t *testing.T) { testOutputExitError(t, h) })
+	t.Run("OutputStderrSet", func(t *testing.T) { testOutputStderrSet(t, h) })
+	t.Run("CombinedOutput", func(t *testing.T) { testCombinedOutput(t, h) })
+	t.Run("StreamsAlreadySet", func(t *testing.T) { testStreamsAlreadySet(t, h) })
+	t.Run("Pipes", func(t *testing.T) { testPipes(t, h) })
+	t.Run("StartTwice", func(t *testing.T) { testStartTwice(t, h) })
+	t.Run("WaitNotStarted", func(t *testing.T) { testWaitNotStarted(t, h) })
+	t.Run("WaitRemovesContainer", func(t *testing.T) { testWaitRemovesContainer(t, h) })
+	t.Run("Kill", func(t *testing.T) { testKill(t, h) })
+	t.Run("KillNotStarted", func(t *testing.T) { testKillNotStarted(t, h) })
+	t.Run("ProcessState", func(t *testing.T) { testProcessState(t, h) })
+}
+
+func testRun(t *testing.T, h Harness) {
+	cmd := h.Command(t, Program{Stdout: "out", Stderr: "err"})
+	var stderr bytes.Buffer
+	cmd.SetStderr(&stderr)
+	// Cmd has no setter for the standard output
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("StdoutPipe() = %v", err)
+	}
+	outc := readAsync(stdout)
+	if err = cmd.Run(); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	expectString(t, "stdout", <-outc, "out")
+	expectString(t, "stderr", stderr.String(), "err")
+}
+
+func testOutput(t *testing.T, h Harness) {
+	out, err := h.Command(t, Program{Stdout: "out", Stderr: "err"}).Output()
+	if err != nil {
+		t.Fatalf("Output() = %v", err)
+	}
+	expectString(t, "Output()", string(out), "out")
+}
+
+func testOutputExitError(t *testing.T, h Harness) {
+	out, err := h.Command(t, Program{Stdout: "out", Stderr: "err", ExitCode: 3}).Output()
+	ee := expectExitError(t, err, 3)
+	expectString(t, "Output()", string(out), "out")
+	if ee != nil {
+		expectString(t, "ExitError.Stderr", string(ee.Stderr), "err")
+	}
+}
+
+func testOutputStderrSet(t *testing.T, h Harness) {
+	cmd := h.Command(t, Program{Stderr: "err", ExitCode: 1})
+	var stderr bytes.Buffer
+	cmd.SetStderr(&stderr)
+	_, err := cmd.Output()
+	if ee := expectExitError(t, err, 1); ee != nil && len(ee.Stderr) != 0 {
+		t.Errorf("ExitError.Stderr = %q, want it empty when Stderr is set", ee.Stderr)
+	}
+	expectString(t, "stderr", stderr.String(), "err")
+}
+
+func testCombinedOutput(t *testing.T, h Harness) {
+	out, err := h.Command(t, Program{Stdout: "out", Stderr: "err"}).CombinedOutput()
+	if err != nil {
+		t.Fatalf("CombinedOutput() = %v", err)
+	}
+	// ordering of the streams is not guaranteed
+	if s := string(out); s != "outerr" && s != "errout" {
+		t.Errorf("CombinedOutput() = %q, want out and err", s)
+	}
+}
+
+func testStreamsAlreadySet(t *testing.T, h Harness) {
+	cmd := h.Command(t, Program{})
+	cmd.SetStderr(io.Discard)
+	if _, err := cmd.CombinedOutput(); err == nil {
+		t.Errorf("CombinedOutput() with Stderr set = nil, want an error")
+	}
+	cmd = h.Command(t, Program{})
+	if _, err := cmd.StdoutPipe(); err != nil {
+		t.Fatalf("StdoutPipe() = %v", err)
+	}
+	if _, err := cmd.StdoutPipe(); err == nil {
+		t.Errorf("second StdoutPipe() = nil, want an error")
+	}
+	if _, err := cmd.Output(); err == nil {
+		t.Errorf("Output() with Stdout set = nil, want an error")
+	}
+	if _, err := cmd.StdinPipe(); err != nil {
+		t.Fatalf("StdinPipe() = %v", err)
+	}
+	if _, err := cmd.StdinPipe(); err == nil {
+		t.Errorf("second StdinPipe() = nil, want an error")
+	}
+}
+
+func testPipes(t *testing.T, h Harness) {
+	cmd := h.Command(t, Program{Stdout: "out:", Stderr: "err", Cat: true})
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatalf("StdinPipe() = %v", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("StdoutPipe() = %v", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		t.Fatalf("StderrPipe() = %v", err)
+	}
+	if err = cmd.Start(); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	outc, errc := readAsync(stdout), readAsync(stderr)
+	if _, err = io.WriteString(stdin, "in"); err != nil {
+		t.Errorf("writing to stdin: %v", err)
+	}
+	stdin.Close()
+	if err = cmd.Wait(); err != nil {
+		t.Fatalf("Wait() = %v", err)
+	}
+	// Wait closes the pipes, so reading them completes
+	expectString(t, "stdout", <-outc, "out:in")
+	expectString(t, "stderr", <-errc, "err")
+}
+
+func testStartTwice(t *testing.T, h Harness) {
+	cmd := h.Command(t, Program{})
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	if err := cmd.Start(); err == nil {
+		t.Errorf("second Start() = nil, want an error")
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("Wait() = %v", err)
+	}
+}
+
+func testWaitNotStarted(t *testing.T, h Harness) {
+	if err := h.Command(t, Program{}).Wait(); err == nil {
+		t.Errorf("Wait() before Start() = nil, want an error")
+	}
+}
+
+func testWaitRemovesContainer(t *testing.T, h Harness) {
+	cmd := h.Command(t, Program{ExitCode: 2})
+	if pid := cmd.GetPID(); pid != "" {
+		t.Errorf("GetPID() before Start() = %q, want empty", pid)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	pid := cmd.GetPID()
+	if pid == "" {
+		t.Fatalf("GetPID() after Start() is empty")
+	}
+	if !h.Exists(t, pid) {
+		t.Errorf("container %s does not exist after Start()", pid)
+	}
+	expectExitError(t, cmd.Wait(), 2)
+	if h.Exists(t, pid) {
+		t.Errorf("container %s exists after Wait()", pid)
+	}
+	if err := cmd.Cleanup(); err != nil {
+		t.Errorf("Cleanup() of a removed container = %v", err)
+	}
+}
+
+func testKill(t *testing.T, h Harness) {
+	cmd := h.Command(t, Program{Sleep: true})
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	pid := cmd.GetPID()
+	if err := cmd.Kill(); err != nil {
+		t.Fatalf("Kill() = %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Wait() of a killed command = nil, want an error")
+		}
+	case <-time.After(time.Minute):
+		t.Fatalf("Wait() did not return after Kill()")
+	}
+	if h.Exists(t, pid) {
+		t.Errorf("container %s exists after Wait()", pid)
+	}
+	if err := cmd.Kill(); err != nil {
+		t.Errorf("Kill() of a removed container = %v", err)
+	}
+}
+
+func testKillNotStarted(t *testing.T, h Harness) {
+	if err := h.Command(t, Program{}).Kill(); err != nil {
+		t.Errorf("Kill() before Start() = %v", err)
+	}
+}
+
+// testProcessState checks the process state of the commands that implement dexec.ProcessStateGetter
+func testProcessState(t *testing.T, h Harness) {
+	cmd := h.Command(t, Program{ExitCode: 4})
+	getter, ok := cmd.(dexec.ProcessStateGetter)
+	if !ok {
+		t.Skip("the command does not implement dexec.ProcessStateGetter")
+	}
+	if ps := getter.GetProcessState(); ps != nil {
+		t.Errorf("GetProcessState() before Start() = %+v, want nil", ps)
+	}
+	expectExitError(t, cmd.Run(), 4)
+	ps := getter.GetProcessState()
+	if ps == nil {
+		t.Fatalf("GetProcessState() after Wait() is nil")
+	}
+	if ps.ID != cmd.GetPID() || !ps.Exited || ps.ExitCode != 4 {
+		t.Errorf("GetProcessState() = %+v, want ID %s, exited with code 4", ps, cmd.GetPID())
+	}
+}
+
+func readAsync(r io.Reader) <-chan string {
+	c := make(chan string, 1)
+	go func() {
+		b, _ := io.ReadAll(r)
+		c <- string(b)
+	}()
+	return c
+}
+
+func expectExitError(t *testing.T, err error, code int) *dexec.ExitError {
+	t.Helper()
+	var ee *dexec.ExitError
+	if !errors.As(err, &ee) {
+		t.Errorf("error = %v, want *dexec.ExitError with code %d", err, code)
+		return nil
+	}
+	if ee.ExitCode != code {
+		t.Errorf("ExitError.ExitCode = %d, want %d", ee.ExitCode, code)
+	}
+	return ee
+}
+
+func expectString(t *testing.T, what, got, want string) {
+	t.Helper()
+	if got != want {
+		t.Errorf("%s = %q, want %q", what, got, want)
+	}
+}
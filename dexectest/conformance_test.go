@@ -0,0 +1,87 @@
+package dexectest
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Workiva/go-dexec"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeHarness runs the conformance suite against Client
+type fakeHarness struct {
+	client *Client
+}
+
+func (h *fakeHarness) Command(_ *testing.T, p Program) dexec.Cmd {
+	argv := p.Shell()
+	s := h.client.On("busybox", argv...).Stdout(p.Stdout).Stderr(p.Stderr).ExitCode(p.ExitCode)
+	if p.Cat {
+		s.EchoStdin()
+	}
+	if p.Sleep {
+		s.Delay(time.Hour)
+	}
+	return h.client.Command(dexec.Config{
+		ContainerConfig: dexec.ContainerConfig{Image: "busybox"},
+		TaskConfig:      dexec.TaskConfig{Executable: argv[0], Args: argv[1:]},
+	})
+}
+
+func (h *fakeHarness) Exists(_ *testing.T, pid string) bool {
+	for _, r := range h.client.Runs() {
+		if r.ID == pid {
+			return !r.Removed
+		}
+	}
+	return false
+}
+
+func TestRunConformance(t *testing.T) {
+	RunConformance(t, &fakeHarness{client: NewClient()})
+}
+
+func TestProgram_Shell(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not available")
+	}
+	tests := []struct {
+		name     string
+		program  Program
+		stdin    string
+		stdout   string
+		stderr   string
+		exitCode int
+	}{
+		{name: "empty", program: Program{}},
+		{name: "output", program: Program{Stdout: "it's %s", Stderr: "err\n"}, stdout: "it's %s", stderr: "err\n"},
+		{name: "cat", program: Program{Stdout: "out:", Cat: true}, stdin: "in", stdout: "out:in"},
+		{name: "exit code", program: Program{ExitCode: 3}, exitCode: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			argv := tt.program.Shell()
+			assert.Equal(t, "sh", argv[0])
+			cmd := exec.Command(sh, argv[1:]...)
+			var stdout, stderr bytes.Buffer
+			cmd.Stdin = strings.NewReader(tt.stdin)
+			cmd.Stdout = &stdout
+			cmd.Stderr = &stderr
+			err := cmd.Run()
+			var ee *exec.ExitError
+			if errors.As(err, &ee) {
+				assert.Equal(t, tt.exitCode, ee.ExitCode())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, 0, tt.exitCode)
+			}
+			assert.Equal(t, tt.stdout, stdout.String())
+			assert.Equal(t, tt.stderr, stderr.String())
+		})
+	}
+}
@@ -0,0 +1,260 @@
+// Package dexectest provides an in-memory fake of dexec for testing code that
+// runs commands with dexec, without a Docker daemon or containerd.
+//
+// Commands are scripted per image and argv with Client.On, and every command
+// that runs is recorded so tests can assert on what was executed:
+//
+//	client := dexectest.NewClient()
+//	client.On("busybox", "echo", "hello").Stdout("hello\n")
+//	cmd := client.Command(config)
+//	out, err := cmd.Output()
+//	client.AssertRan(t, "busybox", "echo", "hello")
+//
+// The Client is also a dexec backend, so code that calls dexec.Command can be
+// given a Client in tests. A scripted command reads its standard input until EOF,
+// runs for the scripted delay, writes the scripted output and exits with the
+// scripted exit code.
+//
+// RunConformance checks that a backend follows the contract of dexec.Cmd.
+package dexectest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Workiva/go-dexec"
+)
+
+// Phase is a step in the life of a command at which a script can fail.
+type Phase string
+
+const (
+	// PhaseCreate fails Start before the command is recorded as run
+	PhaseCreate Phase = "create"
+	// PhaseStart fails Start after the command is recorded as run
+	PhaseStart Phase = "start"
+	// PhaseWait fails Wait after the command exits
+	PhaseWait Phase = "wait"
+	// PhaseKill fails Kill
+	PhaseKill Phase = "kill"
+	// PhaseCleanup fails Cleanup
+	PhaseCleanup Phase = "cleanup"
+)
+
+// TestingT is the subset of *testing.T used by the assertions of Client.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+	Helper()
+}
+
+// Client is a fake dexec client. Its zero value is not usable, use NewClient.
+type Client struct {
+	mu      sync.Mutex
+	scripts []*Script
+	runs    []*Run
+	nextID  int
+}
+
+// NewClient returns a Client without any scripts.
+func NewClient() *Client {
+	return &Client{}
+}
+
+// On scripts the behavior of commands running image with argv. If argv is
+// empty the script matches any command using image. Scripts are matched in the
+// order they were added; a command without a matching script fails to start.
+func (c *Client) On(image string, argv ...string) *Script {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := &Script{image: image, argv: argv, failures: map[Phase]error{}, files: map[string][]byte{}}
+	c.scripts = append(c.scripts, s)
+	return s
+}
+
+// Command returns a fake command for config, in the same way as dexec.Command.
+// Passing the Client to dexec.Command has the same effect.
+func (c *Client) Command(config dexec.Config) *Cmd {
+	config.Backend = BackendName
+	return &Cmd{BackendCmd: dexec.Command(c, config).(*dexec.BackendCmd)}
+}
+
+// Runs returns the commands that were started, in order.
+func (c *Client) Runs() []Run {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	runs := make([]Run, len(c.runs))
+	for i, r := range c.runs {
+		runs[i] = *r
+	}
+	return runs
+}
+
+// AssertRan asserts that a command with image and argv was started.
+func (c *Client) AssertRan(t TestingT, image string, argv ...string) bool {
+	t.Helper()
+	for _, r := range c.Runs() {
+		if r.Image == image && equal(r.Argv, argv) {
+			return true
+		}
+	}
+	t.Errorf("dexectest: expected %s to run %q, ran:\n%s", image, argv, c.describeRuns())
+	return false
+}
+
+// AssertNotRan asserts that no command with image and argv was started.
+func (c *Client) AssertNotRan(t TestingT, image string, argv ...string) bool {
+	t.Helper()
+	for _, r := range c.Runs() {
+		if r.Image == image && equal(r.Argv, argv) {
+			t.Errorf("dexectest: expected %s not to run %q", image, argv)
+			return false
+		}
+	}
+	return true
+}
+
+// AssertExpectations asserts that every script matched at least one command.
+func (c *Client) AssertExpectations(t TestingT) bool {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ok := true
+	for _, s := range c.scripts {
+		if s.calls == 0 {
+			t.Errorf("dexectest: script for %s %q was never run", s.image, s.argv)
+			ok = false
+		}
+	}
+	return ok
+}
+
+func (c *Client) describeRuns() string {
+	var b strings.Builder
+	for _, r := range c.Runs() {
+		fmt.Fprintf(&b, "\t%s %q\n", r.Image, r.Argv)
+	}
+	return b.String()
+}
+
+// match returns the script for image and argv, or nil if none matches
+func (c *Client) match(image string, argv []string) *Script {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range c.scripts {
+		if s.image == image && (len(s.argv) == 0 || equal(s.argv, argv)) {
+			s.calls++
+			return s
+		}
+	}
+	return nil
+}
+
+func (c *Client) record(r *Run) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	r.ID = fmt.Sprintf("dexectest-%d", c.nextID)
+	c.runs = append(c.runs, r)
+}
+
+// Script is the scripted behavior of a command. Its methods return the
+// script so that they can be chained.
+type Script struct {
+	image    string
+	argv     []string
+	stdout   []byte
+	stderr   []byte
+	exitCode int
+	echo     bool
+	delay    time.Duration
+	failures map[Phase]error
+	files    map[string][]byte
+	calls    int
+}
+
+// Stdout sets the standard output of the command.
+func (s *Script) Stdout(out string) *Script {
+	s.stdout = []byte(out)
+	return s
+}
+
+// Stderr sets the standard error of the command.
+func (s *Script) Stderr(out string) *Script {
+	s.stderr = []byte(out)
+	return s
+}
+
+// EchoStdin makes the command write its standard input to its standard output
+// after the scripted output.
+func (s *Script) EchoStdin() *Script {
+	s.echo = true
+	return s
+}
+
+// ExitCode sets the exit code of the command.
+func (s *Script) ExitCode(code int) *Script {
+	s.exitCode = code
+	return s
+}
+
+// Delay makes the command run for d before it exits, unless it is killed.
+func (s *Script) Delay(d time.Duration) *Script {
+	s.delay = d
+	return s
+}
+
+// FailAt makes the command fail with err at phase.
+func (s *Script) FailAt(phase Phase, err error) *Script {
+	s.failures[phase] = err
+	return s
+}
+
+// File makes the command leave a file with content at path in its container,
+// which can be collected as an artifact.
+func (s *Script) File(path, content string) *Script {
+	s.files[path] = []byte(content)
+	return s
+}
+
+// Run is the record of a started command.
+type Run struct {
+	// ID is the identifier returned by GetPID
+	ID     string
+	Config dexec.Config
+	Image  string
+	Argv   []string
+	Env    []string
+	Dir    string
+	// Stdin is everything the command read from its standard input
+	Stdin []byte
+	// CopiedIn maps container directories to the tar archives copied into them
+	CopiedIn map[string][]byte
+	Exited   bool
+	ExitCode int
+	Killed   bool
+	// Removed reports whether the container of the command was removed, which
+	// Wait and Cleanup do
+	Removed bool
+	Cleaned bool
+}
+
+// update applies f to the run r while holding the lock of c
+func (c *Client) update(r *Run, f func(r *Run)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f(r)
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
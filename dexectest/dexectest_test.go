@@ -0,0 +1,248 @@
+package dexectest
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Workiva/go-dexec"
+	"github.com/stretchr/testify/assert"
+)
+
+func config(image, executable string, args ...string) dexec.Config {
+	return dexec.Config{
+		ContainerConfig: dexec.ContainerConfig{Image: image},
+		TaskConfig:      dexec.TaskConfig{Executable: executable, Args: args},
+	}
+}
+
+// recorder records the errors reported by assertions
+type recorder struct {
+	errors []string
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Helper() {}
+
+func TestCmd_Output(t *testing.T) {
+	client := NewClient()
+	client.On("busybox", "echo", "hello").Stdout("hello\n").Stderr("ignored")
+
+	out, err := client.Command(config("busybox", "echo", "hello")).Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello\n", string(out))
+	client.AssertRan(t, "busybox", "echo", "hello")
+	client.AssertNotRan(t, "busybox", "echo", "bye")
+	client.AssertExpectations(t)
+}
+
+func TestCmd_Output_ExitError(t *testing.T) {
+	client := NewClient()
+	client.On("busybox").Stderr("boom").ExitCode(3)
+
+	cmd := client.Command(config("busybox", "false"))
+	_, err := cmd.Output()
+	var ee *dexec.ExitError
+	assert.True(t, errors.As(err, &ee))
+	assert.Equal(t, 3, ee.ExitCode)
+	assert.Equal(t, "boom", string(ee.Stderr))
+	assert.Equal(t, &dexec.ProcessState{ID: cmd.GetPID(), Exited: true, ExitCode: 3}, cmd.GetProcessState())
+}
+
+func TestCmd_CombinedOutput(t *testing.T) {
+	client := NewClient()
+	client.On("busybox", "sh").Stdout("out\n").Stderr("err\n")
+
+	out, err := client.Command(config("busybox", "sh")).CombinedOutput()
+	assert.NoError(t, err)
+	assert.Equal(t, "out\nerr\n", string(out))
+}
+
+func TestCmd_Stdin(t *testing.T) {
+	client := NewClient()
+	client.On("busybox", "cat").Stdout("done")
+
+	cmd := client.Command(config("busybox", "cat"))
+	stdin, err := cmd.StdinPipe()
+	assert.NoError(t, err)
+	stdout, err := cmd.StdoutPipe()
+	assert.NoError(t, err)
+	assert.NoError(t, cmd.Start())
+
+	out := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(stdout)
+		out <- b
+	}()
+	_, err = io.WriteString(stdin, "input")
+	assert.NoError(t, err)
+	assert.NoError(t, stdin.Close())
+	assert.NoError(t, cmd.Wait())
+	assert.Equal(t, "done", string(<-out))
+
+	runs := client.Runs()
+	assert.Len(t, runs, 1)
+	assert.Equal(t, "input", string(runs[0].Stdin))
+}
+
+func TestCmd_Unscripted(t *testing.T) {
+	client := NewClient()
+	client.On("busybox", "ls")
+
+	err := client.Command(config("alpine", "ls")).Run()
+	assert.EqualError(t, err, `dexectest: no script for alpine ["ls"]`)
+	assert.Empty(t, client.Runs())
+
+	r := &recorder{}
+	assert.False(t, client.AssertExpectations(r))
+	assert.False(t, client.AssertRan(r, "alpine", "ls"))
+	assert.Len(t, r.errors, 2)
+}
+
+func TestCmd_FailAt(t *testing.T) {
+	boom := errors.New("boom")
+	tests := []struct {
+		phase Phase
+		ran   bool
+	}{
+		{phase: PhaseCreate},
+		{phase: PhaseStart, ran: true},
+		{phase: PhaseKill, ran: true},
+		{phase: PhaseWait, ran: true},
+		{phase: PhaseCleanup, ran: true},
+	}
+	for _, tc := range tests {
+		t.Run(string(tc.phase), func(t *testing.T) {
+			client := NewClient()
+			client.On("busybox").FailAt(tc.phase, boom)
+
+			cmd := client.Command(config("busybox", "true"))
+			err := cmd.Start()
+			if err == nil && tc.phase == PhaseKill {
+				err = cmd.Kill()
+			}
+			if err == nil {
+				err = cmd.Wait()
+			}
+			if err == nil {
+				err = cmd.Cleanup()
+			}
+			assert.ErrorIs(t, err, boom)
+			assert.Equal(t, tc.ran, len(client.Runs()) == 1)
+		})
+	}
+}
+
+func TestCmd_Kill(t *testing.T) {
+	client := NewClient()
+	client.On("busybox", "sleep").Delay(time.Hour).Stdout("never")
+
+	cmd := client.Command(config("busybox", "sleep"))
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	assert.NoError(t, cmd.Start())
+	assert.NoError(t, cmd.Kill())
+
+	var ee *dexec.ExitError
+	assert.True(t, errors.As(cmd.Wait(), &ee))
+	assert.Equal(t, 137, ee.ExitCode)
+	assert.Empty(t, out.String())
+	assert.NoError(t, cmd.Cleanup())
+	runs := client.Runs()
+	assert.True(t, runs[0].Killed)
+	assert.True(t, runs[0].Cleaned)
+}
+
+func TestCmd_Delay(t *testing.T) {
+	client := NewClient()
+	client.On("busybox").Delay(20 * time.Millisecond)
+
+	start := time.Now()
+	assert.NoError(t, client.Command(config("busybox", "sleep")).Run())
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+}
+
+func TestCmd_EnvAndDir(t *testing.T) {
+	client := NewClient()
+	client.On("busybox")
+
+	conf := config("busybox", "env")
+	conf.ContainerConfig.Env = []string{"A=1"}
+	conf.TaskConfig.WorkingDir = "/work"
+	assert.NoError(t, client.Command(conf).Run())
+
+	cmd := client.Command(conf)
+	cmd.Env = []string{"B=2"}
+	cmd.SetDir("/tmp")
+	assert.NoError(t, cmd.Run())
+
+	runs := client.Runs()
+	assert.Equal(t, []string{"A=1"}, runs[0].Env)
+	assert.Equal(t, "/work", runs[0].Dir)
+	assert.Equal(t, []string{"B=2"}, runs[1].Env)
+	assert.Equal(t, "/tmp", runs[1].Dir)
+}
+
+func TestCmd_CopyIn(t *testing.T) {
+	client := NewClient()
+	client.On("busybox")
+
+	src := filepath.Join(t.TempDir(), "input.txt")
+	assert.NoError(t, os.WriteFile(src, []byte("input"), 0644))
+	cmd := client.Command(config("busybox", "cat", "/data/in.txt"))
+	assert.NoError(t, cmd.CopyIn("/etc", strings.NewReader("archive")))
+	assert.NoError(t, cmd.CopyFileIn(src, "/data/in.txt"))
+	assert.NoError(t, cmd.Run())
+	assert.EqualError(t, cmd.CopyIn("/etc", strings.NewReader("late")), "dexec: already started")
+
+	copied := client.Runs()[0].CopiedIn
+	assert.Equal(t, "archive", string(copied["/etc"]))
+	tr := tar.NewReader(bytes.NewReader(copied["/data"]))
+	hdr, err := tr.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "in.txt", hdr.Name)
+	b, err := io.ReadAll(tr)
+	assert.NoError(t, err)
+	assert.Equal(t, "input", string(b))
+}
+
+func TestCmd_Artifacts(t *testing.T) {
+	client := NewClient()
+	client.On("busybox").
+		File("/out/result.json", `{"ok":true}`).
+		File("/out/reports/report.txt", "report")
+
+	dir := t.TempDir()
+	var out bytes.Buffer
+	conf := config("busybox", "build")
+	conf.Artifacts = dexec.Artifacts{Paths: []string{"/out"}, Dir: dir, Output: &out}
+	assert.NoError(t, client.Command(conf).Run())
+
+	b, err := os.ReadFile(filepath.Join(dir, "out", "reports", "report.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "report", string(b))
+	var names []string
+	tr := tar.NewReader(&out)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+	assert.Equal(t, []string{"out/reports/report.txt", "out/result.json"}, names)
+
+	conf.Artifacts = dexec.Artifacts{Paths: []string{"/out"}, Dir: t.TempDir(), MaxBytes: 8}
+	assert.ErrorIs(t, client.Command(conf).Run(), dexec.ErrArtifactsTooLarge)
+}
@@ -6,8 +6,16 @@ import (
 
 // Docker contains connection to Docker API.
 // Use github.com/fsouza/go-dockerclient to initialize *docker.Client.
+//
+// Docker is initialized with a keyed literal, e.g. Docker{Client: cl}. It used to have
+// the client as its only field, but since Owner and IDs were added the unkeyed literal
+// Docker{cl} no longer compiles and must be rewritten that way.
 type Docker struct {
 	*docker.Client
+	// Owner is the owner label value of the containers counted by GetStats, DefaultOwner if empty
+	Owner string
+	// IDs generates the unique part of the names of containers, DefaultIDGenerator if nil
+	IDs IDGenerator
 }
 
 // Command returns the Cmd struct to execute the named program with given
@@ -1,20 +1,85 @@
 package dexec
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/newrelic/go-agent/v3/newrelic"
 	"io"
+	"net/http"
+	"strings"
+	"time"
 
 	"github.com/fsouza/go-dockerclient"
+	"github.com/opencontainers/go-digest"
 )
 
 type createContainer struct {
 	opt         docker.CreateContainerOptions
+	image       ImageOptions
+	digest      digest.Digest
 	cmd         []string
 	id          string // created container id
 	cw          docker.CloseWaiter
 	transaction *newrelic.Transaction
+	envMerge    MergeMode
+	dirMerge    MergeMode
+	workspace   *Workspace
+	volume      string // volume of the workspace
+	namePrefix  string
+	timeout     time.Duration
+}
+
+// ContainerOption configures the ByCreatingContainer execution beyond what
+// docker.CreateContainerOptions covers.
+type ContainerOption func(*createContainer)
+
+// WithImageOptions sets how the image is made available before the container is created.
+func WithImageOptions(opts ImageOptions) ContainerOption {
+	return func(c *createContainer) {
+		c.image = opts
+	}
+}
+
+// WithEnvMerge sets how Cmd.Env is merged with Config.Env. See MergeMode.
+func WithEnvMerge(mode MergeMode) ContainerOption {
+	return func(c *createContainer) {
+		c.envMerge = mode
+	}
+}
+
+// WithDirMerge sets how Cmd.Dir is merged with Config.WorkingDir. See MergeMode.
+func WithDirMerge(mode MergeMode) ContainerOption {
+	return func(c *createContainer) {
+		c.dirMerge = mode
+	}
+}
+
+// WithWorkspaceVolume sets the scratch directory of the container, backed by a named
+// volume. The volume is created with the container and removed with it. See Workspace.
+func WithWorkspaceVolume(w *Workspace) ContainerOption {
+	return func(c *createContainer) {
+		c.workspace = w
+	}
+}
+
+// WithNamePrefix names the container after prefix, followed by a suffix generated by
+// Docker.IDs. The prefix must be a valid container name. If empty, Name of the options
+// is kept.
+func WithNamePrefix(prefix string) ContainerOption {
+	return func(c *createContainer) {
+		c.namePrefix = prefix
+	}
+}
+
+// WithCommandTimeout labels the container with the deadline of the command, the timeout
+// and a buffer after the container is created, like containerd containers are labeled.
+// GetStats counts the containers whose deadline has passed in DeadlineExceeded. Docker
+// does not stop the container at the deadline. If zero, the container has no deadline.
+func WithCommandTimeout(timeout time.Duration) ContainerOption {
+	return func(c *createContainer) {
+		c.timeout = timeout
+	}
 }
 
 // ByCreatingContainer is the execution strategy where a new container with specified
@@ -22,24 +87,30 @@ type createContainer struct {
 //
 // The container will be created and started with Cmd.Start and will be deleted
 // before Cmd.Wait returns.
-func ByCreatingContainer(opts docker.CreateContainerOptions) (Execution[Docker], error) {
+func ByCreatingContainer(opts docker.CreateContainerOptions, options ...ContainerOption) (Execution[Docker], error) {
 	if opts.Config == nil {
 		return nil, errors.New("dexec: Config is nil")
 	}
-	return &createContainer{opt: opts}, nil
+	c := &createContainer{opt: opts}
+	for _, o := range options {
+		o(c)
+	}
+	return c, nil
 }
 
 func (c *createContainer) setEnv(env []string) error {
-	if len(c.opt.Config.Env) > 0 {
-		return errors.New("dexec: Config.Env already set")
+	env, err := mergeEnv(c.envMerge, c.opt.Config.Env, env)
+	if err != nil {
+		return err
 	}
 	c.opt.Config.Env = env
 	return nil
 }
 
 func (c *createContainer) setDir(dir string) error {
-	if c.opt.Config.WorkingDir != "" {
-		return errors.New("dexec: Config.WorkingDir already set")
+	dir, err := mergeDir(c.dirMerge, c.opt.Config.WorkingDir, dir)
+	if err != nil {
+		return err
 	}
 	c.opt.Config.WorkingDir = dir
 	return nil
@@ -62,7 +133,19 @@ func (c *createContainer) create(d Docker, cmd []string) error {
 	c.opt.Config.StdinOnce = true
 	c.opt.Config.Cmd = nil        // clear cmd
 	c.opt.Config.Entrypoint = cmd // set new entrypoint
+	if c.timeout > 0 {
+		if c.opt.Config.Labels == nil {
+			c.opt.Config.Labels = make(map[string]string)
+		}
+		c.opt.Config.Labels[deadlineLabel] = time.Now().Add(c.timeout + timeoutBuffer).Format(time.RFC3339)
+	}
 
+	if err := c.ensureImage(d); err != nil {
+		return err
+	}
+	if err := c.createWorkspace(d); err != nil {
+		return err
+	}
 	container, err := c.createContainer(d)
 	if err != nil {
 		return fmt.Errorf("dexec: failed to create container: %w", err)
@@ -72,6 +155,88 @@ func (c *createContainer) create(d Docker, cmd []string) error {
 	return nil
 }
 
+// ensureImage pins the image and makes it available on the Docker host according to the pull policy
+func (c *createContainer) ensureImage(d Docker) error {
+	defer c.transaction.StartSegment("ensureImage").End()
+	image, pin, err := pinImage(c.context(), c.opt.Config.Image, c.image)
+	if err != nil {
+		return err
+	}
+	if pin != "" {
+		// a pinned image is looked up and pulled by digest, like containerd does, so that neither a tag moved in the
+		// registry nor a local tag referring to another image fails the command while the pinned image exists
+		if image, err = digestedReference(image, pin); err != nil {
+			return err
+		}
+	}
+	c.opt.Config.Image = image
+	c.digest = pin
+	policy := c.image.PullPolicy
+	if policy == "" && pin != "" {
+		policy = PullIfNotPresent
+	}
+	if policy == "" {
+		return nil
+	}
+	if policy != PullAlways {
+		_, err = d.Client.InspectImage(image)
+		if err != nil && !errors.Is(err, docker.ErrNoSuchImage) {
+			return fmt.Errorf("dexec: failed to inspect image: %w", err)
+		}
+		if err != nil && policy == PullNever {
+			return &ImageNotFoundError{Image: image, Err: err}
+		}
+	}
+	if policy == PullAlways || err != nil {
+		if err = c.pullImage(d); err != nil {
+			return err
+		}
+	}
+	if pin == "" {
+		return nil
+	}
+	if c.opt.Config.Labels == nil {
+		c.opt.Config.Labels = make(map[string]string)
+	}
+	c.opt.Config.Labels[imageDigestLabel] = pin.String()
+	return c.verifyDigest(d)
+}
+
+// verifyDigest makes sure the local image has the digest it is pinned to
+func (c *createContainer) verifyDigest(d Docker) error {
+	image := c.opt.Config.Image
+	img, err := d.Client.InspectImage(image)
+	if err != nil {
+		return fmt.Errorf("dexec: failed to inspect image: %w", err)
+	}
+	return verifyRepoDigests(image, img.RepoDigests, c.digest)
+}
+
+func (c *createContainer) pullImage(d Docker) error {
+	defer c.transaction.StartSegment("pullImage").End()
+	opts := docker.PullImageOptions{
+		Repository:   c.opt.Config.Image,
+		OutputStream: c.image.Progress,
+		Context:      c.context(),
+	}
+	if !strings.Contains(opts.Repository, "@") {
+		opts.Repository, opts.Tag = docker.ParseRepositoryTag(opts.Repository)
+	}
+	auth, err := c.image.Auth.dockerAuth(c.opt.Config.Image)
+	if err != nil {
+		return err
+	}
+	err = d.Client.PullImage(opts, auth)
+	var de *docker.Error
+	if errors.As(err, &de) && de.Status == http.StatusNotFound {
+		return &ImageNotFoundError{Image: c.opt.Config.Image, Err: err}
+	}
+	if err != nil {
+		return fmt.Errorf("dexec: failed to pull image: %w", err)
+	}
+	return nil
+}
+
 func (c *createContainer) run(d Docker, stdin io.Reader, stdout, stderr io.Writer) error {
 	if c.id == "" {
 		return errors.New("dexec: container is not created")
@@ -89,9 +254,82 @@ func (c *createContainer) run(d Docker, stdin io.Reader, stdout, stderr io.Write
 	return nil
 }
 
+func (c *createContainer) copyIn(d Docker, dst string, content io.Reader) error {
+	defer c.transaction.StartSegment("copyIn").End()
+	if c.id == "" {
+		return errors.New("dexec: container is not created")
+	}
+	return d.Client.UploadToContainer(c.id, docker.UploadToContainerOptions{
+		InputStream: content,
+		Path:        dst,
+	})
+}
+
+func (c *createContainer) copyOut(d Docker, src string, w io.Writer) error {
+	defer c.transaction.StartSegment("copyOut").End()
+	if c.id == "" {
+		return errors.New("dexec: container is not created")
+	}
+	return d.Client.DownloadFromContainer(c.id, docker.DownloadFromContainerOptions{
+		OutputStream: w,
+		Path:         src,
+	})
+}
+
+// createContainer creates the container. A named container is created again with a new name when its name is
+// already used.
 func (c *createContainer) createContainer(d Docker) (*docker.Container, error) {
 	defer c.transaction.StartSegment("createContainer").End()
-	return d.Client.CreateContainer(c.opt)
+	for attempt := 1; ; attempt++ {
+		if c.namePrefix != "" {
+			c.opt.Name = containerName(c.namePrefix, newID(d.IDs))
+		}
+		container, err := d.Client.CreateContainer(c.opt)
+		if c.namePrefix == "" || !errors.Is(err, docker.ErrContainerAlreadyExists) || attempt == maxNameAttempts {
+			return container, err
+		}
+	}
+}
+
+// createWorkspace creates the volume of the workspace and mounts it in the container. The volume is a tmpfs when
+// the size of the workspace is limited, and the content of the image is not copied into it.
+func (c *createContainer) createWorkspace(d Docker) error {
+	if c.workspace == nil {
+		return nil
+	}
+	defer c.transaction.StartSegment("createWorkspace").End()
+	opts := docker.CreateVolumeOptions{Name: workspaceName(), Context: c.context()}
+	if c.workspace.Size > 0 {
+		opts.DriverOpts = map[string]string{"type": "tmpfs", "device": "tmpfs", "o": fmt.Sprintf("size=%d", c.workspace.Size)}
+	}
+	volume, err := d.CreateVolume(opts)
+	if err != nil {
+		return fmt.Errorf("dexec: failed to create workspace volume: %w", err)
+	}
+	c.volume = volume.Name
+	if c.opt.HostConfig == nil {
+		c.opt.HostConfig = &docker.HostConfig{}
+	}
+	c.opt.HostConfig.Mounts = append(c.opt.HostConfig.Mounts, docker.HostMount{
+		Type:          MountVolume,
+		Source:        volume.Name,
+		Target:        c.workspace.Path,
+		VolumeOptions: &docker.VolumeOptions{NoCopy: true},
+	})
+	return nil
+}
+
+// removeWorkspace removes the volume of the workspace, which must no longer be used by the container
+func (c *createContainer) removeWorkspace(d Docker) error {
+	if c.volume == "" {
+		return nil
+	}
+	err := d.RemoveVolumeWithOptions(docker.RemoveVolumeOptions{Name: c.volume, Context: c.context()})
+	if err != nil && !errors.Is(err, docker.ErrNoSuchVolume) {
+		return fmt.Errorf("dexec: failed to remove workspace volume: %w", err)
+	}
+	c.volume = ""
+	return nil
 }
 
 func (c *createContainer) startContainer(d Docker) error {
@@ -115,8 +353,9 @@ func (c *createContainer) attachToContainerNonBlocking(d Docker, stdin io.Reader
 	return d.Client.AttachToContainerNonBlocking(opts)
 }
 
-func (c *createContainer) wait(d Docker) (exitCode int, err error) {
+func (c *createContainer) wait(d Docker, beforeRemove func() error) (exitCode int, err error) {
 	del := func() error { return d.RemoveContainer(docker.RemoveContainerOptions{ID: c.id, Force: true}) }
+	defer c.removeWorkspace(d)
 	defer del()
 	if c.cw == nil {
 		return -1, errors.New("dexec: container is not attached")
@@ -128,9 +367,17 @@ func (c *createContainer) wait(d Docker) (exitCode int, err error) {
 	if err != nil {
 		return -1, fmt.Errorf("dexec: cannot wait for container: %w", err)
 	}
+	if beforeRemove != nil {
+		if err := beforeRemove(); err != nil {
+			return ec, err
+		}
+	}
 	if err := del(); err != nil {
 		return -1, fmt.Errorf("dexec: error deleting container: %w", err)
 	}
+	if err := c.removeWorkspace(d); err != nil {
+		return ec, err
+	}
 	return ec, nil
 }
 
@@ -138,6 +385,17 @@ func (c *createContainer) getID() string {
 	return c.id
 }
 
+func (c *createContainer) imageDigest() string {
+	return c.digest.String()
+}
+
+func (c *createContainer) context() context.Context {
+	if c.opt.Context != nil {
+		return c.opt.Context
+	}
+	return context.Background()
+}
+
 func (c *createContainer) kill(d Docker) error {
 	var nsc *docker.NoSuchContainer
 	var cnr *docker.ContainerNotRunning
@@ -150,7 +408,16 @@ func (c *createContainer) kill(d Docker) error {
 	return fmt.Errorf("error stopping container: %w", err)
 }
 
+// cleanup removes the container and the volume of its workspace
 func (c *createContainer) cleanup(d Docker) error {
+	err := c.removeContainer(d)
+	if werr := c.removeWorkspace(d); err == nil {
+		err = werr
+	}
+	return err
+}
+
+func (c *createContainer) removeContainer(d Docker) error {
 	containerId := c.getID()
 	var nsc *docker.NoSuchContainer
 	err := d.StopContainer(containerId, 1)
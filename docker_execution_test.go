@@ -0,0 +1,295 @@
+package dexec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_createContainer_ensureImage(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   PullPolicy
+		present  bool
+		inRepo   bool
+		pulled   bool
+		notFound bool
+	}{
+		{name: "no policy"},
+		{name: "if not present, present", policy: PullIfNotPresent, present: true},
+		{name: "if not present, missing", policy: PullIfNotPresent, inRepo: true, pulled: true},
+		{name: "if not present, missing in registry", policy: PullIfNotPresent, pulled: true, notFound: true},
+		{name: "never, present", policy: PullNever, present: true},
+		{name: "never, missing", policy: PullNever, notFound: true},
+		{name: "always", policy: PullAlways, present: true, inRepo: true, pulled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pulled string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case strings.HasSuffix(r.URL.Path, "/images/busybox:1.36/json"):
+					if !tt.present {
+						http.Error(w, "no such image", http.StatusNotFound)
+						return
+					}
+					fmt.Fprint(w, `{"Id":"sha256:abc"}`)
+				case strings.HasSuffix(r.URL.Path, "/images/create"):
+					pulled = r.URL.Query().Get("fromImage") + ":" + r.URL.Query().Get("tag")
+					if !tt.inRepo {
+						http.Error(w, "manifest unknown", http.StatusNotFound)
+						return
+					}
+					fmt.Fprint(w, `{"status":"Downloaded newer image for busybox:1.36"}`)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer server.Close()
+			client, err := docker.NewClient(server.URL)
+			assert.NoError(t, err)
+
+			var progress strings.Builder
+			exec, err := ByCreatingContainer(docker.CreateContainerOptions{Config: &docker.Config{Image: "busybox:1.36"}},
+				WithImageOptions(ImageOptions{PullPolicy: tt.policy, Progress: &progress}))
+			assert.NoError(t, err)
+
+			err = exec.(*createContainer).ensureImage(Docker{Client: client})
+			var infe *ImageNotFoundError
+			assert.Equal(t, tt.notFound, errors.As(err, &infe))
+			if !tt.notFound {
+				assert.NoError(t, err)
+			}
+			if tt.pulled {
+				assert.Equal(t, "busybox:1.36", pulled)
+			} else {
+				assert.Empty(t, pulled)
+			}
+			if tt.pulled && tt.inRepo {
+				assert.Contains(t, progress.String(), "Downloaded newer image")
+			}
+		})
+	}
+}
+
+func Test_createContainer_ensureImage_Digest(t *testing.T) {
+	pinned := digest.FromString("pinned")
+	tests := []struct {
+		name        string
+		local       bool
+		inRepo      bool
+		repoDigests []string
+		pulled      bool
+		notFound    bool
+		mismatch    bool
+	}{
+		// busybox:1.36 refers to another image locally and in the registry, the pinned image is used anyway
+		{name: "local", local: true, repoDigests: []string{"busybox@" + pinned.String()}},
+		{name: "pulled", inRepo: true, repoDigests: []string{"busybox@" + pinned.String()}, pulled: true},
+		{name: "missing in registry", pulled: true, notFound: true},
+		{name: "other repository", local: true, repoDigests: []string{"acme/busybox@" + pinned.String()}, mismatch: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			present := tt.local
+			var pulled string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case strings.HasSuffix(r.URL.Path, "/images/busybox:1.36/json"):
+					_ = json.NewEncoder(w).Encode(docker.Image{ID: "sha256:retagged",
+						RepoDigests: []string{"busybox@" + digest.FromString("retagged").String()}})
+				case strings.HasSuffix(r.URL.Path, "/images/busybox@"+pinned.String()+"/json"):
+					if !present {
+						http.Error(w, "no such image", http.StatusNotFound)
+						return
+					}
+					_ = json.NewEncoder(w).Encode(docker.Image{ID: "sha256:abc", RepoDigests: tt.repoDigests})
+				case strings.HasSuffix(r.URL.Path, "/images/create"):
+					pulled = r.URL.Query().Get("fromImage") + "@" + r.URL.Query().Get("tag")
+					if !tt.inRepo {
+						http.Error(w, "manifest unknown", http.StatusNotFound)
+						return
+					}
+					present = true
+					fmt.Fprint(w, `{"status":"Downloaded newer image"}`)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer server.Close()
+			client, err := docker.NewClient(server.URL)
+			assert.NoError(t, err)
+
+			exec, _ := ByCreatingContainer(docker.CreateContainerOptions{Config: &docker.Config{Image: "busybox:1.36"}},
+				WithImageOptions(ImageOptions{Digest: pinned}))
+			c := exec.(*createContainer)
+			err = c.ensureImage(Docker{Client: client})
+			var infe *ImageNotFoundError
+			var dme *DigestMismatchError
+			assert.Equal(t, tt.notFound, errors.As(err, &infe))
+			assert.Equal(t, tt.mismatch, errors.As(err, &dme))
+			if tt.pulled {
+				assert.Equal(t, "busybox@"+pinned.String(), pulled)
+			} else {
+				assert.Empty(t, pulled)
+			}
+			if tt.notFound || tt.mismatch {
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "busybox@"+pinned.String(), c.opt.Config.Image)
+			assert.Equal(t, pinned.String(), c.opt.Config.Labels[imageDigestLabel])
+		})
+	}
+}
+
+func newFakeDockerCmd(t *testing.T, server *fakeDockerServer, name string, arg ...string) *DockerCmd {
+	exec, err := ByCreatingContainer(docker.CreateContainerOptions{Config: &docker.Config{Image: "busybox"}})
+	assert.NoError(t, err)
+	return server.Client(t).Command(exec, name, arg...)
+}
+
+func Test_createContainer_Run(t *testing.T) {
+	server := newFakeDockerServer(t, echoProcess)
+	cmd := newFakeDockerCmd(t, server, "echo", "hello", "world")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader("input")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = []string{"A=1"}
+	cmd.Dir = "/work"
+
+	assert.NoError(t, cmd.Start())
+	config := server.Config(cmd.GetPID())
+	assert.NoError(t, cmd.Wait())
+
+	assert.Equal(t, "hello world", stdout.String())
+	assert.Equal(t, "input", stderr.String())
+	assert.Equal(t, []string{"echo", "hello", "world"}, config.Entrypoint)
+	assert.Equal(t, []string{"A=1"}, config.Env)
+	assert.Equal(t, "/work", config.WorkingDir)
+	assert.True(t, config.AttachStdin && config.AttachStdout && config.AttachStderr && config.OpenStdin && config.StdinOnce)
+	assert.Equal(t, 0, cmd.GetProcessState().ExitCode)
+	assert.Empty(t, server.Containers())
+	assert.Equal(t, []string{cmd.GetPID()}, server.Removed())
+}
+
+func Test_createContainer_Output_ExitCode(t *testing.T) {
+	server := newFakeDockerServer(t, echoProcess)
+	cmd := newFakeDockerCmd(t, server, "echo", "out")
+	cmd.Env = []string{"EXIT_CODE=3"}
+	cmd.Stdin = strings.NewReader("err")
+
+	out, err := cmd.Output()
+	var ee *ExitError
+	assert.True(t, errors.As(err, &ee))
+	assert.Equal(t, 3, ee.ExitCode)
+	assert.Equal(t, "err", string(ee.Stderr))
+	assert.Equal(t, "out", string(out))
+	assert.Equal(t, &ProcessState{ID: cmd.GetPID(), Exited: true, ExitCode: 3}, cmd.GetProcessState())
+	assert.Empty(t, server.Containers())
+}
+
+func Test_createContainer_CombinedOutput(t *testing.T) {
+	server := newFakeDockerServer(t, echoProcess)
+	cmd := newFakeDockerCmd(t, server, "echo", "out")
+	cmd.Stdin = strings.NewReader("err")
+
+	out, err := cmd.CombinedOutput()
+	assert.NoError(t, err)
+	assert.Equal(t, "outerr", string(out))
+}
+
+func Test_createContainer_Kill(t *testing.T) {
+	server := newFakeDockerServer(t, func(p *fakeProcess) int {
+		<-p.Stopped
+		return 0
+	})
+	cmd := newFakeDockerCmd(t, server, "sleep", "1000")
+
+	assert.NoError(t, cmd.Start())
+	assert.NoError(t, cmd.Kill())
+	var ee *ExitError
+	assert.True(t, errors.As(cmd.Wait(), &ee))
+	assert.Equal(t, 137, ee.ExitCode)
+	assert.Empty(t, server.Containers())
+
+	// the container is gone, so there is nothing to kill or clean up
+	assert.NoError(t, cmd.Kill())
+	assert.NoError(t, cmd.Cleanup())
+}
+
+func Test_createContainer_Errors(t *testing.T) {
+	tests := []struct {
+		op      string
+		wantErr string
+		removed bool
+	}{
+		{op: "create", wantErr: "dexec: failed to create container"},
+		{op: "start", wantErr: "dexec: failed to start container"},
+		{op: "wait", wantErr: "dexec: cannot wait for container", removed: true},
+		{op: "remove", wantErr: "dexec: error deleting container"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.op, func(t *testing.T) {
+			server := newFakeDockerServer(t, echoProcess)
+			server.Fail(tt.op, http.StatusInternalServerError)
+			cmd := newFakeDockerCmd(t, server, "echo")
+
+			err := cmd.Run()
+			assert.ErrorContains(t, err, tt.wantErr)
+			assert.Equal(t, tt.removed, len(server.Removed()) == 1)
+		})
+	}
+}
+
+func Test_createContainer_Cleanup(t *testing.T) {
+	blocked := func(p *fakeProcess) int {
+		<-p.Stopped
+		return 0
+	}
+	tests := []struct {
+		name    string
+		process func(p *fakeProcess) int
+		exited  bool
+		fail    string
+		wantErr string
+		removed bool
+	}{
+		{name: "running", process: blocked, removed: true},
+		{name: "exited", process: echoProcess, exited: true, removed: true},
+		{name: "stop error", process: blocked, fail: "stop", wantErr: "error stopping container"},
+		{name: "remove error", process: echoProcess, exited: true, fail: "remove", wantErr: "error removing container"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeDockerServer(t, tt.process)
+			cmd := newFakeDockerCmd(t, server, "echo")
+			assert.NoError(t, cmd.Start())
+			if tt.fail != "" {
+				server.Fail(tt.fail, http.StatusInternalServerError)
+			}
+			if tt.exited {
+				// let the command exit without removing its container
+				_, err := server.Client(t).WaitContainer(cmd.GetPID())
+				assert.NoError(t, err)
+			}
+
+			err := cmd.Cleanup()
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.removed, len(server.Removed()) == 1)
+		})
+	}
+}
@@ -0,0 +1,643 @@
+package dexec
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeProcess is the process of a container of fakeDockerServer
+type fakeProcess struct {
+	Args    []string
+	Env     []string
+	Dir     string
+	Stdin   io.Reader
+	Stdout  io.Writer
+	Stderr  io.Writer
+	Stopped <-chan struct{}
+	// Files are the regular files of the container by path, which the process may read and write
+	Files map[string][]byte
+}
+
+type fakeContainer struct {
+	id         string
+	name       string
+	config     docker.Config
+	hostConfig docker.HostConfig
+	uploads    []string
+	files      map[string][]byte
+	running    bool
+	attached   bool
+	exitCode   int
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// exit records the exit of the container, it must be called with the server lock held
+func (c *fakeContainer) exit(code int) {
+	if !c.running {
+		return
+	}
+	c.running = false
+	c.exitCode = code
+	close(c.done)
+}
+
+// fakeDockerServer is an in-process stand-in for the Docker API covering the container lifecycle used by
+// ByCreatingContainer: create, start, attach, wait, stop and remove, uploads and downloads of archives and volumes. Containers
+// run Process when they are attached to, and its output is sent over the attach stream multiplexed like the Docker
+// daemon does. The regular files uploaded to a container are kept in memory, where its process finds them.
+type fakeDockerServer struct {
+	*httptest.Server
+	// Process is run by attached containers and returns their exit code
+	Process func(p *fakeProcess) int
+
+	mu         sync.Mutex
+	failures   map[string]int
+	containers map[string]*fakeContainer
+	removed    []string
+	volumes    map[string]docker.CreateVolumeOptions
+	nextID     int
+}
+
+var (
+	fakeDockerPath = regexp.MustCompile(`^(?:/v[0-9.]+)?/containers/([^/]+)(?:/([a-z]+))?$`)
+	fakeVolumePath = regexp.MustCompile(`^(?:/v[0-9.]+)?/volumes/([^/]+)$`)
+)
+
+func newFakeDockerServer(t *testing.T, process func(p *fakeProcess) int) *fakeDockerServer {
+	s := &fakeDockerServer{
+		Process:    process,
+		failures:   map[string]int{},
+		containers: map[string]*fakeContainer{},
+		volumes:    map[string]docker.CreateVolumeOptions{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Client returns a Docker client connected to the server
+func (s *fakeDockerServer) Client(t *testing.T) Docker {
+	client, err := docker.NewClient(s.URL)
+	assert.NoError(t, err)
+	return Docker{Client: client}
+}
+
+// Fail makes the operation (create, start, wait, stop or remove) fail with the HTTP status code
+func (s *fakeDockerServer) Fail(op string, code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[op] = code
+}
+
+// Containers returns the IDs of the containers that have not been removed
+func (s *fakeDockerServer) Containers() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for id := range s.containers {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Removed returns the IDs of the removed containers
+func (s *fakeDockerServer) Removed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.removed...)
+}
+
+// Config returns the configuration the container was created with
+func (s *fakeDockerServer) Config(id string) docker.Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.containers[id].config
+}
+
+// Name returns the name the container was created with, empty if unnamed
+func (s *fakeDockerServer) Name(id string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.containers[id].name
+}
+
+// HostConfig returns the host configuration the container was created with
+func (s *fakeDockerServer) HostConfig(id string) docker.HostConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.containers[id].hostConfig
+}
+
+// Uploads returns the paths of the files and directories uploaded to the container
+func (s *fakeDockerServer) Uploads(id string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.containers[id].uploads...)
+}
+
+// Volumes returns the names of the volumes that have not been removed
+func (s *fakeDockerServer) Volumes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// VolumeOptions returns the options the volume was created with
+func (s *fakeDockerServer) VolumeOptions(name string) docker.CreateVolumeOptions {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.volumes[name]
+}
+
+func (s *fakeDockerServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if m := fakeVolumePath.FindStringSubmatch(r.URL.Path); m != nil {
+		s.serveVolume(w, r, m[1])
+		return
+	}
+	m := fakeDockerPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	id, op := m[1], m[2]
+	if id == "json" && r.Method == http.MethodGet {
+		s.list(w, r)
+		return
+	} else if id == "create" && r.Method == http.MethodPost {
+		op = "create"
+	} else if op == "" && r.Method == http.MethodDelete {
+		op = "remove"
+	}
+	s.mu.Lock()
+	code, ok := s.failures[op]
+	s.mu.Unlock()
+	if ok {
+		http.Error(w, op+" failed", code)
+		return
+	}
+	if op == "create" {
+		s.create(w, r)
+		return
+	}
+	s.mu.Lock()
+	c, ok := s.containers[id]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "No such container: "+id, http.StatusNotFound)
+		return
+	}
+	switch op {
+	case "start":
+		s.start(w, c)
+	case "attach":
+		s.attach(w, c)
+	case "wait":
+		s.wait(w, r, c)
+	case "stop":
+		s.stop(w, c)
+	case "remove":
+		s.remove(w, r, c)
+	case "archive":
+		if r.Method == http.MethodGet {
+			s.download(w, r, c)
+			return
+		}
+		s.upload(w, r, c)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *fakeDockerServer) create(w http.ResponseWriter, r *http.Request) {
+	var config struct {
+		docker.Config
+		HostConfig docker.HostConfig
+	}
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	s.mu.Lock()
+	for _, c := range s.containers {
+		if name != "" && c.name == name {
+			s.mu.Unlock()
+			http.Error(w, fmt.Sprintf("Conflict. The container name %q is already in use", name), http.StatusConflict)
+			return
+		}
+	}
+	s.nextID++
+	c := &fakeContainer{id: fmt.Sprintf("container-%d", s.nextID), name: name, config: config.Config, hostConfig: config.HostConfig,
+		files: map[string][]byte{}}
+	s.containers[c.id] = c
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"Id": c.id})
+}
+
+// list returns the containers with all the labels of the label filters
+func (s *fakeDockerServer) list(w http.ResponseWriter, r *http.Request) {
+	var filters map[string][]string
+	if f := r.URL.Query().Get("filters"); f != "" {
+		if err := json.Unmarshal([]byte(f), &filters); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	containers := []docker.APIContainers{}
+	for _, c := range s.containers {
+		matches := true
+		for _, label := range filters["label"] {
+			key, value, _ := strings.Cut(label, "=")
+			if v, ok := c.config.Labels[key]; !ok || v != value {
+				matches = false
+			}
+		}
+		if !matches {
+			continue
+		}
+		state := "created"
+		if c.running {
+			state = "running"
+		} else if c.done != nil {
+			state = "exited"
+		}
+		containers = append(containers, docker.APIContainers{ID: c.id, Names: []string{"/" + c.name}, Labels: c.config.Labels, State: state})
+	}
+	json.NewEncoder(w).Encode(containers)
+}
+
+func (s *fakeDockerServer) start(w http.ResponseWriter, c *fakeContainer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.running {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	c.running = true
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// attach hijacks the connection and runs the container process over it
+func (s *fakeDockerServer) attach(w http.ResponseWriter, c *fakeContainer) {
+	s.mu.Lock()
+	if !c.running || c.attached {
+		s.mu.Unlock()
+		http.Error(w, "container is not running", http.StatusConflict)
+		return
+	}
+	c.attached = true
+	s.mu.Unlock()
+
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	fmt.Fprint(rw, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	rw.Flush()
+
+	s.mu.Lock()
+	files := make(map[string][]byte, len(c.files))
+	for name, content := range c.files {
+		files[name] = content
+	}
+	s.mu.Unlock()
+	var wmu sync.Mutex
+	ec := s.Process(&fakeProcess{
+		Args:    c.config.Entrypoint,
+		Env:     c.config.Env,
+		Dir:     c.config.WorkingDir,
+		Stdin:   rw.Reader,
+		Stdout:  &stdWriter{w: conn, mu: &wmu, stream: 1},
+		Stderr:  &stdWriter{w: conn, mu: &wmu, stream: 2},
+		Stopped: c.stop,
+		Files:   files,
+	})
+	s.mu.Lock()
+	c.files = files
+	select {
+	case <-c.stop:
+		ec = 137
+	default:
+	}
+	c.exit(ec)
+	s.mu.Unlock()
+}
+
+func (s *fakeDockerServer) wait(w http.ResponseWriter, r *http.Request, c *fakeContainer) {
+	s.mu.Lock()
+	done := c.done
+	s.mu.Unlock()
+	if done == nil {
+		http.Error(w, "container is not started", http.StatusConflict)
+		return
+	}
+	select {
+	case <-done:
+	case <-r.Context().Done():
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]int{"StatusCode": c.exitCode})
+}
+
+func (s *fakeDockerServer) stop(w http.ResponseWriter, c *fakeContainer) {
+	if !s.kill(c) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// kill stops the container and waits for it to exit, it returns false if the container was not running
+func (s *fakeDockerServer) kill(c *fakeContainer) bool {
+	s.mu.Lock()
+	if !c.running {
+		s.mu.Unlock()
+		return false
+	}
+	select {
+	case <-c.stop:
+	default:
+		close(c.stop)
+	}
+	if !c.attached {
+		c.exit(137)
+	}
+	done := c.done
+	s.mu.Unlock()
+	<-done
+	return true
+}
+
+func (s *fakeDockerServer) remove(w http.ResponseWriter, r *http.Request, c *fakeContainer) {
+	s.mu.Lock()
+	running := c.running
+	s.mu.Unlock()
+	if running {
+		if r.URL.Query().Get("force") != "1" && r.URL.Query().Get("force") != "true" {
+			http.Error(w, "You cannot remove a running container "+c.id, http.StatusConflict)
+			return
+		}
+		s.kill(c)
+	}
+	s.mu.Lock()
+	delete(s.containers, c.id)
+	s.removed = append(s.removed, c.id)
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// upload records the entries of the archive uploaded to the container and keeps its regular files
+func (s *fakeDockerServer) upload(w http.ResponseWriter, r *http.Request, c *fakeContainer) {
+	if r.Method != http.MethodPut {
+		http.NotFound(w, r)
+		return
+	}
+	tr := tar.NewReader(r.Body)
+	var names []string
+	files := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		name := path.Join(r.URL.Query().Get("path"), hdr.Name)
+		names = append(names, name)
+		if hdr.Typeflag == tar.TypeReg {
+			if files[name], err = io.ReadAll(tr); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	s.mu.Lock()
+	c.uploads = append(c.uploads, names...)
+	for name, content := range files {
+		c.files[name] = content
+	}
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+// download writes the archive of the file or directory at the path of the container, whose entries are named after
+// the base name of the path like the Docker daemon does
+func (s *fakeDockerServer) download(w http.ResponseWriter, r *http.Request, c *fakeContainer) {
+	src := path.Clean(r.URL.Query().Get("path"))
+	s.mu.Lock()
+	var names []string
+	for name := range c.files {
+		if name == src || strings.HasPrefix(name, src+"/") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	files := make(map[string][]byte, len(names))
+	for _, name := range names {
+		files[name] = c.files[name]
+	}
+	s.mu.Unlock()
+	if len(names) == 0 {
+		http.Error(w, "Could not find the file "+src+" in container "+c.id, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-tar")
+	tw := tar.NewWriter(w)
+	for _, name := range names {
+		content := files[name]
+		hdr := &tar.Header{Name: path.Join(path.Base(src), strings.TrimPrefix(name, src)), Mode: 0644, Size: int64(len(content)),
+			Typeflag: tar.TypeReg}
+		if tw.WriteHeader(hdr) != nil {
+			return
+		}
+		tw.Write(content)
+	}
+	tw.Close()
+}
+
+// serveVolume creates and removes volumes. Volumes mounted by a container cannot be removed.
+func (s *fakeDockerServer) serveVolume(w http.ResponseWriter, r *http.Request, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case name == "create" && r.Method == http.MethodPost:
+		var opts docker.CreateVolumeOptions
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.volumes[opts.Name] = opts
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(docker.Volume{Name: opts.Name})
+	case r.Method == http.MethodDelete:
+		if _, ok := s.volumes[name]; !ok {
+			http.Error(w, "no such volume", http.StatusNotFound)
+			return
+		}
+		for _, c := range s.containers {
+			for _, m := range c.hostConfig.Mounts {
+				if m.Type == "volume" && m.Source == name {
+					http.Error(w, "volume is in use", http.StatusConflict)
+					return
+				}
+			}
+		}
+		delete(s.volumes, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// stdWriter writes frames of the stream multiplexing used by the Docker attach endpoint
+type stdWriter struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	stream byte
+}
+
+func (w *stdWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	header := make([]byte, 8)
+	header[0] = w.stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(p)))
+	if _, err := w.w.Write(header); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
+// echoProcess writes its arguments to stdout and stdin to stderr, and exits with the code in its EXIT_CODE
+// environment variable
+func echoProcess(p *fakeProcess) int {
+	fmt.Fprint(p.Stdout, strings.Join(p.Args[1:], " "))
+	io.Copy(p.Stderr, p.Stdin)
+	for _, e := range p.Env {
+		if strings.HasPrefix(e, "EXIT_CODE=") {
+			var ec int
+			fmt.Sscan(strings.TrimPrefix(e, "EXIT_CODE="), &ec)
+			return ec
+		}
+	}
+	return 0
+}
+
+// catProcess writes the files of the container named by its arguments to stdout
+func catProcess(p *fakeProcess) int {
+	for _, name := range p.Args[1:] {
+		content, ok := p.Files[name]
+		if !ok {
+			fmt.Fprintf(p.Stderr, "cat: can't open '%s': No such file or directory\n", name)
+			return 1
+		}
+		p.Stdout.Write(content)
+	}
+	return 0
+}
+
+func TestFakeDockerServer_CopyIn(t *testing.T) {
+	server := newFakeDockerServer(t, catProcess)
+	cmd := newFakeDockerCmd(t, server, "cat", "/tmp/input.txt", "/tmp/dir/data.txt")
+	src := filepath.Join(t.TempDir(), "input.txt")
+	assert.NoError(t, os.WriteFile(src, []byte("Hello, world!"), 0644))
+	assert.NoError(t, cmd.CopyFileIn(src, "/tmp/input.txt"))
+	assert.NoError(t, cmd.CopyIn("/tmp", tarOf(t, "dir/data.txt", " Bye!")))
+
+	b, err := cmd.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello, world! Bye!", string(b))
+}
+
+func TestFakeDockerServer_CopyInAfterStart(t *testing.T) {
+	server := newFakeDockerServer(t, echoProcess)
+	cmd := newFakeDockerCmd(t, server, "echo")
+	assert.NoError(t, cmd.Start())
+	assert.EqualError(t, cmd.CopyIn("/tmp", bytes.NewReader(nil)), "dexec: already started")
+	assert.EqualError(t, cmd.CopyFileIn(t.TempDir(), "/tmp"), "dexec: already started")
+	assert.Empty(t, server.Uploads(cmd.GetPID()))
+	assert.NoError(t, cmd.Wait())
+}
+
+// resultProcess writes the file /tmp/out/result.txt
+func resultProcess(p *fakeProcess) int {
+	p.Files["/tmp/out/result.txt"] = []byte("result\n")
+	return 0
+}
+
+func TestFakeDockerServer_Artifacts(t *testing.T) {
+	server := newFakeDockerServer(t, resultProcess)
+	cmd := newFakeDockerCmd(t, server, "sh", "-c", "echo result > /tmp/out/result.txt")
+	dir := t.TempDir()
+	var output bytes.Buffer
+	cmd.Artifacts = Artifacts{Paths: []string{"/tmp/out/result.txt", "/tmp/out"}, Dir: dir, Output: &output}
+	assert.NoError(t, cmd.Run())
+
+	// the fake server has forgotten the files of the container once it is removed, so they were collected before
+	assert.Empty(t, server.Containers())
+	assert.Equal(t, []string{cmd.GetPID()}, server.Removed())
+	b, err := os.ReadFile(filepath.Join(dir, "result.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "result\n", string(b))
+	b, err = os.ReadFile(filepath.Join(dir, "out", "result.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "result\n", string(b))
+	var names []string
+	tr := tar.NewReader(&output)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+	assert.Equal(t, []string{"result.txt", "out/result.txt"}, names)
+}
+
+func TestFakeDockerServer_Artifacts_Missing(t *testing.T) {
+	server := newFakeDockerServer(t, resultProcess)
+	cmd := newFakeDockerCmd(t, server, "true")
+	cmd.Artifacts = Artifacts{Paths: []string{"/tmp/missing.txt"}, Dir: t.TempDir()}
+	err := cmd.Run()
+	assert.ErrorContains(t, err, "dexec: failed to collect artifact /tmp/missing.txt")
+	var de *docker.Error
+	assert.True(t, errors.As(err, &de))
+	assert.Equal(t, http.StatusNotFound, de.Status)
+	// the container is removed even though the artifacts could not be collected
+	assert.Empty(t, server.Containers())
+}
@@ -0,0 +1,68 @@
+package dexec
+
+import (
+	"fmt"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/sirupsen/logrus"
+)
+
+func getDockerStats(d Docker) (Stats, error) {
+	containers, err := ownedDockerContainers(d)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	return processDockerContainers(containers), nil
+}
+
+func getDockerStatsBy(d Docker, key string) (map[string]Stats, error) {
+	containers, err := ownedDockerContainers(d)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string][]docker.APIContainers)
+	for _, container := range containers {
+		value := container.Labels[MetadataLabelPrefix+key]
+		groups[value] = append(groups[value], container)
+	}
+	stats := make(map[string]Stats, len(groups))
+	for value, group := range groups {
+		stats[value] = processDockerContainers(group)
+	}
+	return stats, nil
+}
+
+// ownedDockerContainers returns the containers of the owner of d, whatever their state
+func ownedDockerContainers(d Docker) ([]docker.APIContainers, error) {
+	containers, err := d.Client.ListContainers(docker.ListContainersOptions{
+		All:     true,
+		Filters: map[string][]string{"label": {ownerLabel + "=" + ownerOrDefault(d.Owner)}},
+	})
+	if err != nil {
+		logrus.Warnf("stats: unable to get containers: %v", err)
+		return nil, fmt.Errorf("error getting stats: %w", err)
+	}
+	return containers, nil
+}
+
+func processDockerContainers(containers []docker.APIContainers) Stats {
+	stats := Stats{}
+	for _, container := range containers {
+		stats.addDeadline(container.Labels)
+		switch container.State {
+		case "created":
+			stats.Created += 1
+		case "running":
+			stats.Running += 1
+		case "paused":
+			stats.Paused += 1
+		case "exited", "dead":
+			stats.Stopped += 1
+		default:
+			stats.Unknown += 1
+		}
+	}
+	return stats
+}
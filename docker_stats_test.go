@@ -0,0 +1,58 @@
+package dexec
+
+import (
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetStats_Docker(t *testing.T) {
+	server := newFakeDockerServer(t, echoProcess)
+	d := server.Client(t)
+	create := func(labels map[string]string, start bool) {
+		c, err := d.Client.CreateContainer(docker.CreateContainerOptions{Config: &docker.Config{Image: "busybox", Labels: labels}})
+		assert.NoError(t, err)
+		if start {
+			assert.NoError(t, d.Client.StartContainer(c.ID, nil))
+		}
+	}
+	create(map[string]string{ownerLabel: DefaultOwner, MetadataLabelPrefix + "build": "1"}, true)
+	create(map[string]string{ownerLabel: DefaultOwner, MetadataLabelPrefix + "build": "1"}, false)
+	create(map[string]string{ownerLabel: DefaultOwner, deadlineLabel: time.Now().Add(-time.Minute).Format(time.RFC3339)}, true)
+	create(map[string]string{ownerLabel: "ci", MetadataLabelPrefix + "build": "2"}, true)
+	create(nil, true)
+
+	stats, err := GetStats(d)
+	assert.NoError(t, err)
+	assert.Equal(t, Stats{Running: 2, Created: 1, DeadlineExceeded: 1}, stats)
+
+	d.Owner = "ci"
+	stats, err = GetStats(d)
+	assert.NoError(t, err)
+	assert.Equal(t, Stats{Running: 1}, stats)
+
+	d.Owner = ""
+	statsBy, err := GetStatsBy(d, "build")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]Stats{"1": {Running: 1, Created: 1}, "": {Running: 1, DeadlineExceeded: 1}}, statsBy)
+}
+
+func Test_processDockerContainers(t *testing.T) {
+	stats := processDockerContainers([]docker.APIContainers{
+		{State: "running"},
+		{State: "exited", Labels: map[string]string{deadlineLabel: "not-a-real-time"}},
+		{State: "dead"},
+		{State: "paused"},
+		{State: "restarting"},
+	})
+	assert.Equal(t, Stats{Running: 1, Stopped: 2, Paused: 1, Unknown: 1, Errors: 1}, stats)
+}
+
+func TestGetStats_Docker_Error(t *testing.T) {
+	server := newFakeDockerServer(t, echoProcess)
+	server.Close()
+	_, err := GetStats(server.Client(t))
+	assert.ErrorContains(t, err, "error getting stats: ")
+}
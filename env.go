@@ -0,0 +1,153 @@
+package dexec
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// MergeMode determines how Cmd.Env and Cmd.Dir are merged with the environment and the
+// working directory of the config of a command. The environment of the image is always
+// inherited by the container; the merged environment overrides it by key.
+type MergeMode string
+
+const (
+	// MergeStrict fails to start the command when both the config and Cmd set the
+	// environment or the working directory. It is the default.
+	MergeStrict MergeMode = ""
+	// MergeReplace uses Cmd.Env or Cmd.Dir instead of the config
+	MergeReplace MergeMode = "replace"
+	// MergeAppend appends Cmd.Env to the environment of the config, so that both are
+	// passed to the container, and resolves a relative Cmd.Dir against the working
+	// directory of the config
+	MergeAppend MergeMode = "append"
+	// MergeOverride overrides the variables of the config with the variables of Cmd.Env
+	// that have the same key, keeping the order of the config. Cmd.Dir replaces the
+	// working directory of the config, and a relative Cmd.Dir is resolved against it.
+	MergeOverride MergeMode = "override"
+)
+
+func (m MergeMode) valid() bool {
+	switch m {
+	case MergeStrict, MergeReplace, MergeAppend, MergeOverride:
+		return true
+	}
+	return false
+}
+
+// mergeEnv merges env, set with Cmd.Env, into the environment base of the config
+func mergeEnv(mode MergeMode, base, env []string) ([]string, error) {
+	if len(base) == 0 {
+		return env, nil
+	}
+	switch mode {
+	case MergeStrict:
+		return nil, errors.New("dexec: Config.Env already set")
+	case MergeReplace:
+		return env, nil
+	case MergeAppend:
+		return append(append([]string(nil), base...), env...), nil
+	case MergeOverride:
+		return overrideEnv(base, env), nil
+	}
+	return nil, fmt.Errorf("dexec: unknown merge mode %q", mode)
+}
+
+// overrideEnv returns base with the variables of env replacing the variables with the same key
+func overrideEnv(base, env []string) []string {
+	merged := append([]string(nil), base...)
+	index := make(map[string]int, len(merged))
+	for i, e := range merged {
+		index[envKey(e)] = i
+	}
+	for _, e := range env {
+		if i, ok := index[envKey(e)]; ok {
+			merged[i] = e
+			continue
+		}
+		index[envKey(e)] = len(merged)
+		merged = append(merged, e)
+	}
+	return merged
+}
+
+func envKey(e string) string {
+	key, _, _ := strings.Cut(e, "=")
+	return key
+}
+
+// mergeDir merges dir, set with Cmd.Dir, into the working directory base of the config
+func mergeDir(mode MergeMode, base, dir string) (string, error) {
+	if base == "" {
+		return dir, nil
+	}
+	switch mode {
+	case MergeStrict:
+		return "", errors.New("dexec: Config.WorkingDir already set")
+	case MergeReplace:
+		return dir, nil
+	case MergeAppend, MergeOverride:
+		if path.IsAbs(dir) {
+			return dir, nil
+		}
+		return path.Join(base, dir), nil
+	}
+	return "", fmt.Errorf("dexec: unknown merge mode %q", mode)
+}
+
+// ReadEnvFile reads the variables of an env file. Every line is a KEY=VALUE pair,
+// optionally preceded by "export " and with the value in single or double quotes.
+// Empty lines and lines starting with # are ignored.
+func ReadEnvFile(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("dexec: failed to read env file: %w", err)
+	}
+	defer f.Close()
+
+	var env []string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("dexec: invalid env file %s:%d: %q is not in the KEY=VALUE format", name, n, line)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env = append(env, key+"="+value)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("dexec: failed to read env file: %w", err)
+	}
+	return env, nil
+}
+
+// loadEnvFiles returns config with the variables of its env files in ContainerConfig.Env.
+// The variables of Env override the variables of the files, and later files override
+// earlier ones.
+func loadEnvFiles(config Config) (Config, error) {
+	if len(config.ContainerConfig.EnvFiles) == 0 {
+		return config, nil
+	}
+	var env []string
+	for _, name := range config.ContainerConfig.EnvFiles {
+		vars, err := ReadEnvFile(name)
+		if err != nil {
+			return config, err
+		}
+		env = overrideEnv(env, vars)
+	}
+	config.ContainerConfig.Env = overrideEnv(env, config.ContainerConfig.Env)
+	config.ContainerConfig.EnvFiles = nil
+	return config, nil
+}
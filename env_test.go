@@ -0,0 +1,147 @@
+package dexec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_mergeEnv(t *testing.T) {
+	base := []string{"A=1", "B=2"}
+	tests := []struct {
+		mode MergeMode
+		base []string
+		want []string
+		err  string
+	}{
+		{mode: MergeStrict, base: base, err: "dexec: Config.Env already set"},
+		{mode: MergeStrict, want: []string{"B=3", "C=4"}},
+		{mode: MergeReplace, base: base, want: []string{"B=3", "C=4"}},
+		{mode: MergeAppend, base: base, want: []string{"A=1", "B=2", "B=3", "C=4"}},
+		{mode: MergeOverride, base: base, want: []string{"A=1", "B=3", "C=4"}},
+		{mode: "merge", base: base, err: `dexec: unknown merge mode "merge"`},
+	}
+	for _, tt := range tests {
+		env, err := mergeEnv(tt.mode, tt.base, []string{"B=3", "C=4"})
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, tt.mode)
+			continue
+		}
+		assert.NoError(t, err, tt.mode)
+		assert.Equal(t, tt.want, env, tt.mode)
+	}
+	assert.Equal(t, []string{"A=1", "B=2"}, base)
+}
+
+func Test_mergeDir(t *testing.T) {
+	tests := []struct {
+		mode MergeMode
+		base string
+		dir  string
+		want string
+		err  string
+	}{
+		{mode: MergeStrict, base: "/work", dir: "src", err: "dexec: Config.WorkingDir already set"},
+		{mode: MergeStrict, dir: "/src", want: "/src"},
+		{mode: MergeReplace, base: "/work", dir: "src", want: "src"},
+		{mode: MergeAppend, base: "/work", dir: "src", want: "/work/src"},
+		{mode: MergeOverride, base: "/work", dir: "../src", want: "/src"},
+		{mode: MergeOverride, base: "/work", dir: "/src", want: "/src"},
+	}
+	for _, tt := range tests {
+		dir, err := mergeDir(tt.mode, tt.base, tt.dir)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, tt.mode)
+			continue
+		}
+		assert.NoError(t, err, tt.mode)
+		assert.Equal(t, tt.want, dir, tt.mode)
+	}
+}
+
+func TestReadEnvFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), ".env")
+	assert.NoError(t, os.WriteFile(name, []byte("# comment\nA=1\n\nexport B = two words \nC=\"quoted value\"\nD='x'\nE=\nF=a=b\n"), 0600))
+
+	env, err := ReadEnvFile(name)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A=1", "B=two words", "C=quoted value", "D=x", "E=", "F=a=b"}, env)
+
+	assert.NoError(t, os.WriteFile(name, []byte("A=1\nNOVALUE\n"), 0600))
+	_, err = ReadEnvFile(name)
+	assert.EqualError(t, err, `dexec: invalid env file `+name+`:2: "NOVALUE" is not in the KEY=VALUE format`)
+
+	_, err = ReadEnvFile(filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorContains(t, err, "dexec: failed to read env file")
+}
+
+func Test_loadEnvFiles(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.env"), []byte("A=1\nB=1\nC=1\n"), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "b.env"), []byte("B=2\n"), 0600))
+	config := Config{ContainerConfig: ContainerConfig{
+		Env:      []string{"C=3", "D=3"},
+		EnvFiles: []string{filepath.Join(dir, "a.env"), filepath.Join(dir, "b.env")},
+	}}
+
+	config, err := loadEnvFiles(config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A=1", "B=2", "C=3", "D=3"}, config.ContainerConfig.Env)
+	assert.Nil(t, config.ContainerConfig.EnvFiles)
+}
+
+func TestCommand_EnvMerge(t *testing.T) {
+	config := Config{
+		ContainerConfig: ContainerConfig{Env: []string{"A=1", "B=1"}, EnvMerge: MergeOverride},
+		TaskConfig:      TaskConfig{WorkingDir: t.TempDir(), DirMerge: MergeAppend},
+	}
+	assert.NoError(t, os.Mkdir(filepath.Join(config.TaskConfig.WorkingDir, "sub"), 0755))
+	cmd := newLocalCmd(t, &Local{}, config, `printf '%s %s %s' "$A" "$B" "$(basename "$(pwd)")"`)
+	cmd.Env = []string{"B=2"}
+	cmd.Dir = "sub"
+
+	out, err := cmd.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "1 2 sub", string(out))
+}
+
+func TestCommand_DirMerge_Docker(t *testing.T) {
+	tests := []struct {
+		mode MergeMode
+		dir  string
+		want string
+		err  string
+	}{
+		{mode: MergeStrict, want: "/app"},
+		{mode: MergeStrict, dir: "sub", err: "dexec: Config.WorkingDir already set"},
+		{mode: MergeReplace, dir: "sub", want: "sub"},
+		{mode: MergeAppend, dir: "sub", want: "/app/sub"},
+		{mode: MergeAppend, dir: "/other", want: "/other"},
+		{mode: MergeOverride, dir: "sub", want: "/app/sub"},
+		{mode: MergeOverride, dir: "/other", want: "/other"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode)+" "+tt.dir, func(t *testing.T) {
+			server := newFakeDockerServer(t, func(p *fakeProcess) int {
+				fmt.Fprint(p.Stdout, p.Dir)
+				return 0
+			})
+			cmd := Command(server.Client(t).Client, Config{
+				ContainerConfig: ContainerConfig{Image: "busybox"},
+				TaskConfig:      TaskConfig{Executable: "pwd", WorkingDir: "/app", DirMerge: tt.mode},
+			})
+			cmd.SetDir(tt.dir)
+
+			out, err := cmd.Output()
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(out))
+		})
+	}
+}
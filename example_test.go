@@ -3,7 +3,7 @@ package dexec_test
 func ExampleCmd_Output() {
 	// AA: disabling docker tests for skynet
 	/*	cl, _ := docker.NewClient("unix:///var/run/docker.sock")
-			d := dexec.Docker{cl}
+			d := dexec.Docker{Client: cl}
 
 			m, _ := dexec.ByCreatingContainer(docker.CreateContainerOptions{
 				Config: &docker.Config{Image: "busybox"}})
@@ -14,7 +14,7 @@ from
 container`
 
 	cl, _ := docker.NewClientFromEnv()
-	d := dexec.Docker{cl}
+	d := dexec.Docker{Client: cl}
 
 	m, _ := dexec.ByCreatingContainer(docker.CreateContainerOptions{
 		Config: &docker.Config{Image: "busybox"}})
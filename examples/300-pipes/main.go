@@ -10,7 +10,7 @@ import (
 
 func main() {
 	cl, _ := docker.NewClientFromEnv()
-	d := dexec.Docker{cl}
+	d := dexec.Docker{Client: cl}
 
 	m, _ := dexec.ByCreatingContainer(docker.CreateContainerOptions{
 		Config: &docker.Config{Image: "busybox"}})
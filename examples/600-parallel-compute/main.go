@@ -21,7 +21,7 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
-	d = dexec.Docker{cl}
+	d = dexec.Docker{Client: cl}
 }
 
 func main() {
@@ -6,19 +6,25 @@ import (
 )
 
 type ContainerClient interface {
-	Docker | Containerd
+	Docker | Containerd | BackendClient
 }
 
 // Execution determines how the command is going to be executed. Currently
-// the only method is ByCreatingContainer.
+// the only method is ByCreatingContainer. Runtimes other than Docker and
+// containerd implement Backend instead.
 type Execution[T ContainerClient] interface {
 	create(d T, cmd []string) error
 	run(d T, stdin io.Reader, stdout, stderr io.Writer) error
-	wait(d T) (int, error)
+	// wait waits for the command to exit. beforeRemove, if not nil, is called after
+	// the command exits and before its container is removed.
+	wait(d T, beforeRemove func() error) (int, error)
+	copyIn(d T, dst string, content io.Reader) error
+	copyOut(d T, src string, w io.Writer) error
 
 	setEnv(env []string) error
 	setDir(dir string) error
 	getID() string
+	imageDigest() string
 	kill(d T) error
 	cleanup(d T) error
 
@@ -0,0 +1,17 @@
+package dexec
+
+// Exported for the tests of package dexec_test, which cannot be part of package
+// dexec since they import dexectest.
+type (
+	FakeDockerServer = fakeDockerServer
+	FakePodmanServer = fakePodmanServer
+	FakeContainerd   = fakeContainerd
+	FakeProcess      = fakeProcess
+)
+
+var (
+	NewFakeDockerServer = newFakeDockerServer
+	NewFakePodmanServer = newFakePodmanServer
+	NewFakeContainerd   = newFakeContainerd
+	NewFakeRunc         = newFakeRunc
+)
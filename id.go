@@ -1,20 +1,77 @@
 package dexec
 
 import (
-	"math/rand"
+	"crypto/rand"
+	"encoding/binary"
+	"math/big"
 	"time"
 )
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
-}
-
 const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
+// RandomString returns a string of n letters read from crypto/rand
 func RandomString(n int) string {
 	b := make([]byte, n)
+	max := big.NewInt(int64(len(letters)))
 	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+		v, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic("dexec: failed to read random bytes: " + err.Error())
+		}
+		b[i] = letters[v.Int64()]
 	}
 	return string(b)
 }
+
+// IDGenerator generates the unique part of the names of containers. IDs must only have
+// letters and digits.
+type IDGenerator interface {
+	NewID() string
+}
+
+// RandomIDs generates IDs of Length letters read from crypto/rand
+type RandomIDs struct {
+	Length int
+}
+
+func (g RandomIDs) NewID() string {
+	return RandomString(g.Length)
+}
+
+// crockford is the Crockford's base32 alphabet of ULIDs
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULIDs generates ULIDs: 26 characters sorted by the time they were generated at, to the
+// millisecond, followed by 80 random bits read from crypto/rand.
+type ULIDs struct{}
+
+func (ULIDs) NewID() string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixMilli())<<16)
+	if _, err := rand.Read(b[6:]); err != nil {
+		panic("dexec: failed to read random bytes: " + err.Error())
+	}
+	// 128 bits are 26 characters of 5 bits, the first one having only 3 bits
+	id := make([]byte, 26)
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	for i := 25; i >= 0; i-- {
+		id[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(id)
+}
+
+// DefaultIDGenerator generates the IDs of clients that do not set an IDGenerator
+var DefaultIDGenerator IDGenerator = RandomIDs{Length: randomSuffixLength}
+
+// newID returns a new ID of ids, or of DefaultIDGenerator if ids is nil
+func newID(ids IDGenerator) string {
+	if ids == nil {
+		ids = DefaultIDGenerator
+	}
+	return ids.NewID()
+}
+
+// maxNameAttempts is the number of names tried when a container cannot be created because its name is used
+const maxNameAttempts = 3
@@ -2,9 +2,18 @@ package dexec
 
 import (
 	"fmt"
-	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestRandomString(t *testing.T) {
@@ -30,3 +39,159 @@ func TestRandomString(t *testing.T) {
 		})
 	}
 }
+
+func TestRandomIDs(t *testing.T) {
+	ids := RandomIDs{Length: 12}
+	id := ids.NewID()
+	assert.Regexp(t, regexp.MustCompile(`^[A-Za-z]{12}$`), id)
+	assert.NotEqual(t, id, ids.NewID())
+}
+
+func TestULIDs(t *testing.T) {
+	first := ULIDs{}.NewID()
+	assert.Regexp(t, regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`), first)
+	time.Sleep(2 * time.Millisecond)
+	second := ULIDs{}.NewID()
+	assert.Less(t, first, second)
+	assert.NotEqual(t, first[10:], second[10:])
+}
+
+func Test_ULIDs_Time(t *testing.T) {
+	// the first 10 characters are the time in milliseconds
+	before := time.Now().UnixMilli()
+	id := ULIDs{}.NewID()
+	var ms int64
+	for _, c := range id[:10] {
+		ms = ms<<5 | int64(strings.IndexRune(crockford, c))
+	}
+	assert.GreaterOrEqual(t, ms, before)
+	assert.LessOrEqual(t, ms, time.Now().UnixMilli())
+}
+
+// sequenceIDs returns its IDs in order, and then the last one
+type sequenceIDs struct {
+	ids []string
+}
+
+func (s *sequenceIDs) NewID() string {
+	id := s.ids[0]
+	if len(s.ids) > 1 {
+		s.ids = s.ids[1:]
+	}
+	return id
+}
+
+func TestIDGenerator_Docker_NameConflict(t *testing.T) {
+	server := newFakeDockerServer(t, echoProcess)
+	newCmd := func(ids IDGenerator) Cmd {
+		cmd, err := NewCommand(server.Client(t).Client, WithImage("busybox"), WithCommand("echo"), func(c *Config) {
+			c.NameTemplate = "ci"
+			c.IDGenerator = ids
+		})
+		assert.NoError(t, err)
+		return cmd
+	}
+	first := newCmd(&sequenceIDs{ids: []string{"a"}})
+	assert.NoError(t, first.Start())
+	assert.Equal(t, "ci-a", server.Name(first.GetPID()))
+
+	// the names used by the first container are retried with a new ID
+	second := newCmd(&sequenceIDs{ids: []string{"a", "a", "b"}})
+	assert.NoError(t, second.Start())
+	assert.Equal(t, "ci-b", server.Name(second.GetPID()))
+
+	// until the attempts are exhausted
+	third := newCmd(&sequenceIDs{ids: []string{"a", "b", "a", "c"}})
+	assert.ErrorIs(t, third.Start(), docker.ErrContainerAlreadyExists)
+
+	assert.NoError(t, first.Wait())
+	assert.NoError(t, second.Wait())
+}
+
+func TestIDGenerator_Podman_NameConflict(t *testing.T) {
+	server := newFakePodmanServer(t, echoProcess)
+	newContainer := func(ids IDGenerator) *podmanContainer {
+		c, err := newPodmanContainer(server.Podman(), Config{ContainerConfig: ContainerConfig{Image: "busybox"}, NameTemplate: "ci",
+			IDGenerator: ids})
+		assert.NoError(t, err)
+		return c
+	}
+	first := newContainer(&sequenceIDs{ids: []string{"a"}})
+	assert.NoError(t, first.Create([]string{"echo"}))
+	assert.Equal(t, "ci-a", server.Spec(first.ID()).Name)
+
+	// the names used by the first container are retried with a new ID
+	second := newContainer(&sequenceIDs{ids: []string{"a", "a", "b"}})
+	assert.NoError(t, second.Create([]string{"echo"}))
+	assert.Equal(t, "ci-b", server.Spec(second.ID()).Name)
+
+	// until the attempts are exhausted
+	third := newContainer(&sequenceIDs{ids: []string{"a", "b", "a", "c"}})
+	assert.ErrorContains(t, third.Create([]string{"echo"}), `the container name "ci-a" is already in use`)
+
+	// without a template, Podman containers are unnamed
+	unnamed, err := newPodmanContainer(server.Podman(), Config{ContainerConfig: ContainerConfig{Image: "busybox"}})
+	assert.NoError(t, err)
+	assert.NoError(t, unnamed.Create([]string{"echo"}))
+	assert.Empty(t, server.Spec(unnamed.ID()).Name)
+
+	for _, c := range []*podmanContainer{first, second, unnamed} {
+		assert.NoError(t, c.Cleanup())
+	}
+}
+
+func Test_createTask_createContainer_NameConflict(t *testing.T) {
+	// nerdctl fails to create the container while its name is ci-a
+	bin := t.TempDir()
+	script := "#!/bin/sh\nfor a; do [ \"$a\" = ci-a ] && { echo 'name \"ci-a\" is already used by ID \"1\"' >&2; exit 1; }; done\necho $@ >> " + filepath.Join(bin, "args") + "\necho id\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(bin, nerdctlBinary), []byte(script), 0755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	mockContainer := new(container)
+	client := new(client)
+	client.On("LoadContainer", mock.Anything, "id").Return(mockContainer, nil)
+	ct := &createTask{opts: CreateTaskOptions{Image: "busybox", NamePrefix: "ci"}, logger: logrus.NewEntry(logrus.New())}
+
+	c := Containerd{ContainerdClient: client, Namespace: "unit-test", IDs: &sequenceIDs{ids: []string{"a", "a", "b"}}}
+	created, err := ct.createContainer(c)
+	assert.NoError(t, err)
+	assert.Equal(t, mockContainer, created)
+	args, err := os.ReadFile(filepath.Join(bin, "args"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(args), "--name ci-b")
+
+	c.IDs = &sequenceIDs{ids: []string{"a"}}
+	_, err = ct.createContainer(c)
+	assert.ErrorContains(t, err, `nerdctl: error creating container: exit status 1: name "ci-a" is already used by ID "1"`)
+}
+
+func TestIDGenerator_Backends(t *testing.T) {
+	local := newLocalCmd(t, &Local{Env: os.Environ()}, Config{IDGenerator: &sequenceIDs{ids: []string{"l1"}}}, "true")
+	assert.NoError(t, local.Start())
+	assert.Equal(t, "local-l1", local.GetPID())
+	assert.NoError(t, local.Wait())
+
+	r := newFakeRunc(t, ocispec.ImageConfig{}, map[string]string{"bin/.keep": ""})
+	c := newRuncContainer(r, Config{ContainerConfig: ContainerConfig{Image: "busybox:1.36"}, IDGenerator: &sequenceIDs{ids: []string{"r1"}}})
+	assert.NoError(t, c.Create([]string{"true"}))
+	assert.Equal(t, "dexec-r1", c.ID())
+	assert.NoError(t, c.Cleanup())
+
+	podman := newFakePodmanServer(t, echoProcess)
+	pc, err := newPodmanContainer(podman.Podman(), Config{ContainerConfig: ContainerConfig{Image: "busybox"}, NameTemplate: "ci",
+		IDGenerator: &sequenceIDs{ids: []string{"p1"}}})
+	assert.NoError(t, err)
+	assert.NoError(t, pc.Create([]string{"echo"}))
+	assert.Equal(t, "ci-p1", podman.Spec(pc.ID()).Name)
+	assert.NoError(t, pc.Cleanup())
+
+	server := newFakeDockerServer(t, echoProcess)
+	d := server.Client(t)
+	d.IDs = &sequenceIDs{ids: []string{"d1"}}
+	method, err := ByCreatingContainer(docker.CreateContainerOptions{Config: &docker.Config{Image: "busybox"}}, WithNamePrefix("ci"))
+	assert.NoError(t, err)
+	cmd := d.Command(method, "echo")
+	assert.NoError(t, cmd.Start())
+	assert.Equal(t, "ci-d1", server.Name(cmd.GetPID()))
+	assert.NoError(t, cmd.Wait())
+}
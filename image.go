@@ -0,0 +1,152 @@
+package dexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	refdocker "github.com/containerd/containerd/reference/docker"
+	"github.com/opencontainers/go-digest"
+)
+
+// PullPolicy determines when the image of a command is pulled before its container is created
+type PullPolicy string
+
+const (
+	// PullAlways pulls the image before every command
+	PullAlways PullPolicy = "Always"
+	// PullIfNotPresent pulls the image only when it is missing on the host
+	PullIfNotPresent PullPolicy = "IfNotPresent"
+	// PullNever never pulls the image and fails with an *ImageNotFoundError when it is missing
+	PullNever PullPolicy = "Never"
+)
+
+// ImageOptions controls how the image of a command is made available before its container is created.
+type ImageOptions struct {
+	// PullPolicy is the policy used to pull the image. If empty, dexec does not check
+	// for the image and leaves it to the backend: Docker fails when the image is missing
+	// and nerdctl pulls it implicitly.
+	PullPolicy PullPolicy
+	// Progress, if not nil, receives progress messages while the image is pulled
+	Progress io.Writer
+	// Auth, if not nil, provides the credentials used to pull the image
+	Auth *RegistryAuth
+	// Digest pins the image to a digest. The image reference may also be pinned
+	// directly in the form name@sha256:... A pinned image is looked up and pulled by
+	// digest on every backend, so moving the tag, locally or in the registry, has no
+	// effect on it. It is pulled when it is missing on the host unless PullPolicy is
+	// PullNever, and the command fails with a *DigestMismatchError if the digest of the
+	// local image differs.
+	Digest digest.Digest
+	// ResolveDigest resolves the tag of an unpinned image to a digest with the registry
+	// and runs the image by digest, so that a retagged image cannot change between the
+	// resolution and the creation of the container.
+	ResolveDigest bool
+	// RequireDigest fails with ErrDigestRequired when the image is not pinned
+	RequireDigest bool
+}
+
+// ImageNotFoundError reports an image that is not present on the host and could not be pulled.
+type ImageNotFoundError struct {
+	// Image is the reference of the missing image
+	Image string
+	// Err is the error returned by the backend
+	Err error
+}
+
+func (e *ImageNotFoundError) Error() string {
+	return fmt.Sprintf("dexec: image not found: %s: %v", e.Image, e.Err)
+}
+
+func (e *ImageNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// ErrDigestRequired is returned when ImageOptions.RequireDigest is set and the image is not pinned to a digest
+var ErrDigestRequired = errors.New("dexec: image must be pinned to a digest")
+
+// DigestMismatchError reports a local image whose digest differs from the digest it is pinned to.
+type DigestMismatchError struct {
+	// Image is the reference of the image
+	Image string
+	// Expected is the digest the image is pinned to
+	Expected digest.Digest
+	// Actual is the digest of the local image, or empty if it has none
+	Actual digest.Digest
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("dexec: image %s has digest %q, expected %q", e.Image, e.Actual, e.Expected)
+}
+
+// pinImage returns the reference used to create the container and the digest the image is pinned to. The digest is
+// taken from the reference or ImageOptions.Digest, or resolved with the registry when ImageOptions.ResolveDigest is
+// set. If the image is not pinned, the returned digest is empty
+func pinImage(ctx context.Context, image string, opts ImageOptions) (string, digest.Digest, error) {
+	named, err := refdocker.ParseDockerRef(image)
+	if err != nil {
+		return "", "", fmt.Errorf("dexec: error parsing image reference: %w", err)
+	}
+	pin := opts.Digest
+	if digested, ok := named.(refdocker.Digested); ok {
+		if pin != "" && pin != digested.Digest() {
+			return "", "", &DigestMismatchError{Image: image, Expected: pin, Actual: digested.Digest()}
+		}
+		return image, digested.Digest(), nil
+	}
+	if pin == "" && opts.ResolveDigest {
+		_, desc, err := opts.Auth.resolver().Resolve(ctx, named.String())
+		if err != nil {
+			return "", "", fmt.Errorf("dexec: failed to resolve digest of %s: %w", image, err)
+		}
+		pinned, err := digestedReference(image, desc.Digest)
+		if err != nil {
+			return "", "", err
+		}
+		return pinned, desc.Digest, nil
+	}
+	if pin == "" && opts.RequireDigest {
+		return "", "", fmt.Errorf("%w: %s", ErrDigestRequired, image)
+	}
+	return image, pin, nil
+}
+
+// digestedReference returns the reference to the image of the repository of image with the digest pin, which keeps
+// referring to the same image when a tag is moved
+func digestedReference(image string, pin digest.Digest) (string, error) {
+	named, err := refdocker.ParseDockerRef(image)
+	if err != nil {
+		return "", fmt.Errorf("dexec: error parsing image reference: %w", err)
+	}
+	pinned, err := refdocker.WithDigest(refdocker.TrimNamed(named), pin)
+	if err != nil {
+		return "", err
+	}
+	return refdocker.FamiliarString(pinned), nil
+}
+
+// verifyRepoDigests checks that one of the repository digests of a local image, as reported by the Docker and Podman
+// APIs, is pin in the repository of image. The digests of the image in other repositories do not count, since they
+// say nothing of the content image refers to.
+func verifyRepoDigests(image string, repoDigests []string, pin digest.Digest) error {
+	named, err := refdocker.ParseDockerRef(image)
+	if err != nil {
+		return fmt.Errorf("dexec: error parsing image reference: %w", err)
+	}
+	var actual digest.Digest
+	for _, repoDigest := range repoDigests {
+		ref, err := refdocker.ParseDockerRef(repoDigest)
+		if err != nil {
+			continue
+		}
+		digested, ok := ref.(refdocker.Digested)
+		if !ok || ref.Name() != named.Name() {
+			continue
+		}
+		if actual = digested.Digest(); actual == pin {
+			return nil
+		}
+	}
+	return &DigestMismatchError{Image: image, Expected: pin, Actual: actual}
+}
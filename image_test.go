@@ -0,0 +1,64 @@
+package dexec
+
+import (
+	"context"
+	"errors"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_pinImage(t *testing.T) {
+	registry := newTestRegistry(t, "user", "secret")
+	resolved := registry.AddManifest("team/app", "1.0")
+	auth := &RegistryAuth{Username: "user", Password: "secret"}
+	pinned := digest.FromString("pinned")
+	other := digest.FromString("other")
+
+	tests := []struct {
+		name        string
+		image       string
+		opts        ImageOptions
+		expectedRef string
+		expectedPin digest.Digest
+		mismatch    bool
+		required    bool
+	}{
+		{name: "not pinned", image: "busybox:1.36", expectedRef: "busybox:1.36"},
+		{name: "pinned reference", image: "busybox@" + pinned.String(), expectedRef: "busybox@" + pinned.String(), expectedPin: pinned},
+		{name: "pinned option", image: "busybox:1.36", opts: ImageOptions{Digest: pinned}, expectedRef: "busybox:1.36", expectedPin: pinned},
+		{name: "pinned reference and option", image: "busybox@" + pinned.String(), opts: ImageOptions{Digest: other}, mismatch: true},
+		{
+			name:        "resolved",
+			image:       registry.Host() + "/team/app:1.0",
+			opts:        ImageOptions{ResolveDigest: true, RequireDigest: true, Auth: auth},
+			expectedRef: registry.Host() + "/team/app@" + resolved.String(),
+			expectedPin: resolved,
+		},
+		{name: "required", image: "busybox:1.36", opts: ImageOptions{RequireDigest: true}, required: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ref, pin, err := pinImage(context.Background(), tt.image, tt.opts)
+			var dme *DigestMismatchError
+			assert.Equal(t, tt.mismatch, errors.As(err, &dme))
+			assert.Equal(t, tt.required, errors.Is(err, ErrDigestRequired))
+			if !tt.mismatch && !tt.required {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedRef, ref)
+			assert.Equal(t, tt.expectedPin, pin)
+		})
+	}
+}
+
+func Test_verifyRepoDigests(t *testing.T) {
+	pinned := digest.FromString("pinned")
+	assert.NoError(t, verifyRepoDigests("busybox:1.36", []string{"<none>@<none>", "docker.io/library/busybox@" + pinned.String()}, pinned))
+	assert.NoError(t, verifyRepoDigests("ghcr.io/acme/tool", []string{"ghcr.io/acme/tool@" + pinned.String()}, pinned))
+
+	err := verifyRepoDigests("ghcr.io/acme/tool:1.0", []string{"ghcr.io/evil/tool@" + pinned.String()}, pinned)
+	var dme *DigestMismatchError
+	assert.True(t, errors.As(err, &dme))
+	assert.Empty(t, dme.Actual)
+}
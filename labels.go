@@ -0,0 +1,76 @@
+package dexec
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultOwner is the owner of the containers of commands whose ContainerConfig.Owner is empty
+const DefaultOwner = chains
+
+// reservedLabels are the labels set by dexec, which ContainerConfig.Labels cannot set
+var reservedLabels = map[string]bool{
+	ownerLabel:             true,
+	deadlineLabel:          true,
+	commandExecutorIdLabel: true,
+	chainExecutorIdLabel:   true,
+	commandResultIdLabel:   true,
+	imageDigestLabel:       true,
+}
+
+// errAnnotationsUnsupported is returned by backends that cannot annotate containers
+var errAnnotationsUnsupported = errors.New("dexec: Annotations are not supported by this backend")
+
+// ownerOrDefault returns owner, or DefaultOwner if owner is empty
+func ownerOrDefault(owner string) string {
+	if owner == "" {
+		return DefaultOwner
+	}
+	return owner
+}
+
+// commandLabels returns labels with the owner, the metadata and the CommandDetails labels of a container added
+func commandLabels(labels map[string]string, owner string, metadata map[string]string, details CommandDetails) map[string]string {
+	res := make(map[string]string, len(labels)+len(metadata)+4)
+	for key, value := range labels {
+		res[key] = value
+	}
+	for key, value := range metadata {
+		res[MetadataLabelPrefix+key] = value
+	}
+	res[ownerLabel] = ownerOrDefault(owner)
+	res[commandExecutorIdLabel] = strconv.FormatInt(details.ExecutorId, 10)
+	res[chainExecutorIdLabel] = strconv.FormatInt(details.ChainExecutorId, 10)
+	res[commandResultIdLabel] = strconv.FormatInt(details.ResultId, 10)
+	return res
+}
+
+// keyValues returns the pairs of m as sorted KEY=VALUE strings
+func keyValues(m map[string]string) []string {
+	kvs := make([]string, 0, len(m))
+	for key, value := range m {
+		kvs = append(kvs, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(kvs)
+	return kvs
+}
+
+// validateLabels checks the keys of the labels of field, reporting invalid keys with invalid
+func validateLabels(field string, labels map[string]string, reserved map[string]bool, invalid func(field, format string, args ...interface{})) {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch {
+		case key == "" || strings.ContainsAny(key, "= \t\n"):
+			invalid(field, "%q is not a valid key", key)
+		case reserved[key]:
+			invalid(field, "%q is set by dexec", key)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package dexec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_commandLabels(t *testing.T) {
+	details := CommandDetails{ExecutorId: 1, ChainExecutorId: 2, ResultId: 3}
+	assert.Equal(t, map[string]string{
+		"team":                 "builds",
+		ownerLabel:             "ci",
+		commandExecutorIdLabel: "1",
+		chainExecutorIdLabel:   "2",
+		commandResultIdLabel:   "3",
+	}, commandLabels(map[string]string{"team": "builds", ownerLabel: "spoofed"}, "ci", nil, details))
+	assert.Equal(t, DefaultOwner, commandLabels(nil, "", nil, details)[ownerLabel])
+}
+
+func TestLabels_Docker(t *testing.T) {
+	config := Config{ContainerConfig: ContainerConfig{Image: "busybox", Labels: map[string]string{"team": "builds"}, Owner: "ci"}}
+	execution, err := getDockerExecution(config)
+	assert.NoError(t, err)
+	labels := execution.(*createContainer).opt.Config.Labels
+	assert.Equal(t, "builds", labels["team"])
+	assert.Equal(t, "ci", labels[ownerLabel])
+	assert.Equal(t, "0", labels[commandResultIdLabel])
+
+	config.ContainerConfig.Annotations = map[string]string{"io.kubernetes.cri.sandbox-id": "1"}
+	_, err = getDockerExecution(config)
+	assert.ErrorIs(t, err, errAnnotationsUnsupported)
+}
+
+func TestLabels_Docker_Deadline(t *testing.T) {
+	server := newFakeDockerServer(t, func(p *fakeProcess) int {
+		if p.Args[0] == "sleep" {
+			<-p.Stopped
+		}
+		return 0
+	})
+	before := time.Now().Truncate(time.Second)
+	cmd, err := NewCommand(server.Client(t).Client, WithImage("busybox"), WithCommand("sleep", "60"), WithTimeout(time.Minute))
+	assert.NoError(t, err)
+	assert.NoError(t, cmd.Start())
+	defer cmd.Cleanup()
+
+	deadline, err := time.Parse(time.RFC3339, server.Config(cmd.GetPID()).Labels[deadlineLabel])
+	assert.NoError(t, err)
+	assert.False(t, deadline.Before(before.Add(time.Minute+timeoutBuffer)))
+	assert.False(t, deadline.After(time.Now().Add(time.Minute+timeoutBuffer)))
+	stats, err := GetStats(server.Client(t))
+	assert.NoError(t, err)
+	assert.Equal(t, Stats{Running: 1}, stats)
+
+	// without a timeout, the container has no deadline
+	cmd, err = NewCommand(server.Client(t).Client, WithImage("busybox"), WithCommand("echo"))
+	assert.NoError(t, err)
+	assert.NoError(t, cmd.Start())
+	assert.NotContains(t, server.Config(cmd.GetPID()).Labels, deadlineLabel)
+	assert.NoError(t, cmd.Wait())
+}
+
+func Test_createTask_buildCreateContainerArgs_Labels(t *testing.T) {
+	task := &createTask{opts: CreateTaskOptions{
+		Image:       "busybox",
+		Labels:      map[string]string{"team": "builds"},
+		Annotations: map[string]string{"b": "2", "a": "1"},
+		Owner:       "ci",
+	}}
+	task.buildLabels()
+	args := task.buildCreateContainerArgs(Containerd{Namespace: "unit-test"})
+	assert.Equal(t, []string{
+		"--label", chainExecutorIdLabel + "=0",
+		"--label", commandExecutorIdLabel + "=0",
+		"--label", commandResultIdLabel + "=0",
+		"--label", "team=builds",
+		"--label", ownerLabel + "=ci",
+		"--annotation", "a=1",
+		"--annotation", "b=2",
+		"busybox",
+	}, args[7:])
+}
+
+func Test_getContainerdStats_Owner(t *testing.T) {
+	client := new(client)
+	client.On("Containers", mock.Anything, []string{`labels."wk/owner"=="chains"`}).Return([]containerd.Container{}, nil).Once()
+	client.On("Containers", mock.Anything, []string{`labels."wk/owner"=="ci"`}).Return([]containerd.Container{}, nil).Once()
+	client.On("Containers", mock.Anything, []string{`labels."wk/owner"=="team \"a\""`}).Return([]containerd.Container{}, nil).Once()
+
+	_, err := GetStats(Containerd{ContainerdClient: client, Namespace: "unit-test"})
+	assert.NoError(t, err)
+	_, err = GetStats(Containerd{ContainerdClient: client, Namespace: "unit-test", Owner: "ci"})
+	assert.NoError(t, err)
+	_, err = GetStats(Containerd{ContainerdClient: client, Namespace: "unit-test", Owner: `team "a"`})
+	assert.NoError(t, err)
+	client.AssertExpectations(t)
+}
+
+func TestLabels_Podman(t *testing.T) {
+	server := newFakePodmanServer(t, echoProcess)
+	cmd := newFakePodmanCmd(t, server, Config{ContainerConfig: ContainerConfig{
+		Labels:      map[string]string{"team": "builds"},
+		Annotations: map[string]string{"a": "1"},
+	}}, "echo")
+
+	assert.NoError(t, cmd.Start())
+	spec := server.Spec(cmd.GetPID())
+	assert.NoError(t, cmd.Wait())
+	assert.Equal(t, "builds", spec.Labels["team"])
+	assert.Equal(t, DefaultOwner, spec.Labels[ownerLabel])
+	assert.Equal(t, map[string]string{"a": "1"}, spec.Annotations)
+}
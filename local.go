@@ -0,0 +1,105 @@
+package dexec
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// LocalBackend is the name of the local backend. Commands are run as processes of the
+// host when Command is given a *Local.
+const LocalBackend = "local"
+
+// Local runs commands as processes of the host with os/exec instead of in containers.
+// It is meant for development and tests: the same Config runs unchanged, but the
+// command is not isolated and ContainerConfig options other than Env and Mounts are
+// ignored.
+type Local struct {
+	// Env is the environment commands start with, before ContainerConfig.Env and
+	// Cmd.Env are added. If nil, the environment of the current process is used.
+	Env []string
+	// MapMounts rewrites the paths of commands that are below the Destination of a
+	// bind mount to the Source of the mount, so a command sees the host directories
+	// it would see in its container. Arguments, the working directory and the paths
+	// of copied content and artifacts are rewritten.
+	MapMounts bool
+
+	mu      sync.Mutex
+	running map[string]struct{}
+}
+
+func init() {
+	RegisterBackend(LocalBackend, func(client interface{}, config Config) (Backend, error) {
+		l, ok := client.(*Local)
+		if !ok {
+			return nil, ErrUnsupportedClient
+		}
+		if !config.ContainerConfig.Security.isZero() {
+			return nil, errSecurityUnsupported
+		}
+		if config.ContainerConfig.Runtime != "" || config.ContainerConfig.RuntimeOptions != (RuntimeOptions{}) {
+			return nil, errRuntimeUnsupported
+		}
+		return newLocalProcess(l, config), nil
+	})
+}
+
+// Running returns the sorted IDs of the commands that were started and whose
+// processes were not waited for or cleaned up yet.
+func (l *Local) Running() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ids := make([]string, 0, len(l.running))
+	for id := range l.running {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (l *Local) setRunning(id string, running bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !running {
+		delete(l.running, id)
+		return
+	}
+	if l.running == nil {
+		l.running = make(map[string]struct{})
+	}
+	l.running[id] = struct{}{}
+}
+
+// pathMapper rewrites container paths to host paths using bind mounts
+type pathMapper []Mount
+
+func newPathMapper(mounts []Mount) pathMapper {
+	var m pathMapper
+	for _, mount := range mounts {
+		if mount.Source == "" || mount.Destination == "" || (mount.Type != "" && mount.Type != "bind") {
+			continue
+		}
+		m = append(m, mount)
+	}
+	// the most specific mount wins
+	sort.SliceStable(m, func(i, j int) bool {
+		return len(path.Clean(m[i].Destination)) > len(path.Clean(m[j].Destination))
+	})
+	return m
+}
+
+// mapPath returns the host path of p, or p if it is not below the destination of a mount
+func (m pathMapper) mapPath(p string) string {
+	for _, mount := range m {
+		dst := path.Clean(mount.Destination)
+		if p == dst {
+			return mount.Source
+		}
+		prefix := strings.TrimSuffix(dst, "/") + "/"
+		if strings.HasPrefix(p, prefix) {
+			return path.Join(mount.Source, strings.TrimPrefix(p, prefix))
+		}
+	}
+	return p
+}
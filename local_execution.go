@@ -0,0 +1,191 @@
+package dexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"syscall"
+	"time"
+)
+
+// localProcess is the Backend running a command as a process of the host
+type localProcess struct {
+	local    *Local
+	mounts   pathMapper
+	env      []string
+	dir      string
+	envMerge MergeMode
+	dirMerge MergeMode
+	timeout  time.Duration
+	ids      IDGenerator
+	id       string
+	argv     []string
+	cmd      *exec.Cmd
+	done     chan struct{}
+	err      error
+}
+
+func newLocalProcess(l *Local, config Config) *localProcess {
+	p := &localProcess{
+		local:    l,
+		env:      config.ContainerConfig.Env,
+		dir:      config.TaskConfig.WorkingDir,
+		envMerge: config.ContainerConfig.EnvMerge,
+		dirMerge: config.TaskConfig.DirMerge,
+		timeout:  config.TaskConfig.Timeout,
+		ids:      config.IDGenerator,
+	}
+	if l.MapMounts {
+		p.mounts = newPathMapper(config.ContainerConfig.Mounts)
+	}
+	return p
+}
+
+func (p *localProcess) SetEnv(env []string) error {
+	env, err := mergeEnv(p.envMerge, p.env, env)
+	if err != nil {
+		return err
+	}
+	p.env = env
+	return nil
+}
+
+func (p *localProcess) SetDir(dir string) error {
+	dir, err := mergeDir(p.dirMerge, p.dir, dir)
+	if err != nil {
+		return err
+	}
+	p.dir = dir
+	return nil
+}
+
+// Create resolves the executable, so a missing one fails like a container that cannot be created
+func (p *localProcess) Create(cmd []string) error {
+	if len(cmd) == 0 || cmd[0] == "" {
+		return errors.New("dexec: no command")
+	}
+	argv := make([]string, len(cmd))
+	for i, arg := range cmd {
+		argv[i] = p.mapPath(arg)
+	}
+	if _, err := exec.LookPath(argv[0]); err != nil {
+		return fmt.Errorf("dexec: failed to create process: %w", err)
+	}
+	p.argv = argv
+	p.id = "local-" + newID(p.ids)
+	return nil
+}
+
+func (p *localProcess) mapPath(s string) string {
+	if len(p.mounts) == 0 || !path.IsAbs(s) {
+		return s
+	}
+	return p.mounts.mapPath(path.Clean(s))
+}
+
+func (p *localProcess) CopyIn(dst string, content io.Reader) error {
+	if p.id == "" {
+		return errors.New("dexec: process is not created")
+	}
+	return untar(p.mapPath(dst), content)
+}
+
+func (p *localProcess) CopyOut(src string, w io.Writer) error {
+	if p.id == "" {
+		return errors.New("dexec: process is not created")
+	}
+	return writeTar(w, p.mapPath(src), path.Base(src))
+}
+
+func (p *localProcess) Start(stdin io.Reader, stdout, stderr io.Writer) error {
+	if p.id == "" {
+		return errors.New("dexec: process is not created")
+	}
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if p.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+	}
+	cmd := exec.CommandContext(ctx, p.argv[0], p.argv[1:]...)
+	cmd.Env = append(append([]string(nil), p.baseEnv()...), p.env...)
+	cmd.Dir = p.mapPath(p.dir)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return fmt.Errorf("dexec: failed to start process: %w", err)
+	}
+	p.cmd = cmd
+	p.done = make(chan struct{})
+	p.local.setRunning(p.id, true)
+	// the process is reaped even if Wait is never called
+	go func() {
+		defer close(p.done)
+		defer cancel()
+		p.err = cmd.Wait()
+	}()
+	return nil
+}
+
+func (p *localProcess) baseEnv() []string {
+	if p.local.Env != nil {
+		return p.local.Env
+	}
+	return os.Environ()
+}
+
+func (p *localProcess) Wait(beforeRemove func() error) (int, error) {
+	if p.cmd == nil {
+		return -1, errors.New("dexec: process is not started")
+	}
+	defer p.local.setRunning(p.id, false)
+	<-p.done
+	var ee *exec.ExitError
+	if p.err != nil && !errors.As(p.err, &ee) {
+		return -1, fmt.Errorf("dexec: cannot wait for process: %w", p.err)
+	}
+	ec := exitCode(p.cmd.ProcessState)
+	if beforeRemove != nil {
+		if err := beforeRemove(); err != nil {
+			return ec, err
+		}
+	}
+	return ec, nil
+}
+
+// exitCode returns the exit code of a process, which is 128 plus the signal for processes
+// killed by a signal as reported for containers
+func exitCode(state *os.ProcessState) int {
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return state.ExitCode()
+}
+
+func (p *localProcess) ID() string {
+	return p.id
+}
+
+func (p *localProcess) Kill() error {
+	// if the process is not started or already exited
+	// do not return an error
+	if p.cmd == nil {
+		return nil
+	}
+	if err := p.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("error killing process: %w", err)
+	}
+	return nil
+}
+
+func (p *localProcess) Cleanup() error {
+	if err := p.Kill(); err != nil {
+		return err
+	}
+	p.local.setRunning(p.id, false)
+	return nil
+}
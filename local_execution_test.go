@@ -0,0 +1,154 @@
+package dexec
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newLocalCmd(t *testing.T, l *Local, config Config, script string, arg ...string) *BackendCmd {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	config.TaskConfig.Executable = "sh"
+	config.TaskConfig.Args = append([]string{"-c", script, "sh"}, arg...)
+	return Command(l, config).(*BackendCmd)
+}
+
+func TestCommand_Local(t *testing.T) {
+	dir := t.TempDir()
+	l := &Local{Env: []string{"BASE=base", "PATH=" + os.Getenv("PATH")}}
+	cmd := newLocalCmd(t, l, Config{
+		ContainerConfig: ContainerConfig{Image: "busybox", Env: []string{"A=1"}},
+		TaskConfig:      TaskConfig{WorkingDir: dir},
+	}, `printf '%s %s %s' "$BASE" "$A" "$(pwd)"; cat >&2`)
+	assert.Equal(t, LocalBackend, cmd.client.Name)
+	var stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader("input")
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	assert.NoError(t, err)
+	wd, _ := filepath.EvalSymlinks(dir)
+	assert.Equal(t, "base 1 "+wd, string(out))
+	assert.Equal(t, "input", stderr.String())
+	assert.True(t, strings.HasPrefix(cmd.GetPID(), "local-"))
+	assert.Equal(t, &ProcessState{ID: cmd.GetPID(), Exited: true, ExitCode: 0}, cmd.GetProcessState())
+	assert.Empty(t, l.Running())
+}
+
+func Test_localProcess_ExitCode(t *testing.T) {
+	cmd := newLocalCmd(t, &Local{}, Config{}, "exit 3")
+
+	var ee *ExitError
+	assert.True(t, errors.As(cmd.Run(), &ee))
+	assert.Equal(t, 3, ee.ExitCode)
+}
+
+func Test_localProcess_Kill(t *testing.T) {
+	l := &Local{}
+	cmd := newLocalCmd(t, l, Config{}, "exec sleep 3600")
+
+	assert.NoError(t, cmd.Start())
+	assert.Equal(t, []string{cmd.GetPID()}, l.Running())
+	assert.NoError(t, cmd.Kill())
+	var ee *ExitError
+	assert.True(t, errors.As(cmd.Wait(), &ee))
+	assert.Equal(t, 137, ee.ExitCode)
+	assert.Empty(t, l.Running())
+
+	// the process is gone, so there is nothing to kill or clean up
+	assert.NoError(t, cmd.Kill())
+	assert.NoError(t, cmd.Cleanup())
+}
+
+func Test_localProcess_Timeout(t *testing.T) {
+	cmd := newLocalCmd(t, &Local{}, Config{TaskConfig: TaskConfig{Timeout: 100 * time.Millisecond}}, "exec sleep 3600")
+
+	var ee *ExitError
+	assert.True(t, errors.As(cmd.Run(), &ee))
+	assert.Equal(t, 137, ee.ExitCode)
+}
+
+func Test_localProcess_Cleanup(t *testing.T) {
+	l := &Local{}
+	cmd := newLocalCmd(t, l, Config{}, "exec sleep 3600")
+
+	assert.NoError(t, cmd.Start())
+	assert.NoError(t, cmd.Cleanup())
+	assert.Empty(t, l.Running())
+}
+
+func Test_localProcess_NotFound(t *testing.T) {
+	cmd := Command(&Local{}, Config{TaskConfig: TaskConfig{Executable: "dexec-no-such-executable"}})
+
+	assert.ErrorContains(t, cmd.Run(), "dexec: failed to create process")
+}
+
+func Test_localProcess_MapMounts(t *testing.T) {
+	src := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "in.txt"), []byte("hello"), 0644))
+	config := Config{
+		ContainerConfig: ContainerConfig{Mounts: []Mount{{Type: "bind", Source: src, Destination: "/data"}}},
+		TaskConfig:      TaskConfig{WorkingDir: "/data"},
+		Artifacts:       Artifacts{Paths: []string{"/data/out"}},
+	}
+	var artifacts bytes.Buffer
+	config.Artifacts.Output = &artifacts
+	cmd := newLocalCmd(t, &Local{MapMounts: true}, config, `mkdir out && cat "$1" "$2" > out/result.txt`, "/data/in.txt", "/data/copied.txt")
+	assert.NoError(t, cmd.CopyIn("/data", tarOf(t, "copied.txt", " world")))
+
+	assert.NoError(t, cmd.Run())
+	b, err := os.ReadFile(filepath.Join(src, "out", "result.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+
+	tr := tar.NewReader(&artifacts)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, hdr.Name)
+	}
+	assert.Equal(t, []string{"out/", "out/result.txt"}, names)
+}
+
+func tarOf(t *testing.T, name, content string) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+	_, err := tw.Write([]byte(content))
+	assert.NoError(t, err)
+	assert.NoError(t, tw.Close())
+	return &buf
+}
+
+func Test_pathMapper(t *testing.T) {
+	m := newPathMapper([]Mount{
+		{Type: "bind", Source: "/host/data", Destination: "/data"},
+		{Source: "/host/cache", Destination: "/data/cache/"},
+		{Type: "tmpfs", Destination: "/tmp"},
+	})
+	tests := map[string]string{
+		"/data":           "/host/data",
+		"/data/a/b":       "/host/data/a/b",
+		"/data/cache/x":   "/host/cache/x",
+		"/data/cache":     "/host/cache",
+		"/database":       "/database",
+		"/tmp/x":          "/tmp/x",
+		"/other/data/abc": "/other/data/abc",
+	}
+	for p, want := range tests {
+		assert.Equal(t, want, m.mapPath(p), p)
+	}
+}
@@ -0,0 +1,150 @@
+package dexec
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-units"
+)
+
+// The types of Mount
+const (
+	MountBind   = "bind"
+	MountVolume = "volume"
+	MountTmpfs  = "tmpfs"
+)
+
+// propagations are the values of Mount.Propagation
+var propagations = map[string]bool{
+	"private": true, "rprivate": true, "shared": true, "rshared": true, "slave": true, "rslave": true,
+}
+
+// resolved returns m with its default type and the options that have a field of Mount
+// moved to the field, so that every backend translates them the same way
+func (m Mount) resolved() Mount {
+	if m.Type == "" {
+		m.Type = MountBind
+	}
+	var opts []string
+	for _, opt := range m.Options {
+		key, value, _ := strings.Cut(opt, "=")
+		switch {
+		case opt == "ro":
+			m.ReadOnly = true
+		case opt == "rw":
+		case propagations[opt] && m.Propagation == "":
+			m.Propagation = opt
+		case (opt == "z" || opt == "Z") && m.SELinuxRelabel == "":
+			m.SELinuxRelabel = opt
+		case opt == "nocopy":
+			m.NoCopy = true
+		case m.Type == MountTmpfs && key == "size" && m.TmpfsSize == 0:
+			size, err := units.RAMInBytes(value)
+			if err != nil {
+				opts = append(opts, opt)
+				continue
+			}
+			m.TmpfsSize = size
+		case m.Type == MountTmpfs && key == "mode" && m.TmpfsMode == 0:
+			mode, err := strconv.ParseUint(value, 8, 32)
+			if err != nil {
+				opts = append(opts, opt)
+				continue
+			}
+			m.TmpfsMode = uint32(mode)
+		default:
+			opts = append(opts, opt)
+		}
+	}
+	m.Options = opts
+	return m
+}
+
+// ociOptions returns the options of m, which must be resolved, in the form of the mount command
+func (m Mount) ociOptions() []string {
+	opts := append([]string(nil), m.Options...)
+	if m.ReadOnly {
+		opts = append(opts, "ro")
+	}
+	if m.Propagation != "" {
+		opts = append(opts, m.Propagation)
+	}
+	if m.SELinuxRelabel != "" {
+		opts = append(opts, m.SELinuxRelabel)
+	}
+	if m.NoCopy {
+		opts = append(opts, "nocopy")
+	}
+	if m.TmpfsSize > 0 {
+		opts = append(opts, fmt.Sprintf("size=%d", m.TmpfsSize))
+	}
+	if m.TmpfsMode != 0 {
+		opts = append(opts, fmt.Sprintf("mode=%o", m.TmpfsMode))
+	}
+	return opts
+}
+
+// validate checks the fields of the mount, reporting invalid fields of field with invalid
+func (m Mount) validate(field string, invalid func(field, format string, args ...interface{})) {
+	m = m.resolved()
+	switch m.Type {
+	case MountBind:
+		if m.Source == "" {
+			invalid(field+".Source", "source of a bind mount is required")
+		}
+	case MountVolume:
+		if m.Source == "" {
+			invalid(field+".Source", "name of a volume mount is required")
+		}
+	case MountTmpfs:
+		if m.Source != "" && m.Source != MountTmpfs {
+			invalid(field+".Source", "a tmpfs mount has no source")
+		}
+	default:
+		invalid(field+".Type", "unknown mount type %q", m.Type)
+	}
+	if m.Propagation != "" && (!propagations[m.Propagation] || m.Type != MountBind) {
+		invalid(field+".Propagation", "propagation %q is not valid for a %s mount", m.Propagation, m.Type)
+	}
+	if m.SELinuxRelabel != "" && (m.SELinuxRelabel != "z" && m.SELinuxRelabel != "Z" || m.Type != MountBind) {
+		invalid(field+".SELinuxRelabel", "relabel %q is not valid for a %s mount", m.SELinuxRelabel, m.Type)
+	}
+	if m.NoCopy && m.Type != MountVolume {
+		invalid(field+".NoCopy", "only a volume mount can disable copying")
+	}
+	if (m.TmpfsSize != 0 || m.TmpfsMode != 0) && m.Type != MountTmpfs {
+		invalid(field+".TmpfsSize", "only a tmpfs mount has a size and a mode")
+	} else if m.TmpfsSize < 0 {
+		invalid(field+".TmpfsSize", "size %d is negative", m.TmpfsSize)
+	}
+}
+
+// mountSupport is what a backend supports of Mount
+type mountSupport struct {
+	// volumes are volume mounts
+	volumes bool
+	// relabel is Mount.SELinuxRelabel
+	relabel bool
+	// noCopy is Mount.NoCopy
+	noCopy bool
+}
+
+// checkMounts returns an error for the first feature of mounts not in support
+func checkMounts(mounts []Mount, support mountSupport) error {
+	unsupported := func(m Mount, format string, args ...interface{}) error {
+		return fmt.Errorf("dexec: %s of mount %s is not supported by this backend", fmt.Sprintf(format, args...), m.Destination)
+	}
+	for _, m := range mounts {
+		m = m.resolved()
+		switch {
+		case m.Type == MountVolume && !support.volumes:
+			return unsupported(m, "volume %s", m.Source)
+		case m.SELinuxRelabel != "" && !support.relabel:
+			return unsupported(m, "SELinux relabel")
+		case m.NoCopy && !support.noCopy:
+			return unsupported(m, "nocopy")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package dexec
+
+import (
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMount_resolved(t *testing.T) {
+	m := Mount{Source: "/src", Destination: "/dst", Options: []string{"rbind", "ro", "rshared", "Z", "noexec"}}
+	assert.Equal(t, Mount{
+		Type:           MountBind,
+		Source:         "/src",
+		Destination:    "/dst",
+		Options:        []string{"rbind", "noexec"},
+		ReadOnly:       true,
+		Propagation:    "rshared",
+		SELinuxRelabel: "Z",
+	}, m.resolved())
+
+	m = Mount{Type: MountTmpfs, Destination: "/tmp", Options: []string{"size=64m", "mode=1777", "rw"}}
+	assert.Equal(t, Mount{Type: MountTmpfs, Destination: "/tmp", TmpfsSize: 64 << 20, TmpfsMode: 01777}, m.resolved())
+
+	// the fields win over the options
+	m = Mount{Type: MountTmpfs, Destination: "/tmp", Options: []string{"size=64m"}, TmpfsSize: 1024}
+	assert.Equal(t, Mount{Type: MountTmpfs, Destination: "/tmp", Options: []string{"size=64m"}, TmpfsSize: 1024}, m.resolved())
+}
+
+func Test_convertMount_Docker(t *testing.T) {
+	tests := []struct {
+		name  string
+		mount Mount
+		want  docker.HostMount
+	}{
+		{
+			name:  "bind",
+			mount: Mount{Source: "/src", Destination: "/dst", Options: []string{"ro", "rslave"}},
+			want:  docker.HostMount{Type: "bind", Source: "/src", Target: "/dst", ReadOnly: true, BindOptions: &docker.BindOptions{Propagation: "rslave"}},
+		},
+		{
+			name:  "volume",
+			mount: Mount{Type: MountVolume, Source: "cache", Destination: "/cache", NoCopy: true},
+			want:  docker.HostMount{Type: "volume", Source: "cache", Target: "/cache", VolumeOptions: &docker.VolumeOptions{NoCopy: true}},
+		},
+		{
+			name:  "tmpfs",
+			mount: Mount{Type: MountTmpfs, Destination: "/tmp", TmpfsSize: 1 << 20, TmpfsMode: 01777},
+			want:  docker.HostMount{Type: "tmpfs", Target: "/tmp", TempfsOptions: &docker.TempfsOptions{SizeBytes: 1 << 20, Mode: 01777}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, convertMount[docker.HostMount](tt.mount))
+		})
+	}
+}
+
+func Test_convertMount_OCI(t *testing.T) {
+	tests := []struct {
+		name  string
+		mount Mount
+		want  specs.Mount
+	}{
+		{
+			name:  "bind",
+			mount: Mount{Source: "/src", Destination: "/dst", Options: []string{"rbind"}, ReadOnly: true, Propagation: "rprivate", SELinuxRelabel: "z"},
+			want:  specs.Mount{Type: "bind", Source: "/src", Destination: "/dst", Options: []string{"rbind", "ro", "rprivate", "z"}},
+		},
+		{
+			name:  "volume",
+			mount: Mount{Type: MountVolume, Source: "cache", Destination: "/cache", Options: []string{"nocopy"}},
+			want:  specs.Mount{Type: "volume", Source: "cache", Destination: "/cache", Options: []string{"nocopy"}},
+		},
+		{
+			name:  "tmpfs",
+			mount: Mount{Type: MountTmpfs, Destination: "/tmp", Options: []string{"noexec"}, TmpfsSize: 1 << 20, TmpfsMode: 0700},
+			want:  specs.Mount{Type: "tmpfs", Source: "tmpfs", Destination: "/tmp", Options: []string{"noexec", "size=1048576", "mode=700"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, convertMount[specs.Mount](tt.mount))
+		})
+	}
+}
+
+func Test_dockerMounts(t *testing.T) {
+	mounts, binds := dockerMounts([]Mount{
+		{Source: "/src", Destination: "/dst", ReadOnly: true},
+		{Source: "/data", Destination: "/data", Options: []string{"ro", "Z"}, Propagation: "rshared"},
+	})
+	assert.Equal(t, []docker.HostMount{{Type: "bind", Source: "/src", Target: "/dst", ReadOnly: true}}, mounts)
+	assert.Equal(t, []string{"/data:/data:ro,rshared,Z"}, binds)
+
+	mounts, binds = dockerMounts(nil)
+	assert.Equal(t, []docker.HostMount{}, mounts)
+	assert.Nil(t, binds)
+}
+
+func TestMount_Backends(t *testing.T) {
+	volume := Mount{Type: MountVolume, Source: "cache", Destination: "/cache"}
+	relabel := Mount{Source: "/src", Destination: "/dst", SELinuxRelabel: "z"}
+	noCopy := Mount{Type: MountVolume, Source: "cache", Destination: "/cache", NoCopy: true}
+	option := Mount{Source: "/src", Destination: "/dst", Options: []string{"noexec"}}
+
+	config := func(mounts ...Mount) Config {
+		return Config{ContainerConfig: ContainerConfig{Image: "busybox", Mounts: mounts}, Namespace: "unit-test"}
+	}
+	_, err := getDockerExecution(config(volume, relabel, noCopy))
+	assert.NoError(t, err)
+	// Docker ignores the options without a field of Mount, as it always did
+	_, err = getDockerExecution(config(option))
+	assert.NoError(t, err)
+	_, err = getDockerExecution(config(Mount{Source: "/src", Destination: "/dst", Options: []string{"rbind"}}))
+	assert.NoError(t, err)
+	_, err = getDockerExecution(config(getMounts()...))
+	assert.NoError(t, err)
+	assert.NotPanics(t, func() { Command(&docker.Client{}, config(getMounts()...)) })
+
+	_, err = getContainerdExecution(config(volume, option))
+	assert.NoError(t, err)
+	_, err = getContainerdExecution(config(relabel))
+	assert.EqualError(t, err, "dexec: SELinux relabel of mount /dst is not supported by this backend")
+	_, err = getContainerdExecution(config(noCopy))
+	assert.EqualError(t, err, "dexec: nocopy of mount /cache is not supported by this backend")
+
+	assert.NoError(t, checkMounts([]Mount{option}, mountSupport{}))
+	assert.EqualError(t, checkMounts([]Mount{volume}, mountSupport{}), "dexec: volume cache of mount /cache is not supported by this backend")
+}
+
+func Test_createTask_buildCreateContainerArgs_Mounts(t *testing.T) {
+	task := &createTask{opts: CreateTaskOptions{
+		Image: "busybox",
+		Mounts: convertMounts[specs.Mount]([]Mount{
+			{Type: MountVolume, Source: "cache", Destination: "/cache", ReadOnly: true},
+			{Type: MountTmpfs, Destination: "/tmp", TmpfsSize: 1 << 20},
+			{Type: MountTmpfs, Destination: "/run"},
+		}),
+	}}
+	args := task.buildCreateContainerArgs(Containerd{Namespace: "unit-test"})
+	assert.Equal(t, []string{"-v", "cache:/cache:ro", "--tmpfs", "/tmp:size=1048576", "--tmpfs", "/run", "busybox"}, args[7:])
+}
+
+func TestMount_Podman(t *testing.T) {
+	server := newFakePodmanServer(t, echoProcess)
+	cmd := newFakePodmanCmd(t, server, Config{ContainerConfig: ContainerConfig{Mounts: []Mount{
+		{Type: MountVolume, Source: "cache", Destination: "/cache", NoCopy: true},
+		{Type: MountTmpfs, Destination: "/tmp", TmpfsMode: 01777},
+		{Source: "/src", Destination: "/src", SELinuxRelabel: "Z"},
+	}}}, "echo")
+
+	assert.NoError(t, cmd.Start())
+	spec := server.Spec(cmd.GetPID())
+	assert.NoError(t, cmd.Wait())
+	assert.Equal(t, []podmanVolume{{Name: "cache", Dest: "/cache", Options: []string{"nocopy"}}}, spec.Volumes)
+	assert.Equal(t, []specs.Mount{
+		{Type: "tmpfs", Source: "tmpfs", Destination: "/tmp", Options: []string{"mode=1777"}},
+		{Type: "bind", Source: "/src", Destination: "/src", Options: []string{"Z"}},
+	}, spec.Mounts)
+}
@@ -0,0 +1,97 @@
+package dexec
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// DefaultNameTemplate is the NameTemplate of commands that do not set one. It names
+// containers after their CommandDetails.
+const DefaultNameTemplate = "chains-{{.chainExecutorId}}-{{.executorId}}-{{.resultId}}"
+
+// MetadataLabelPrefix prefixes the keys of Config.Metadata in the labels of containers
+const MetadataLabelPrefix = "dexec/metadata."
+
+// maxNameLength is the maximum length of a container name, the limit of containerd identifiers
+const maxNameLength = 76
+
+// nameData returns the data NameTemplate is executed with: the CommandDetails, overridden by metadata
+func nameData(metadata map[string]string, details CommandDetails) map[string]string {
+	data := map[string]string{
+		"executorId":      strconv.FormatInt(details.ExecutorId, 10),
+		"chainExecutorId": strconv.FormatInt(details.ChainExecutorId, 10),
+		"resultId":        strconv.FormatInt(details.ResultId, 10),
+	}
+	for key, value := range metadata {
+		data[key] = value
+	}
+	return data
+}
+
+// namePrefix executes text, DefaultNameTemplate if empty, with metadata and details and returns the result
+// sanitized to a valid container name
+func namePrefix(text string, metadata map[string]string, details CommandDetails) (string, error) {
+	if text == "" {
+		text = DefaultNameTemplate
+	}
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err = tmpl.Execute(&b, nameData(metadata, details)); err != nil {
+		return "", err
+	}
+	return sanitizeName(b.String()), nil
+}
+
+// configNamePrefix returns the prefix of the container names of the command of config
+func configNamePrefix(config Config) (string, error) {
+	prefix, err := namePrefix(config.NameTemplate, config.Metadata, config.CommandDetails)
+	if err != nil {
+		return "", fmt.Errorf("dexec: invalid NameTemplate: %w", err)
+	}
+	return prefix, nil
+}
+
+// containerName returns a container name made of prefix and the unique suffix. The prefix is truncated so that the
+// name is not too long, but never the suffix, so that two names never differ only by the part cut off.
+func containerName(prefix, suffix string) string {
+	if max := maxNameLength - len(suffix) - 1; len(prefix) > max {
+		prefix = strings.TrimRight(prefix[:max], "._-")
+	}
+	if prefix == "" {
+		return suffix
+	}
+	return prefix + "-" + suffix
+}
+
+// sanitizeName returns s as a name valid for both containerd and Docker: alphanumerics separated by single '.', '_'
+// or '-'. Other characters are replaced by '-', and runs of separators are replaced by their first separator, so
+// that e.g. the name of negative IDs does not have two hyphens in a row. It returns "dexec" if nothing is left.
+func sanitizeName(s string) string {
+	var b strings.Builder
+	var sep byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+			if sep != 0 && b.Len() > 0 {
+				b.WriteByte(sep)
+			}
+			sep = 0
+			b.WriteByte(c)
+		case sep != 0:
+		case c == '.' || c == '_':
+			sep = c
+		default:
+			sep = '-'
+		}
+	}
+	if b.Len() == 0 {
+		return "dexec"
+	}
+	return b.String()
+}
@@ -0,0 +1,100 @@
+package dexec
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_sanitizeName(t *testing.T) {
+	for s, want := range map[string]string{
+		"chains-1-2-3":         "chains-1-2-3",
+		"chains--1-2-3":        "chains-1-2-3",
+		"build/main#42":        "build-main-42",
+		"-_.leading.trailing_": "leading.trailing",
+		"a._-b":                "a.b",
+		"déjà vu":              "d-j-vu",
+		"":                     "dexec",
+		"---":                  "dexec",
+	} {
+		assert.Equal(t, want, sanitizeName(s), s)
+	}
+}
+
+func Test_namePrefix(t *testing.T) {
+	details := CommandDetails{ExecutorId: 2, ChainExecutorId: -1, ResultId: 3}
+	prefix, err := namePrefix("", nil, details)
+	assert.NoError(t, err)
+	assert.Equal(t, "chains-1-2-3", prefix)
+
+	prefix, err = namePrefix("{{.app}}/{{.build}}-{{.resultId}}", map[string]string{"app": "ci", "build": "main#42"}, details)
+	assert.NoError(t, err)
+	assert.Equal(t, "ci-main-42-3", prefix)
+
+	_, err = namePrefix("{{.app}}", nil, details)
+	assert.ErrorContains(t, err, `map has no entry for key "app"`)
+	_, err = namePrefix("{{.app", nil, details)
+	assert.Error(t, err)
+}
+
+func Test_containerName(t *testing.T) {
+	assert.Equal(t, "ci-abc123", containerName("ci", "abc123"))
+
+	// the prefix is truncated, never the suffix
+	name := containerName(strings.Repeat("a", 68)+"-"+strings.Repeat("b", 20), "abc123")
+	assert.Equal(t, strings.Repeat("a", 68)+"-abc123", name)
+}
+
+func TestNameTemplate_Docker(t *testing.T) {
+	server := newFakeDockerServer(t, echoProcess)
+	cmd, err := NewCommand(server.Client(t).Client, WithImage("busybox"), WithCommand("echo"), func(c *Config) {
+		c.NameTemplate = "{{.app}}-{{.build}}"
+		c.Metadata = map[string]string{"app": "ci", "build": "42"}
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, cmd.Start())
+	assert.Regexp(t, regexp.MustCompile(`^ci-42-[a-zA-Z]{6}$`), server.Name(cmd.GetPID()))
+	assert.Equal(t, "42", server.Config(cmd.GetPID()).Labels[MetadataLabelPrefix+"build"])
+	assert.NoError(t, cmd.Wait())
+
+	// without a template, Docker containers are unnamed
+	cmd, err = NewCommand(server.Client(t).Client, WithImage("busybox"), WithCommand("echo"))
+	assert.NoError(t, err)
+	assert.NoError(t, cmd.Start())
+	assert.Empty(t, server.Name(cmd.GetPID()))
+	assert.NoError(t, cmd.Wait())
+}
+
+func TestNameTemplate_Invalid(t *testing.T) {
+	_, err := NewCommand(&Local{}, WithCommand("true"), func(c *Config) { c.NameTemplate = "{{.app}}" })
+	assert.EqualError(t, err, `dexec: invalid config: NameTemplate: template: name:1:2: executing "name" at <.app>: map has no entry for key "app"`)
+
+	_, err = getContainerdExecution(Config{NameTemplate: "{{.app"})
+	assert.ErrorContains(t, err, "dexec: invalid NameTemplate: ")
+	_, err = newPodmanContainer(&Podman{}, Config{NameTemplate: "{{.app"})
+	assert.ErrorContains(t, err, "dexec: invalid NameTemplate: ")
+}
+
+func TestGetStatsBy(t *testing.T) {
+	build1 := createMockContainer(map[string]string{MetadataLabelPrefix + "build": "1"})
+	returnMockTaskWithStatus(build1, containerd.Running, nil)
+	build2 := createMockContainer(map[string]string{MetadataLabelPrefix + "build": "2"})
+	returnMockTaskWithStatus(build2, containerd.Stopped, nil)
+	other := createMockContainer(nil)
+	returnMockTaskWithStatus(other, containerd.Running, nil)
+	client := new(client)
+	client.On("Containers", mock.Anything, []string{`labels."wk/owner"=="chains"`}).Return([]containerd.Container{build1, build2, other}, nil)
+
+	stats, err := GetStatsBy(Containerd{ContainerdClient: client, Namespace: "unit-test"}, "build")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]Stats{"1": {Running: 1}, "2": {Stopped: 1}, "": {Running: 1}}, stats)
+
+	stats, err = GetStatsBy(&Local{}, "build")
+	assert.NoError(t, err)
+	assert.Empty(t, stats)
+}
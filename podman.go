@@ -0,0 +1,187 @@
+package dexec
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// PodmanBackend is the name of the Podman backend. Commands are run with Podman when
+// Command is given a *Podman.
+const PodmanBackend = "podman"
+
+// podmanAPIVersion is the version of the libpod API used, which is supported by Podman 4 and later
+const podmanAPIVersion = "v4.0.0"
+
+// Podman contains a connection to the libpod API of Podman. Use NewPodman to
+// initialize it, or set Socket.
+type Podman struct {
+	// Socket is the path of the unix socket of the Podman API service
+	Socket string
+	// UserNS is the user namespace mode of the containers, e.g. "keep-id" to run
+	// commands of rootless Podman as the user running Podman, or "auto". If empty,
+	// the default of Podman is used.
+	UserNS string
+
+	once   sync.Once
+	client *http.Client
+}
+
+func init() {
+	RegisterBackend(PodmanBackend, func(client interface{}, config Config) (Backend, error) {
+		p, ok := client.(*Podman)
+		if !ok {
+			return nil, ErrUnsupportedClient
+		}
+		return newPodmanContainer(p, config)
+	})
+}
+
+// NewPodman returns a Podman connected to the API service listening on socket.
+// See DefaultPodmanSocket.
+func NewPodman(socket string) *Podman {
+	return &Podman{Socket: socket}
+}
+
+// httpClient returns the client of the API, connecting to Socket on first use so that
+// a Podman built as a literal works like one returned by NewPodman
+func (p *Podman) httpClient() *http.Client {
+	p.once.Do(func() {
+		socket := p.Socket
+		p.client = &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		}
+	})
+	return p.client
+}
+
+// DefaultPodmanSocket returns the default socket of the Podman API service: the one
+// of rootless Podman when XDG_RUNTIME_DIR is set and of rootful Podman otherwise.
+func DefaultPodmanSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" && os.Geteuid() != 0 {
+		return filepath.Join(dir, "podman", "podman.sock")
+	}
+	return "/run/podman/podman.sock"
+}
+
+// podmanError is an error response of the Podman API
+type podmanError struct {
+	Status  int
+	Message string
+}
+
+func (e *podmanError) Error() string {
+	return fmt.Sprintf("podman: %s (status %d)", e.Message, e.Status)
+}
+
+func isPodmanStatus(err error, status int) bool {
+	pe, ok := err.(*podmanError)
+	return ok && pe.Status == status
+}
+
+func (p *Podman) url(path string, query url.Values) string {
+	u := "http://podman/" + podmanAPIVersion + "/libpod" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// do sends a request to the API. Responses with an error status are returned as a *podmanError.
+func (p *Podman) do(ctx context.Context, method, path string, query url.Values, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, p.url(path, query), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := p.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		var e struct {
+			Message string `json:"message"`
+		}
+		b, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(b, &e) != nil || e.Message == "" {
+			e.Message = strings.TrimSpace(string(b))
+		}
+		return nil, &podmanError{Status: resp.StatusCode, Message: e.Message}
+	}
+	return resp, nil
+}
+
+// doJSON sends in as the JSON body of a request and decodes the JSON response into out, if not nil
+func (p *Podman) doJSON(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body io.Reader
+	header := http.Header{}
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = strings.NewReader(string(b))
+		header.Set("Content-Type", "application/json")
+	}
+	resp, err := p.do(ctx, method, path, query, body, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// attach opens a connection streaming the standard streams of a container. The connection carries stdin to the
+// container and the multiplexed stdout and stderr from it.
+func (p *Podman) attach(ctx context.Context, id string) (net.Conn, *bufio.Reader, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", p.Socket)
+	if err != nil {
+		return nil, nil, err
+	}
+	query := url.Values{"stdin": {"true"}, "stdout": {"true"}, "stderr": {"true"}, "stream": {"true"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url("/containers/"+id+"/attach", query), nil)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols && resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		conn.Close()
+		return nil, nil, &podmanError{Status: resp.StatusCode, Message: strings.TrimSpace(string(b))}
+	}
+	return conn, br, nil
+}
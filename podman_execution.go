@@ -0,0 +1,435 @@
+package dexec
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// podmanSpec is the subset of the libpod SpecGenerator used to create containers
+type podmanSpec struct {
+	Name        string            `json:"name,omitempty"`
+	Image       string            `json:"image"`
+	Entrypoint  []string          `json:"entrypoint,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	WorkDir     string            `json:"work_dir,omitempty"`
+	User        string            `json:"user,omitempty"`
+	Mounts      []specs.Mount     `json:"mounts,omitempty"`
+	Volumes     []podmanVolume    `json:"volumes,omitempty"`
+	DNSServers  []string          `json:"dns_server,omitempty"`
+	DNSSearch   []string          `json:"dns_search,omitempty"`
+	DNSOptions  []string          `json:"dns_option,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Stdin       bool              `json:"stdin"`
+	Timeout     uint              `json:"timeout,omitempty"`
+	UserNS      *podmanNamespace  `json:"userns,omitempty"`
+
+	CapAdd          []string `json:"cap_add,omitempty"`
+	CapDrop         []string `json:"cap_drop,omitempty"`
+	SeccompProfile  string   `json:"seccomp_profile_path,omitempty"`
+	AppArmorProfile string   `json:"apparmor_profile,omitempty"`
+	NoNewPrivileges bool     `json:"no_new_privileges,omitempty"`
+	ReadOnlyRootfs  bool     `json:"read_only_filesystem,omitempty"`
+	Mask            []string `json:"mask,omitempty"`
+
+	OCIRuntime string `json:"oci_runtime,omitempty"`
+}
+
+// podmanVolume is a named volume of a podmanSpec
+type podmanVolume struct {
+	Name    string
+	Dest    string
+	Options []string `json:",omitempty"`
+}
+
+type podmanNamespace struct {
+	NSMode string `json:"nsmode"`
+}
+
+// podmanContainer is the Backend running a command in a new Podman container. The
+// container is created and started with Cmd.Start and removed before Cmd.Wait returns.
+type podmanContainer struct {
+	podman   *Podman
+	spec     podmanSpec
+	env      []string
+	envMerge MergeMode
+	dirMerge MergeMode
+	image    ImageOptions
+	security Security
+	// namePrefix, if not empty, names the container with a unique suffix generated by ids
+	namePrefix string
+	ids        IDGenerator
+	digest     digest.Digest
+	id         string
+	conn       net.Conn
+	streamed   chan error
+}
+
+func newPodmanContainer(p *Podman, config Config) (*podmanContainer, error) {
+	if config.ContainerConfig.RuntimeOptions != (RuntimeOptions{}) {
+		return nil, errRuntimeOptionsUnsupported
+	}
+	var prefix string
+	if config.NameTemplate != "" {
+		var err error
+		if prefix, err = configNamePrefix(config); err != nil {
+			return nil, err
+		}
+	}
+	c := &podmanContainer{
+		podman:   p,
+		env:      config.ContainerConfig.Env,
+		envMerge: config.ContainerConfig.EnvMerge,
+		dirMerge: config.TaskConfig.DirMerge,
+		image:    getImageOptions(config),
+		spec: podmanSpec{
+			Image:       config.ContainerConfig.Image,
+			WorkDir:     config.TaskConfig.WorkingDir,
+			User:        config.ContainerConfig.User,
+			DNSServers:  config.NetworkConfig.DNS,
+			DNSSearch:   config.NetworkConfig.DNSSearch,
+			DNSOptions:  config.NetworkConfig.DNSOptions,
+			Timeout:     uint(config.TaskConfig.Timeout.Seconds()),
+			Labels:      commandLabels(config.ContainerConfig.Labels, config.ContainerConfig.Owner, config.Metadata, config.CommandDetails),
+			Annotations: config.ContainerConfig.Annotations,
+
+			CapAdd:          config.ContainerConfig.Security.CapAdd,
+			CapDrop:         config.ContainerConfig.Security.CapDrop,
+			AppArmorProfile: config.ContainerConfig.Security.AppArmorProfile,
+			NoNewPrivileges: config.ContainerConfig.Security.NoNewPrivileges,
+			ReadOnlyRootfs:  config.ContainerConfig.Security.ReadOnlyRootfs,
+			Mask:            config.ContainerConfig.Security.MaskedPaths,
+
+			OCIRuntime: config.ContainerConfig.Runtime,
+		},
+		security:   config.ContainerConfig.Security,
+		namePrefix: prefix,
+		ids:        config.IDGenerator,
+	}
+	for _, m := range config.ContainerConfig.Mounts {
+		if m.resolved().Type == MountVolume {
+			v := convertMount[specs.Mount](m)
+			c.spec.Volumes = append(c.spec.Volumes, podmanVolume{Name: v.Source, Dest: v.Destination, Options: v.Options})
+			continue
+		}
+		c.spec.Mounts = append(c.spec.Mounts, convertMount[specs.Mount](m))
+	}
+	if p.UserNS != "" {
+		c.spec.UserNS = &podmanNamespace{NSMode: p.UserNS}
+	}
+	return c, nil
+}
+
+func (c *podmanContainer) SetEnv(env []string) error {
+	env, err := mergeEnv(c.envMerge, c.env, env)
+	if err != nil {
+		return err
+	}
+	c.env = env
+	return nil
+}
+
+func (c *podmanContainer) SetDir(dir string) error {
+	dir, err := mergeDir(c.dirMerge, c.spec.WorkDir, dir)
+	if err != nil {
+		return err
+	}
+	c.spec.WorkDir = dir
+	return nil
+}
+
+func (c *podmanContainer) Create(cmd []string) error {
+	env, err := podmanEnv(c.env)
+	if err != nil {
+		return err
+	}
+	c.spec.Env = env
+	c.spec.Entrypoint = cmd
+	c.spec.Stdin = true
+	if err = c.ensureImage(); err != nil {
+		return err
+	}
+	// Podman reads seccomp profiles from files when the container is created
+	seccompFile, removeSeccompFile, err := c.security.writeSeccompProfile()
+	if err != nil {
+		return err
+	}
+	defer removeSeccompFile()
+	c.spec.SeccompProfile = seccompFile
+	if c.security.SeccompProfile == Unconfined {
+		c.spec.SeccompProfile = Unconfined
+	}
+	var resp struct {
+		ID string `json:"Id"`
+	}
+	// a named container is created again with a new name when its name is already used
+	for attempt := 1; ; attempt++ {
+		if c.namePrefix != "" {
+			c.spec.Name = containerName(c.namePrefix, newID(c.ids))
+		}
+		err = c.podman.doJSON(context.Background(), http.MethodPost, "/containers/create", nil, c.spec, &resp)
+		if c.namePrefix == "" || !isPodmanStatus(err, http.StatusConflict) || attempt == maxNameAttempts {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("dexec: failed to create container: %w", err)
+	}
+	c.id = resp.ID
+	return nil
+}
+
+// podmanEnv converts KEY=VALUE pairs to the map of environment variables of a libpod spec
+func podmanEnv(env []string) (map[string]string, error) {
+	if len(env) == 0 {
+		return nil, nil
+	}
+	m := make(map[string]string, len(env))
+	for _, e := range env {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("dexec: invalid environment variable %q, expected KEY=VALUE", e)
+		}
+		m[kv[0]] = kv[1]
+	}
+	return m, nil
+}
+
+// ensureImage pins the image and makes it available to Podman according to the pull policy
+func (c *podmanContainer) ensureImage() error {
+	ctx := context.Background()
+	image, pin, err := pinImage(ctx, c.spec.Image, c.image)
+	if err != nil {
+		return err
+	}
+	if pin != "" {
+		// a pinned image is looked up and pulled by digest, like Docker and containerd do
+		if image, err = digestedReference(image, pin); err != nil {
+			return err
+		}
+	}
+	c.spec.Image = image
+	c.digest = pin
+	policy := c.image.PullPolicy
+	if policy == "" && pin != "" {
+		policy = PullIfNotPresent
+	}
+	if policy == "" {
+		return nil
+	}
+	present := false
+	if policy != PullAlways {
+		err = c.podman.doJSON(ctx, http.MethodGet, "/images/"+image+"/exists", nil, nil, nil)
+		if err != nil && !isPodmanStatus(err, http.StatusNotFound) {
+			return fmt.Errorf("dexec: failed to inspect image: %w", err)
+		}
+		present = err == nil
+		if !present && policy == PullNever {
+			return &ImageNotFoundError{Image: image, Err: err}
+		}
+	}
+	if !present {
+		if err = c.pullImage(ctx); err != nil {
+			return err
+		}
+	}
+	if pin == "" {
+		return nil
+	}
+	if c.spec.Labels == nil {
+		c.spec.Labels = make(map[string]string)
+	}
+	c.spec.Labels[imageDigestLabel] = pin.String()
+	var img struct {
+		RepoDigests []string
+	}
+	if err = c.podman.doJSON(ctx, http.MethodGet, "/images/"+image+"/json", nil, nil, &img); err != nil {
+		return fmt.Errorf("dexec: failed to inspect image: %w", err)
+	}
+	return verifyRepoDigests(image, img.RepoDigests, pin)
+}
+
+func (c *podmanContainer) pullImage(ctx context.Context) error {
+	image := c.spec.Image
+	auth, err := c.image.Auth.dockerAuth(image)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	if auth.Username != "" || auth.IdentityToken != "" {
+		b, err := json.Marshal(auth)
+		if err != nil {
+			return err
+		}
+		header.Set("X-Registry-Auth", base64.URLEncoding.EncodeToString(b))
+	}
+	query := url.Values{"reference": {image}, "policy": {"always"}}
+	resp, err := c.podman.do(ctx, http.MethodPost, "/images/pull", query, nil, header)
+	if isPodmanStatus(err, http.StatusNotFound) {
+		return &ImageNotFoundError{Image: image, Err: err}
+	}
+	if err != nil {
+		return fmt.Errorf("dexec: failed to pull image: %w", err)
+	}
+	defer resp.Body.Close()
+	// the pull reports progress, and errors, as a stream of JSON objects
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var report struct {
+			Stream string `json:"stream"`
+			Error  string `json:"error"`
+		}
+		if err = dec.Decode(&report); err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("dexec: failed to pull image: %w", err)
+		}
+		if report.Error != "" {
+			return fmt.Errorf("dexec: failed to pull image: %s", report.Error)
+		}
+		if c.image.Progress != nil && report.Stream != "" {
+			io.WriteString(c.image.Progress, report.Stream)
+		}
+	}
+}
+
+func (c *podmanContainer) CopyIn(dst string, content io.Reader) error {
+	if c.id == "" {
+		return errors.New("dexec: container is not created")
+	}
+	resp, err := c.podman.do(context.Background(), http.MethodPut, "/containers/"+c.id+"/archive",
+		url.Values{"path": {dst}}, content, http.Header{"Content-Type": {"application/x-tar"}})
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (c *podmanContainer) CopyOut(src string, w io.Writer) error {
+	if c.id == "" {
+		return errors.New("dexec: container is not created")
+	}
+	resp, err := c.podman.do(context.Background(), http.MethodGet, "/containers/"+c.id+"/archive",
+		url.Values{"path": {src}}, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Start attaches to the container before starting it, so no output is missed
+func (c *podmanContainer) Start(stdin io.Reader, stdout, stderr io.Writer) error {
+	if c.id == "" {
+		return errors.New("dexec: container is not created")
+	}
+	conn, br, err := c.podman.attach(context.Background(), c.id)
+	if err != nil {
+		return fmt.Errorf("dexec: failed to attach container: %w", err)
+	}
+	if err = c.podman.doJSON(context.Background(), http.MethodPost, "/containers/"+c.id+"/start", nil, nil, nil); err != nil {
+		conn.Close()
+		return fmt.Errorf("dexec: failed to start container: %w", err)
+	}
+	c.conn = conn
+	c.streamed = make(chan error, 1)
+	go func() {
+		io.Copy(conn, stdin)
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+	}()
+	go func() {
+		_, err := stdcopy.StdCopy(stdout, stderr, br)
+		c.streamed <- err
+	}()
+	return nil
+}
+
+func (c *podmanContainer) Wait(beforeRemove func() error) (int, error) {
+	ctx := context.Background()
+	remove := func() error {
+		err := c.podman.doJSON(ctx, http.MethodDelete, "/containers/"+c.id, url.Values{"force": {"true"}}, nil, nil)
+		if isPodmanStatus(err, http.StatusNotFound) {
+			return nil
+		}
+		return err
+	}
+	defer remove()
+	if c.streamed == nil {
+		return -1, errors.New("dexec: container is not attached")
+	}
+	err := <-c.streamed
+	c.conn.Close()
+	if err != nil {
+		return -1, fmt.Errorf("dexec: attach error: %w", err)
+	}
+	var ec int
+	if err = c.podman.doJSON(ctx, http.MethodPost, "/containers/"+c.id+"/wait", nil, nil, &ec); err != nil {
+		return -1, fmt.Errorf("dexec: cannot wait for container: %w", err)
+	}
+	if beforeRemove != nil {
+		if err := beforeRemove(); err != nil {
+			return ec, err
+		}
+	}
+	if err = remove(); err != nil {
+		return -1, fmt.Errorf("dexec: error deleting container: %w", err)
+	}
+	return ec, nil
+}
+
+func (c *podmanContainer) ID() string {
+	return c.id
+}
+
+func (c *podmanContainer) ImageDigest() string {
+	return c.digest.String()
+}
+
+// stop stops the container. Podman answers 304 Not Modified, which is not an error, if it is not running.
+func (c *podmanContainer) stop() error {
+	return c.podman.doJSON(context.Background(), http.MethodPost, "/containers/"+c.id+"/stop",
+		url.Values{"timeout": {strconv.Itoa(1)}}, nil, nil)
+}
+
+func (c *podmanContainer) Kill() error {
+	err := c.stop()
+	// if container doesn't exist or already is killed
+	// do not return an error
+	if err == nil || isPodmanStatus(err, http.StatusNotFound) {
+		return nil
+	}
+	return fmt.Errorf("error stopping container: %w", err)
+}
+
+func (c *podmanContainer) Cleanup() error {
+	err := c.stop()
+	// if container doesn't exist we have nothing else to do
+	if isPodmanStatus(err, http.StatusNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error stopping container: %w", err)
+	}
+	err = c.podman.doJSON(context.Background(), http.MethodDelete, "/containers/"+c.id, nil, nil, nil)
+	if err != nil && !isPodmanStatus(err, http.StatusNotFound) {
+		return fmt.Errorf("error removing container: %w", err)
+	}
+	return nil
+}
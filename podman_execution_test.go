@@ -0,0 +1,338 @@
+package dexec
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFakePodmanCmd(t *testing.T, server *fakePodmanServer, config Config, name string, arg ...string) *BackendCmd {
+	config.ContainerConfig.Image = "busybox"
+	config.TaskConfig.Executable = name
+	config.TaskConfig.Args = arg
+	return Command(server.Podman(), config).(*BackendCmd)
+}
+
+func TestCommand_Podman(t *testing.T) {
+	server := newFakePodmanServer(t, echoProcess)
+	p := server.Podman()
+	p.UserNS = "keep-id"
+	cmd := Command(p, Config{
+		ContainerConfig: ContainerConfig{Image: "busybox", User: "1000", Mounts: []Mount{{Type: "bind", Source: "/src", Destination: "/dst", Options: []string{"ro"}}}},
+		NetworkConfig:   NetworkConfig{DNS: []string{"1.1.1.1"}, DNSSearch: []string{"example.com"}, DNSOptions: []string{"ndots:1"}},
+		TaskConfig:      TaskConfig{Executable: "echo", Args: []string{"hi"}, WorkingDir: "/work"},
+	})
+	assert.IsType(t, &BackendCmd{}, cmd)
+	assert.Equal(t, PodmanBackend, cmd.(*BackendCmd).client.Name)
+
+	assert.NoError(t, cmd.Start())
+	spec := server.Spec(cmd.GetPID())
+	assert.NoError(t, cmd.Wait())
+
+	assert.Equal(t, "busybox", spec.Image)
+	assert.Equal(t, []string{"echo", "hi"}, spec.Entrypoint)
+	assert.Equal(t, "1000", spec.User)
+	assert.Equal(t, "/work", spec.WorkDir)
+	assert.Len(t, spec.Mounts, 1)
+	assert.Equal(t, "/dst", spec.Mounts[0].Destination)
+	assert.Equal(t, []string{"1.1.1.1"}, spec.DNSServers)
+	assert.Equal(t, []string{"example.com"}, spec.DNSSearch)
+	assert.Equal(t, []string{"ndots:1"}, spec.DNSOptions)
+	assert.True(t, spec.Stdin)
+	assert.Equal(t, &podmanNamespace{NSMode: "keep-id"}, spec.UserNS)
+}
+
+func TestCommand_Podman_Literal(t *testing.T) {
+	server := newFakePodmanServer(t, echoProcess)
+	cmd := Command(&Podman{Socket: server.Socket}, Config{
+		ContainerConfig: ContainerConfig{Image: "busybox"},
+		TaskConfig:      TaskConfig{Executable: "echo", Args: []string{"hi"}},
+	})
+	out, err := cmd.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", string(out))
+}
+
+func Test_podmanContainer_Run(t *testing.T) {
+	server := newFakePodmanServer(t, echoProcess)
+	cmd := newFakePodmanCmd(t, server, Config{}, "echo", "hello", "world")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader("input")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = []string{"A=1", "B=x=y"}
+	cmd.Dir = "/work"
+
+	assert.NoError(t, cmd.Start())
+	spec := server.Spec(cmd.GetPID())
+	assert.NoError(t, cmd.Wait())
+
+	assert.Equal(t, "hello world", stdout.String())
+	assert.Equal(t, "input", stderr.String())
+	assert.Equal(t, map[string]string{"A": "1", "B": "x=y"}, spec.Env)
+	assert.Equal(t, "/work", spec.WorkDir)
+	assert.Nil(t, spec.UserNS)
+	assert.Equal(t, 0, cmd.GetProcessState().ExitCode)
+	assert.Empty(t, server.Containers())
+	assert.Equal(t, []string{cmd.GetPID()}, server.Removed())
+}
+
+func Test_podmanContainer_InvalidEnv(t *testing.T) {
+	server := newFakePodmanServer(t, echoProcess)
+	cmd := newFakePodmanCmd(t, server, Config{ContainerConfig: ContainerConfig{Env: []string{"NOVALUE"}}}, "echo")
+
+	assert.ErrorContains(t, cmd.Run(), `invalid environment variable "NOVALUE"`)
+	assert.Empty(t, server.Containers())
+}
+
+func Test_podmanContainer_Output_ExitCode(t *testing.T) {
+	server := newFakePodmanServer(t, echoProcess)
+	cmd := newFakePodmanCmd(t, server, Config{ContainerConfig: ContainerConfig{Env: []string{"EXIT_CODE=3"}}}, "echo", "out")
+	cmd.Stdin = strings.NewReader("err")
+
+	out, err := cmd.Output()
+	var ee *ExitError
+	assert.True(t, errors.As(err, &ee))
+	assert.Equal(t, 3, ee.ExitCode)
+	assert.Equal(t, "err", string(ee.Stderr))
+	assert.Equal(t, "out", string(out))
+	assert.Empty(t, server.Containers())
+}
+
+func Test_podmanContainer_Kill(t *testing.T) {
+	server := newFakePodmanServer(t, func(p *fakeProcess) int {
+		<-p.Stopped
+		return 0
+	})
+	cmd := newFakePodmanCmd(t, server, Config{}, "sleep", "1000")
+
+	assert.NoError(t, cmd.Start())
+	assert.NoError(t, cmd.Kill())
+	var ee *ExitError
+	assert.True(t, errors.As(cmd.Wait(), &ee))
+	assert.Equal(t, 137, ee.ExitCode)
+	assert.Empty(t, server.Containers())
+
+	// the container is gone, so there is nothing to kill or clean up
+	assert.NoError(t, cmd.Kill())
+	assert.NoError(t, cmd.Cleanup())
+}
+
+func Test_podmanContainer_Errors(t *testing.T) {
+	tests := []struct {
+		op      string
+		wantErr string
+		removed bool
+	}{
+		{op: "create", wantErr: "dexec: failed to create container"},
+		{op: "attach", wantErr: "dexec: failed to attach container"},
+		{op: "start", wantErr: "dexec: failed to start container"},
+		{op: "wait", wantErr: "dexec: cannot wait for container", removed: true},
+		{op: "remove", wantErr: "dexec: error deleting container"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.op, func(t *testing.T) {
+			server := newFakePodmanServer(t, echoProcess)
+			server.Fail(tt.op, http.StatusInternalServerError)
+			cmd := newFakePodmanCmd(t, server, Config{}, "echo")
+
+			err := cmd.Run()
+			assert.ErrorContains(t, err, tt.wantErr)
+			assert.ErrorContains(t, err, tt.op+" failed")
+			assert.Equal(t, tt.removed, len(server.Removed()) == 1)
+		})
+	}
+}
+
+func Test_podmanContainer_Cleanup(t *testing.T) {
+	blocked := func(p *fakeProcess) int {
+		<-p.Stopped
+		return 0
+	}
+	tests := []struct {
+		name    string
+		process func(p *fakeProcess) int
+		fail    string
+		wantErr string
+		removed bool
+	}{
+		{name: "running", process: blocked, removed: true},
+		{name: "exited", process: echoProcess, removed: true},
+		{name: "stop error", process: blocked, fail: "stop", wantErr: "error stopping container"},
+		{name: "remove error", process: echoProcess, fail: "remove", wantErr: "error removing container"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakePodmanServer(t, tt.process)
+			cmd := newFakePodmanCmd(t, server, Config{}, "echo")
+			assert.NoError(t, cmd.Start())
+			if tt.fail != "" {
+				server.Fail(tt.fail, http.StatusInternalServerError)
+			}
+
+			err := cmd.Cleanup()
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.removed, len(server.Removed()) == 1)
+		})
+	}
+}
+
+func Test_podmanContainer_Copy(t *testing.T) {
+	server := newFakePodmanServer(t, echoProcess)
+	c, err := newPodmanContainer(server.Podman(), Config{ContainerConfig: ContainerConfig{Image: "busybox"}})
+	assert.NoError(t, err)
+	assert.ErrorContains(t, c.CopyIn("/in", strings.NewReader("archive")), "container is not created")
+
+	assert.NoError(t, c.Create([]string{"true"}))
+	assert.NoError(t, c.CopyIn("/in", strings.NewReader("archive")))
+	assert.Equal(t, "archive", string(server.Archive(c.ID(), "/in")))
+
+	var out bytes.Buffer
+	assert.NoError(t, c.CopyOut("/in", &out))
+	assert.Equal(t, "archive", out.String())
+	assert.True(t, isPodmanStatus(c.CopyOut("/missing", &out), http.StatusNotFound))
+}
+
+func Test_podmanContainer_ensureImage(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   PullPolicy
+		present  bool
+		inRepo   bool
+		pulled   bool
+		notFound bool
+		pullErr  bool
+	}{
+		{name: "no policy"},
+		{name: "if not present, present", policy: PullIfNotPresent, present: true},
+		{name: "if not present, missing", policy: PullIfNotPresent, inRepo: true, pulled: true},
+		{name: "if not present, missing in registry", policy: PullIfNotPresent, pulled: true, pullErr: true},
+		{name: "never, present", policy: PullNever, present: true},
+		{name: "never, missing", policy: PullNever, notFound: true},
+		{name: "always", policy: PullAlways, present: true, inRepo: true, pulled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakePodmanServer(t, echoProcess)
+			if tt.present {
+				server.AddImage("busybox:1.36")
+			}
+			if tt.inRepo {
+				server.AddRegistryImage("busybox:1.36")
+			}
+			var progress strings.Builder
+			c, err := newPodmanContainer(server.Podman(), Config{ContainerConfig: ContainerConfig{
+				Image: "busybox:1.36", PullPolicy: tt.policy, PullProgress: &progress,
+			}})
+			assert.NoError(t, err)
+
+			err = c.ensureImage()
+			var infe *ImageNotFoundError
+			assert.Equal(t, tt.notFound, errors.As(err, &infe))
+			if tt.pullErr {
+				assert.ErrorContains(t, err, "dexec: failed to pull image: initializing source busybox:1.36: manifest unknown")
+			} else if !tt.notFound {
+				assert.NoError(t, err)
+			}
+			pulls, _ := server.Pulls()
+			if tt.pulled {
+				assert.Equal(t, []string{"busybox:1.36"}, pulls)
+				assert.Contains(t, progress.String(), "Trying to pull busybox:1.36")
+			} else {
+				assert.Empty(t, pulls)
+			}
+		})
+	}
+}
+
+func Test_podmanContainer_ensureImage_Auth(t *testing.T) {
+	server := newFakePodmanServer(t, echoProcess)
+	server.AddRegistryImage("registry.example.com/app:1")
+	c, err := newPodmanContainer(server.Podman(), Config{ContainerConfig: ContainerConfig{
+		Image:        "registry.example.com/app:1",
+		PullPolicy:   PullAlways,
+		RegistryAuth: &RegistryAuth{Username: "user", Password: "secret"},
+	}})
+	assert.NoError(t, err)
+
+	assert.NoError(t, c.ensureImage())
+	_, auths := server.Pulls()
+	assert.Len(t, auths, 1)
+	b, err := base64.URLEncoding.DecodeString(auths[0])
+	assert.NoError(t, err)
+	var auth docker.AuthConfiguration
+	assert.NoError(t, json.Unmarshal(b, &auth))
+	assert.Equal(t, docker.AuthConfiguration{Username: "user", Password: "secret", ServerAddress: "registry.example.com"}, auth)
+}
+
+func Test_podmanContainer_ensureImage_Digest(t *testing.T) {
+	pinned := digest.FromString("pinned")
+	retagged := []string{"busybox@" + digest.FromString("retagged").String()}
+	tests := []struct {
+		name     string
+		local    []string
+		registry []string
+		pulled   bool
+		failed   bool
+	}{
+		// busybox:1.36 refers to another image locally and in the registry, the pinned image is used anyway
+		{name: "local", local: []string{"busybox@" + digest.FromString("other").String(), "busybox@" + pinned.String()}},
+		{name: "pulled", registry: []string{"busybox@" + pinned.String()}, pulled: true},
+		{name: "missing in registry", pulled: true, failed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakePodmanServer(t, echoProcess)
+			server.AddImage("busybox:1.36", retagged...)
+			server.AddRegistryImage("busybox:1.36", retagged...)
+			if tt.local != nil {
+				server.AddImage("busybox:old", tt.local...)
+			}
+			if tt.registry != nil {
+				server.AddRegistryImage("busybox:old", tt.registry...)
+			}
+			c, err := newPodmanContainer(server.Podman(), Config{ContainerConfig: ContainerConfig{Image: "busybox:1.36", ImageDigest: pinned}})
+			assert.NoError(t, err)
+
+			err = c.ensureImage()
+			pulls, _ := server.Pulls()
+			if tt.pulled {
+				assert.Equal(t, []string{"busybox@" + pinned.String()}, pulls)
+			} else {
+				assert.Empty(t, pulls)
+			}
+			if tt.failed {
+				assert.ErrorContains(t, err, "manifest unknown")
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "busybox@"+pinned.String(), c.spec.Image)
+			assert.Equal(t, pinned.String(), c.spec.Labels[imageDigestLabel])
+			assert.Equal(t, pinned.String(), c.ImageDigest())
+		})
+	}
+}
+
+func TestDefaultPodmanSocket(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	if os.Geteuid() == 0 {
+		assert.Equal(t, "/run/podman/podman.sock", DefaultPodmanSocket())
+	} else {
+		assert.Equal(t, "/run/user/1000/podman/podman.sock", DefaultPodmanSocket())
+	}
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	assert.Equal(t, "/run/podman/podman.sock", DefaultPodmanSocket())
+}
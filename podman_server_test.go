@@ -0,0 +1,473 @@
+package dexec
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePodmanContainer struct {
+	id       string
+	spec     podmanSpec
+	started  chan struct{}
+	running  bool
+	attached bool
+	exitCode int
+	stop     chan struct{}
+	done     chan struct{}
+	gone     chan struct{}
+	archives map[string][]byte
+}
+
+// exit records the exit of the container, it must be called with the server lock held
+func (c *fakePodmanContainer) exit(code int) {
+	if !c.running {
+		return
+	}
+	c.running = false
+	c.exitCode = code
+	close(c.done)
+}
+
+// fakePodmanServer is an in-process stand-in for the libpod API of Podman, served on a unix socket. It covers
+// the container lifecycle used by the Podman backend: create, attach, start, wait, stop, remove and archive,
+// and the images endpoints: exists, json and pull. Containers run Process once they are attached to and
+// started, and its output is sent over the attach stream multiplexed like Podman does.
+type fakePodmanServer struct {
+	*httptest.Server
+	// Socket is the path of the unix socket the server listens on
+	Socket string
+	// Process is run by attached containers and returns their exit code
+	Process func(p *fakeProcess) int
+
+	mu         sync.Mutex
+	failures   map[string]int
+	containers map[string]*fakePodmanContainer
+	removed    []string
+	nextID     int
+	images     map[string][]string
+	registry   map[string][]string
+	pulls      []string
+	pullAuth   []string
+}
+
+var (
+	fakePodmanContainerPath = regexp.MustCompile(`^/v[0-9.]+/libpod/containers/([^/]+)(?:/([a-z]+))?$`)
+	fakePodmanImagePath     = regexp.MustCompile(`^/v[0-9.]+/libpod/images/(.+)/([a-z]+)$`)
+)
+
+func newFakePodmanServer(t *testing.T, process func(p *fakeProcess) int) *fakePodmanServer {
+	// t.TempDir can exceed the length limit of unix socket paths
+	dir, err := os.MkdirTemp("", "podman")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "podman.sock")
+	l, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+
+	s := &fakePodmanServer{
+		Socket:     socket,
+		Process:    process,
+		failures:   map[string]int{},
+		containers: map[string]*fakePodmanContainer{},
+		images:     map[string][]string{},
+		registry:   map[string][]string{},
+	}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+	s.Server.Listener = l
+	s.Server.Start()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Podman returns a Podman client connected to the server
+func (s *fakePodmanServer) Podman() *Podman {
+	return NewPodman(s.Socket)
+}
+
+// Fail makes the operation (create, attach, start, wait, stop, remove, archive, exists or pull) fail with the
+// HTTP status code
+func (s *fakePodmanServer) Fail(op string, code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[op] = code
+}
+
+// AddImage makes the image present locally with the repository digests
+func (s *fakePodmanServer) AddImage(image string, repoDigests ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.images[image] = repoDigests
+}
+
+// AddRegistryImage makes the image available to pull, with the repository digests
+func (s *fakePodmanServer) AddRegistryImage(image string, repoDigests ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.registry[image] = repoDigests
+}
+
+// Pulls returns the references of the pulled images and the X-Registry-Auth headers of the pulls
+func (s *fakePodmanServer) Pulls() ([]string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.pulls...), append([]string(nil), s.pullAuth...)
+}
+
+// Containers returns the IDs of the containers that have not been removed
+func (s *fakePodmanServer) Containers() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for id := range s.containers {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Removed returns the IDs of the removed containers
+func (s *fakePodmanServer) Removed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.removed...)
+}
+
+// Spec returns the spec the container was created with
+func (s *fakePodmanServer) Spec(id string) podmanSpec {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.containers[id].spec
+}
+
+// Archive returns the archive copied to path in the container
+func (s *fakePodmanServer) Archive(id, path string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.containers[id].archives[path]
+}
+
+func (s *fakePodmanServer) failure(op string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code, ok := s.failures[op]
+	return code, ok
+}
+
+func (s *fakePodmanServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if m := fakePodmanImagePath.FindStringSubmatch(r.URL.Path); m != nil {
+		s.serveImage(w, r, m[1], m[2])
+		return
+	}
+	if r.URL.Path == "/"+podmanAPIVersion+"/libpod/images/pull" && r.Method == http.MethodPost {
+		s.pull(w, r)
+		return
+	}
+	m := fakePodmanContainerPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	id, op := m[1], m[2]
+	if id == "create" && r.Method == http.MethodPost {
+		op = "create"
+	} else if op == "" && r.Method == http.MethodDelete {
+		op = "remove"
+	}
+	if code, ok := s.failure(op); ok {
+		writePodmanError(w, op+" failed", code)
+		return
+	}
+	if op == "create" {
+		s.create(w, r)
+		return
+	}
+	s.mu.Lock()
+	c, ok := s.containers[id]
+	s.mu.Unlock()
+	if !ok {
+		writePodmanError(w, "no container with name or ID "+id+" found: no such container", http.StatusNotFound)
+		return
+	}
+	switch op {
+	case "attach":
+		s.attach(w, c)
+	case "start":
+		s.start(w, c)
+	case "wait":
+		s.wait(w, r, c)
+	case "stop":
+		s.stop(w, c)
+	case "remove":
+		s.remove(w, r, c)
+	case "archive":
+		s.archive(w, r, c)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// writePodmanError writes an error response like the libpod API does
+func writePodmanError(w http.ResponseWriter, message string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"cause": message, "message": message, "response": code})
+}
+
+func (s *fakePodmanServer) serveImage(w http.ResponseWriter, r *http.Request, image, op string) {
+	if code, ok := s.failure(op); ok {
+		writePodmanError(w, op+" failed", code)
+		return
+	}
+	s.mu.Lock()
+	repoDigests, ok := lookupPodmanImage(s.images, image)
+	s.mu.Unlock()
+	if !ok {
+		writePodmanError(w, image+": image not known", http.StatusNotFound)
+		return
+	}
+	switch op {
+	case "exists":
+		w.WriteHeader(http.StatusNoContent)
+	case "json":
+		json.NewEncoder(w).Encode(map[string]interface{}{"RepoDigests": repoDigests})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// lookupPodmanImage returns the repository digests of the image with the reference, which is either the reference
+// the image was added with or one of its repository digests
+func lookupPodmanImage(images map[string][]string, ref string) ([]string, bool) {
+	if repoDigests, ok := images[ref]; ok {
+		return repoDigests, true
+	}
+	for _, repoDigests := range images {
+		for _, repoDigest := range repoDigests {
+			if repoDigest == ref {
+				return repoDigests, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (s *fakePodmanServer) pull(w http.ResponseWriter, r *http.Request) {
+	if code, ok := s.failure("pull"); ok {
+		writePodmanError(w, "pull failed", code)
+		return
+	}
+	image := r.URL.Query().Get("reference")
+	s.mu.Lock()
+	s.pulls = append(s.pulls, image)
+	s.pullAuth = append(s.pullAuth, r.Header.Get("X-Registry-Auth"))
+	repoDigests, ok := lookupPodmanImage(s.registry, image)
+	if ok {
+		s.images[image] = repoDigests
+	}
+	s.mu.Unlock()
+	enc := json.NewEncoder(w)
+	enc.Encode(map[string]string{"stream": "Trying to pull " + image + "...\n"})
+	if !ok {
+		enc.Encode(map[string]string{"error": "initializing source " + image + ": manifest unknown"})
+		return
+	}
+	enc.Encode(map[string]interface{}{"images": []string{"sha256:0123"}, "id": "sha256:0123"})
+}
+
+func (s *fakePodmanServer) create(w http.ResponseWriter, r *http.Request) {
+	var spec podmanSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		writePodmanError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	for _, c := range s.containers {
+		if spec.Name != "" && c.spec.Name == spec.Name {
+			s.mu.Unlock()
+			writePodmanError(w, fmt.Sprintf("creating container storage: the container name %q is already in use by %s", spec.Name, c.id),
+				http.StatusConflict)
+			return
+		}
+	}
+	s.nextID++
+	c := &fakePodmanContainer{
+		id:       fmt.Sprintf("podman-%d", s.nextID),
+		spec:     spec,
+		started:  make(chan struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		gone:     make(chan struct{}),
+		archives: map[string][]byte{},
+	}
+	s.containers[c.id] = c
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"Id": c.id, "Warnings": []string{}})
+}
+
+// attach hijacks the connection and runs the container process over it once the container is started
+func (s *fakePodmanServer) attach(w http.ResponseWriter, c *fakePodmanContainer) {
+	s.mu.Lock()
+	if c.attached {
+		s.mu.Unlock()
+		writePodmanError(w, "container is already attached", http.StatusConflict)
+		return
+	}
+	c.attached = true
+	s.mu.Unlock()
+
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	fmt.Fprint(rw, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.multiplexed-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	rw.Flush()
+
+	select {
+	case <-c.started:
+	case <-c.gone:
+		return
+	}
+	env := make([]string, 0, len(c.spec.Env))
+	for k, v := range c.spec.Env {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	var wmu sync.Mutex
+	ec := s.Process(&fakeProcess{
+		Args:    c.spec.Entrypoint,
+		Env:     env,
+		Dir:     c.spec.WorkDir,
+		Stdin:   rw.Reader,
+		Stdout:  &stdWriter{w: conn, mu: &wmu, stream: 1},
+		Stderr:  &stdWriter{w: conn, mu: &wmu, stream: 2},
+		Stopped: c.stop,
+	})
+	s.mu.Lock()
+	select {
+	case <-c.stop:
+		ec = 137
+	default:
+	}
+	c.exit(ec)
+	s.mu.Unlock()
+}
+
+func (s *fakePodmanServer) start(w http.ResponseWriter, c *fakePodmanContainer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-c.started:
+		w.WriteHeader(http.StatusNotModified)
+		return
+	default:
+	}
+	c.running = true
+	close(c.started)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *fakePodmanServer) wait(w http.ResponseWriter, r *http.Request, c *fakePodmanContainer) {
+	select {
+	case <-c.started:
+	default:
+		writePodmanError(w, "container is not started", http.StatusConflict)
+		return
+	}
+	select {
+	case <-c.done:
+	case <-r.Context().Done():
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	json.NewEncoder(w).Encode(c.exitCode)
+}
+
+func (s *fakePodmanServer) stop(w http.ResponseWriter, c *fakePodmanContainer) {
+	if !s.kill(c) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// kill stops the container and waits for it to exit, it returns false if the container was not running
+func (s *fakePodmanServer) kill(c *fakePodmanContainer) bool {
+	s.mu.Lock()
+	if !c.running {
+		s.mu.Unlock()
+		return false
+	}
+	select {
+	case <-c.stop:
+	default:
+		close(c.stop)
+	}
+	if !c.attached {
+		c.exit(137)
+	}
+	s.mu.Unlock()
+	<-c.done
+	return true
+}
+
+func (s *fakePodmanServer) remove(w http.ResponseWriter, r *http.Request, c *fakePodmanContainer) {
+	s.mu.Lock()
+	running := c.running
+	s.mu.Unlock()
+	if running {
+		if r.URL.Query().Get("force") != "true" {
+			writePodmanError(w, "cannot remove container "+c.id+" as it is running", http.StatusConflict)
+			return
+		}
+		s.kill(c)
+	}
+	s.mu.Lock()
+	close(c.gone)
+	delete(s.containers, c.id)
+	s.removed = append(s.removed, c.id)
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode([]map[string]string{{"Id": c.id}})
+}
+
+func (s *fakePodmanServer) archive(w http.ResponseWriter, r *http.Request, c *fakePodmanContainer) {
+	path := r.URL.Query().Get("path")
+	switch r.Method {
+	case http.MethodPut:
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			writePodmanError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		c.archives[path] = b
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		s.mu.Lock()
+		b, ok := c.archives[path]
+		s.mu.Unlock()
+		if !ok {
+			writePodmanError(w, path+": no such file or directory", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Write(b)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,214 @@
+package dexec
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	refdocker "github.com/containerd/containerd/reference/docker"
+	"github.com/opencontainers/go-digest"
+)
+
+// Policy decides which configs commands may be created with. It is evaluated by Command
+// and NewCommand before the container is created, once the env files of the config are
+// loaded. NewCommand validates the config first, but Command does not, so a Policy must
+// not assume that the config is valid.
+type Policy interface {
+	// Check returns the rules of the policy violated by config
+	Check(config Config) []Violation
+}
+
+// PolicyFunc is a function used as a Policy
+type PolicyFunc func(config Config) []Violation
+
+func (f PolicyFunc) Check(config Config) []Violation {
+	return f(config)
+}
+
+// Policies returns a Policy violated by the violations of every policy
+func Policies(policies ...Policy) Policy {
+	return PolicyFunc(func(config Config) []Violation {
+		var violations []Violation
+		for _, p := range policies {
+			violations = append(violations, p.Check(config)...)
+		}
+		return violations
+	})
+}
+
+// Violation is a rule of a Policy violated by a config
+type Violation struct {
+	// Rule is the name of the rule, e.g. one of the Rule constants of PolicyRules
+	Rule string
+	// Message describes how the config violates the rule
+	Message string
+}
+
+// PolicyViolationError is returned when a config violates the Policy of the command.
+type PolicyViolationError struct {
+	Violations []Violation
+}
+
+func (e *PolicyViolationError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = fmt.Sprintf("%s: %s", v.Rule, v.Message)
+	}
+	return "dexec: policy violation: " + strings.Join(msgs, "; ")
+}
+
+// The rules of PolicyRules
+const (
+	RuleImage           = "image"
+	RuleDigest          = "digest"
+	RuleMountSource     = "mount-source"
+	RuleMountReadOnly   = "mount-read-only"
+	RuleUser            = "user"
+	RuleCapabilities    = "capabilities"
+	RuleUnconfined      = "unconfined"
+	RuleNoNewPrivileges = "no-new-privileges"
+)
+
+// PolicyRules is a Policy of common rules. Rules with zero values are not checked.
+type PolicyRules struct {
+	// AllowedImages are the registries and repositories images may come from, e.g.
+	// "docker.io/library" or "ghcr.io/acme". Images are compared in their normalized
+	// form, so busybox is docker.io/library/busybox.
+	AllowedImages []string
+	// RequireDigest requires images to be pinned to a digest, with
+	// ContainerConfig.ImageDigest or in the image reference
+	RequireDigest bool
+	// AllowedDigests are the digests images may be pinned to. Images must be pinned
+	// when it is set.
+	AllowedDigests []digest.Digest
+	// AllowedMountSources are the host directories bind mounts and Workspace.Source may
+	// come from
+	AllowedMountSources []string
+	// ReadOnlyMounts requires bind mounts to be read-only
+	ReadOnlyMounts bool
+	// UIDs is the range of user IDs commands may run as. ContainerConfig.User must be a
+	// numeric uid or uid:gid when it is set.
+	UIDs *UIDRange
+	// AllowedCapabilities are the capabilities Security.CapAdd may add. Adding any
+	// capability is a violation when it is empty and DenyPrivileged is set.
+	AllowedCapabilities []string
+	// DenyPrivileged denies adding capabilities that are not allowed and disabling
+	// seccomp or AppArmor
+	DenyPrivileged bool
+	// RequireNoNewPrivileges requires Security.NoNewPrivileges
+	RequireNoNewPrivileges bool
+}
+
+// UIDRange is an inclusive range of user IDs
+type UIDRange struct {
+	Min, Max uint32
+}
+
+func (r PolicyRules) Check(config Config) []Violation {
+	var violations []Violation
+	violate := func(rule, format string, args ...interface{}) {
+		violations = append(violations, Violation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	cc := config.ContainerConfig
+	image := cc.Image
+	if named, err := refdocker.ParseDockerRef(image); err == nil {
+		image = refdocker.TrimNamed(named).Name()
+		if digested, ok := named.(refdocker.Digested); ok && cc.ImageDigest == "" {
+			cc.ImageDigest = digested.Digest()
+		}
+	}
+	if len(r.AllowedImages) > 0 && !hasPathPrefix(image, r.AllowedImages) {
+		violate(RuleImage, "image %s is not from an allowed repository", cc.Image)
+	}
+	if (r.RequireDigest || len(r.AllowedDigests) > 0) && cc.ImageDigest == "" {
+		violate(RuleDigest, "image %s is not pinned to a digest", cc.Image)
+	} else if len(r.AllowedDigests) > 0 && !containsDigest(r.AllowedDigests, cc.ImageDigest) {
+		violate(RuleDigest, "digest %s of image %s is not allowed", cc.ImageDigest, cc.Image)
+	}
+
+	for _, m := range cc.Mounts {
+		if m.Type != "" && m.Type != "bind" {
+			continue
+		}
+		if len(r.AllowedMountSources) > 0 && !hasPathPrefix(path.Clean(m.Source), r.AllowedMountSources) {
+			violate(RuleMountSource, "source %s of mount %s is not allowed", m.Source, m.Destination)
+		}
+		if r.ReadOnlyMounts && !isReadOnly(m) {
+			violate(RuleMountReadOnly, "mount %s is not read-only", m.Destination)
+		}
+	}
+	// the source of a workspace is copied from the host like the source of a bind mount is shared
+	if w := config.Workspace; w != nil && w.Source != "" && len(r.AllowedMountSources) > 0 &&
+		!hasPathPrefix(path.Clean(w.Source), r.AllowedMountSources) {
+		violate(RuleMountSource, "source %s of workspace %s is not allowed", w.Source, w.Path)
+	}
+
+	if r.UIDs != nil {
+		uid, _, _ := strings.Cut(cc.User, ":")
+		id, err := strconv.ParseUint(uid, 10, 32)
+		switch {
+		case cc.User == "":
+			violate(RuleUser, "user is not set, so the command runs as the user of the image")
+		case err != nil:
+			violate(RuleUser, "user %q is not a numeric uid", cc.User)
+		case uint32(id) < r.UIDs.Min || uint32(id) > r.UIDs.Max:
+			violate(RuleUser, "uid %d is not in the range %d-%d", id, r.UIDs.Min, r.UIDs.Max)
+		}
+	}
+
+	security := cc.Security
+	if r.DenyPrivileged {
+		allowed := make(map[string]bool, len(r.AllowedCapabilities))
+		for _, c := range capNames(r.AllowedCapabilities) {
+			allowed[c] = true
+		}
+		for _, c := range security.CapAdd {
+			if name := capNames([]string{c}); c == "ALL" || !allowed[name[0]] {
+				violate(RuleCapabilities, "capability %s is not allowed", c)
+			}
+		}
+		if security.SeccompProfile == Unconfined {
+			violate(RuleUnconfined, "seccomp is disabled")
+		}
+		if security.AppArmorProfile == Unconfined {
+			violate(RuleUnconfined, "AppArmor is disabled")
+		}
+	}
+	if r.RequireNoNewPrivileges && !security.NoNewPrivileges {
+		violate(RuleNoNewPrivileges, "the command may gain privileges")
+	}
+	return violations
+}
+
+// hasPathPrefix reports whether p is one of prefixes or in one of them
+func hasPathPrefix(p string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if p == prefix || strings.HasPrefix(p, prefix+"/") || prefix == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func containsDigest(digests []digest.Digest, d digest.Digest) bool {
+	for _, allowed := range digests {
+		if allowed == d {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPolicy returns a *PolicyViolationError if config violates its policy
+func checkPolicy(config Config) error {
+	if config.Policy == nil {
+		return nil
+	}
+	if violations := config.Policy.Check(config); len(violations) > 0 {
+		return &PolicyViolationError{Violations: violations}
+	}
+	return nil
+}
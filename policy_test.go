@@ -0,0 +1,96 @@
+package dexec
+
+import (
+	"errors"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+const testDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+
+func TestPolicyRules_Check(t *testing.T) {
+	rules := PolicyRules{
+		AllowedImages:          []string{"docker.io/library", "ghcr.io/acme/"},
+		RequireDigest:          true,
+		AllowedMountSources:    []string{"/srv/data"},
+		ReadOnlyMounts:         true,
+		UIDs:                   &UIDRange{Min: 1000, Max: 1999},
+		AllowedCapabilities:    []string{"NET_BIND_SERVICE"},
+		DenyPrivileged:         true,
+		RequireNoNewPrivileges: true,
+	}
+	allowed := Config{ContainerConfig: ContainerConfig{
+		Image:    "busybox@" + testDigest,
+		User:     "1000:1000",
+		Mounts:   []Mount{{Type: "bind", Source: "/srv/data/in", Destination: "/in", Options: []string{"ro"}}, {Type: "tmpfs", Destination: "/tmp"}},
+		Security: Security{CapAdd: []string{"CAP_NET_BIND_SERVICE"}, NoNewPrivileges: true},
+	}}
+	assert.Empty(t, rules.Check(allowed))
+	allowed.ContainerConfig.Image = "ghcr.io/acme/tool:1.0"
+	allowed.ContainerConfig.ImageDigest = testDigest
+	assert.Empty(t, rules.Check(allowed))
+
+	denied := Config{ContainerConfig: ContainerConfig{
+		Image:    "ghcr.io/acme-evil/tool:1.0",
+		User:     "root",
+		Mounts:   []Mount{{Type: "bind", Source: "/srv/data/../../etc", Destination: "/etc/host"}},
+		Security: Security{CapAdd: []string{"SYS_ADMIN"}, SeccompProfile: Unconfined, AppArmorProfile: Unconfined},
+	}}
+	assert.Equal(t, []Violation{
+		{Rule: RuleImage, Message: "image ghcr.io/acme-evil/tool:1.0 is not from an allowed repository"},
+		{Rule: RuleDigest, Message: "image ghcr.io/acme-evil/tool:1.0 is not pinned to a digest"},
+		{Rule: RuleMountSource, Message: "source /srv/data/../../etc of mount /etc/host is not allowed"},
+		{Rule: RuleMountReadOnly, Message: "mount /etc/host is not read-only"},
+		{Rule: RuleUser, Message: `user "root" is not a numeric uid`},
+		{Rule: RuleCapabilities, Message: "capability SYS_ADMIN is not allowed"},
+		{Rule: RuleUnconfined, Message: "seccomp is disabled"},
+		{Rule: RuleUnconfined, Message: "AppArmor is disabled"},
+		{Rule: RuleNoNewPrivileges, Message: "the command may gain privileges"},
+	}, rules.Check(denied))
+
+	assert.Equal(t, []Violation{{Rule: RuleUser, Message: "uid 0 is not in the range 1000-1999"}},
+		PolicyRules{UIDs: rules.UIDs}.Check(Config{ContainerConfig: ContainerConfig{User: "0"}}))
+	assert.Equal(t, []Violation{{Rule: RuleUser, Message: "user is not set, so the command runs as the user of the image"}},
+		PolicyRules{UIDs: rules.UIDs}.Check(Config{}))
+	assert.Equal(t, []Violation{{Rule: RuleDigest, Message: "digest " + testDigest + " of image busybox is not allowed"}},
+		PolicyRules{AllowedDigests: []digest.Digest{"sha256:00"}}.Check(Config{ContainerConfig: ContainerConfig{Image: "busybox", ImageDigest: testDigest}}))
+	assert.Empty(t, PolicyRules{}.Check(denied))
+
+	workspace := Config{Workspace: &Workspace{Path: "/work", Source: "/srv/data/../secrets"}}
+	assert.Equal(t, []Violation{{Rule: RuleMountSource, Message: "source /srv/data/../secrets of workspace /work is not allowed"}},
+		PolicyRules{AllowedMountSources: rules.AllowedMountSources}.Check(workspace))
+	workspace.Workspace.Source = "/srv/data/in"
+	assert.Empty(t, PolicyRules{AllowedMountSources: rules.AllowedMountSources}.Check(workspace))
+}
+
+func TestNewCommand_Policy(t *testing.T) {
+	policy := Policies(
+		PolicyRules{AllowedImages: []string{"docker.io/library"}},
+		PolicyFunc(func(config Config) []Violation {
+			if config.TaskConfig.Executable == "rm" {
+				return []Violation{{Rule: "no-rm", Message: "rm is not allowed"}}
+			}
+			return nil
+		}),
+	)
+
+	_, err := NewCommand(&docker.Client{}, WithPolicy(policy), WithImage("busybox"), WithCommand("echo"))
+	assert.NoError(t, err)
+
+	_, err = NewCommand(&docker.Client{}, WithPolicy(policy), WithImage("quay.io/evil/busybox"), WithCommand("rm", "-rf", "/"))
+	var pve *PolicyViolationError
+	assert.True(t, errors.As(err, &pve))
+	assert.Len(t, pve.Violations, 2)
+	assert.EqualError(t, err, "dexec: policy violation: image: image quay.io/evil/busybox is not from an allowed repository; no-rm: rm is not allowed")
+
+	assert.PanicsWithError(t, err.Error(), func() {
+		Command(&docker.Client{}, Config{
+			ContainerConfig: ContainerConfig{Image: "quay.io/evil/busybox"},
+			TaskConfig:      TaskConfig{Executable: "rm"},
+			Policy:          policy,
+		})
+	})
+}
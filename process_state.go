@@ -0,0 +1,14 @@
+package dexec
+
+// ProcessState describes a command that has been started.
+type ProcessState struct {
+	// ID is the identifier of the command's container, as returned by GetPID
+	ID string
+	// ImageDigest is the digest the image was pinned or resolved to, or empty if the
+	// image was not pinned. See ImageOptions.
+	ImageDigest string
+	// Exited reports whether the command has exited
+	Exited bool
+	// ExitCode is the exit code of the command, or -1 if it has not exited
+	ExitCode int
+}
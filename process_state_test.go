@@ -0,0 +1,17 @@
+package dexec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGenericCmd_ProcessState(t *testing.T) {
+	cmd := newRootfsCmd(t)
+	assert.Nil(t, cmd.GetProcessState())
+
+	assert.NoError(t, cmd.Start())
+	assert.Equal(t, &ProcessState{ID: "rootfs", ExitCode: -1}, cmd.GetProcessState())
+
+	assert.NoError(t, cmd.Wait())
+	assert.Equal(t, &ProcessState{ID: "rootfs", Exited: true, ExitCode: 0}, cmd.GetProcessState())
+}
@@ -0,0 +1,176 @@
+package dexec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/archive"
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	refdocker "github.com/containerd/containerd/reference/docker"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// RuncBackend is the name of the runc backend. Commands are run with runc when Command
+// is given a *Runc.
+const RuncBackend = "runc"
+
+// Runc runs commands in containers created directly with the runc binary, without a
+// container daemon. Images are read from an OCI image layout and unpacked into a new
+// bundle for every command; they are never pulled, so ContainerConfig.PullPolicy and
+// RegistryAuth are ignored.
+type Runc struct {
+	// Binary is the path of the runc binary, "runc" if empty. ContainerConfig.Runtime
+	// overrides it.
+	Binary string
+	// Root is the directory runc stores the state of containers in. If empty, the
+	// default of runc is used.
+	Root string
+	// Layout is the directory of the OCI image layout images are read from. The image
+	// of a command is the manifest whose org.opencontainers.image.ref.name annotation
+	// is ContainerConfig.Image or its tag, or whose digest it is pinned to.
+	Layout string
+	// BundleDir is the directory bundles are created in, os.TempDir() if empty. A
+	// bundle is removed with its container.
+	BundleDir string
+}
+
+func init() {
+	RegisterBackend(RuncBackend, func(client interface{}, config Config) (Backend, error) {
+		r, ok := client.(*Runc)
+		if !ok {
+			return nil, ErrUnsupportedClient
+		}
+		if config.ContainerConfig.RuntimeOptions != (RuntimeOptions{}) {
+			return nil, errRuntimeOptionsUnsupported
+		}
+		if err := checkMounts(config.ContainerConfig.Mounts, mountSupport{}); err != nil {
+			return nil, err
+		}
+		return newRuncContainer(r, config), nil
+	})
+}
+
+func (r *Runc) binary() string {
+	if r.Binary == "" {
+		return "runc"
+	}
+	return r.Binary
+}
+
+// command returns the arguments of a runc command, preceded by the global options
+func (r *Runc) command(args ...string) []string {
+	if r.Root != "" {
+		args = append([]string{"--root", r.Root}, args...)
+	}
+	return args
+}
+
+// layoutStore provides the blobs of an OCI image layout
+type layoutStore string
+
+type blobReader struct {
+	*os.File
+	size int64
+}
+
+func (b *blobReader) Size() int64 {
+	return b.size
+}
+
+func (s layoutStore) ReaderAt(_ context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	if err := desc.Digest.Validate(); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(string(s), "blobs", desc.Digest.Algorithm().String(), desc.Digest.Encoded()))
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &blobReader{File: f, size: info.Size()}, nil
+}
+
+// find returns the descriptor of image in the index of the layout, which may not have the pinned digest
+func (s layoutStore) find(image string, pin digest.Digest) (ocispec.Descriptor, error) {
+	b, err := os.ReadFile(filepath.Join(string(s), "index.json"))
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("dexec: failed to read image layout: %w", err)
+	}
+	var index ocispec.Index
+	if err = json.Unmarshal(b, &index); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("dexec: failed to read image layout: %w", err)
+	}
+	names := map[string]bool{image: true}
+	if named, err := refdocker.ParseDockerRef(image); err == nil {
+		names[named.String()] = true
+		if tagged, ok := named.(refdocker.Tagged); ok {
+			names[tagged.Tag()] = true
+		}
+	}
+	// a pinned image is found by digest even if the layout does not name it
+	for _, desc := range index.Manifests {
+		if pin != "" && desc.Digest == pin {
+			return desc, nil
+		}
+	}
+	for _, desc := range index.Manifests {
+		if names[desc.Annotations[ocispec.AnnotationRefName]] {
+			return desc, nil
+		}
+	}
+	return ocispec.Descriptor{}, &ImageNotFoundError{Image: image, Err: fmt.Errorf("not in image layout %s", s)}
+}
+
+// unpack applies the layers of the image described by desc to the directory rootfs and returns the
+// configuration of the image
+func (s layoutStore) unpack(ctx context.Context, desc ocispec.Descriptor, rootfs string) (ocispec.ImageConfig, error) {
+	manifest, err := images.Manifest(ctx, s, desc, platforms.Default())
+	if err != nil {
+		return ocispec.ImageConfig{}, fmt.Errorf("dexec: failed to read image manifest: %w", err)
+	}
+	b, err := content.ReadBlob(ctx, s, manifest.Config)
+	if err != nil {
+		return ocispec.ImageConfig{}, fmt.Errorf("dexec: failed to read image config: %w", err)
+	}
+	var image ocispec.Image
+	if err = json.Unmarshal(b, &image); err != nil {
+		return ocispec.ImageConfig{}, fmt.Errorf("dexec: failed to read image config: %w", err)
+	}
+	if err = os.MkdirAll(rootfs, 0755); err != nil {
+		return ocispec.ImageConfig{}, err
+	}
+	for _, layer := range manifest.Layers {
+		if err = s.applyLayer(ctx, layer, rootfs); err != nil {
+			return ocispec.ImageConfig{}, fmt.Errorf("dexec: failed to unpack layer %s: %w", layer.Digest, err)
+		}
+	}
+	return image.Config, nil
+}
+
+func (s layoutStore) applyLayer(ctx context.Context, layer ocispec.Descriptor, rootfs string) error {
+	if !images.IsLayerType(layer.MediaType) {
+		return fmt.Errorf("unsupported media type %s", layer.MediaType)
+	}
+	ra, err := s.ReaderAt(ctx, layer)
+	if err != nil {
+		return err
+	}
+	defer ra.Close()
+	r, err := compression.DecompressStream(content.NewReader(ra))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = archive.Apply(ctx, rootfs, r)
+	return err
+}
@@ -0,0 +1,382 @@
+package dexec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/oci"
+	"github.com/containerd/continuity/fs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	// runcNamespace is the namespace of the cgroups of runc containers when Config.Namespace is empty
+	runcNamespace = "dexec"
+	// cpuPeriod is the CFS period the CPU quota of Resources.CPUs is relative to
+	cpuPeriod = 100000
+)
+
+// runcContainer is the Backend running a command in a container created with runc. The
+// bundle is created with Cmd.Start and removed before Cmd.Wait returns.
+type runcContainer struct {
+	runc      *Runc
+	config    Config
+	image     ImageOptions
+	env       []string
+	dir       string
+	digest    digest.Digest
+	id        string
+	bundle    string
+	cmd       *exec.Cmd
+	done      chan struct{}
+	err       error
+	timer     *time.Timer
+	namespace string
+}
+
+func newRuncContainer(r *Runc, config Config) *runcContainer {
+	namespace := config.Namespace
+	if namespace == "" {
+		namespace = runcNamespace
+	}
+	return &runcContainer{
+		runc:      r,
+		config:    config,
+		image:     getImageOptions(config),
+		env:       config.ContainerConfig.Env,
+		dir:       config.TaskConfig.WorkingDir,
+		namespace: namespace,
+	}
+}
+
+func (c *runcContainer) SetEnv(env []string) error {
+	env, err := mergeEnv(c.config.ContainerConfig.EnvMerge, c.env, env)
+	if err != nil {
+		return err
+	}
+	c.env = env
+	return nil
+}
+
+func (c *runcContainer) SetDir(dir string) error {
+	dir, err := mergeDir(c.config.TaskConfig.DirMerge, c.dir, dir)
+	if err != nil {
+		return err
+	}
+	c.dir = dir
+	return nil
+}
+
+// binary is the runtime running the container, ContainerConfig.Runtime or the binary of runc
+func (c *runcContainer) binary() string {
+	if runtime := c.config.ContainerConfig.Runtime; runtime != "" {
+		return runtime
+	}
+	return c.runc.binary()
+}
+
+func (c *runcContainer) rootfs() string {
+	return filepath.Join(c.bundle, "rootfs")
+}
+
+// Create unpacks the image into a new bundle and writes the runtime spec of the command into it
+func (c *runcContainer) Create(cmd []string) error {
+	ctx := namespaces.WithNamespace(context.Background(), c.namespace)
+	image, pin, err := pinImage(ctx, c.config.ContainerConfig.Image, c.image)
+	if err != nil {
+		return err
+	}
+	store := layoutStore(c.runc.Layout)
+	desc, err := store.find(image, pin)
+	if err != nil {
+		return err
+	}
+	if pin != "" && desc.Digest != pin {
+		return &DigestMismatchError{Image: image, Expected: pin, Actual: desc.Digest}
+	}
+	c.digest = pin
+
+	id := "dexec-" + newID(c.config.IDGenerator)
+	bundle := filepath.Join(c.runc.BundleDir, id)
+	if c.runc.BundleDir == "" {
+		bundle = filepath.Join(os.TempDir(), id)
+	}
+	if err = os.MkdirAll(bundle, 0700); err != nil {
+		return fmt.Errorf("dexec: failed to create bundle: %w", err)
+	}
+	c.id, c.bundle = id, bundle
+	if err = c.writeSpec(ctx, store, desc, cmd); err != nil {
+		os.RemoveAll(bundle)
+		c.id, c.bundle = "", ""
+		return err
+	}
+	return nil
+}
+
+// runcMounts converts mounts to the mounts of the spec. runc only bind mounts a mount with the bind or rbind
+// option, so it is added to the bind mounts without either, recursive like the bind mounts of the other backends.
+func runcMounts(ms []Mount) []specs.Mount {
+	mounts := convertMounts[specs.Mount](ms)
+	for i, m := range mounts {
+		if m.Type != MountBind {
+			continue
+		}
+		bind := false
+		for _, opt := range m.Options {
+			bind = bind || opt == "bind" || opt == "rbind"
+		}
+		if !bind {
+			mounts[i].Options = append([]string{"rbind"}, m.Options...)
+		}
+	}
+	return mounts
+}
+
+func (c *runcContainer) writeSpec(ctx context.Context, store layoutStore, desc ocispec.Descriptor, cmd []string) error {
+	imageConfig, err := store.unpack(ctx, desc, c.rootfs())
+	if err != nil {
+		return err
+	}
+	dir := c.dir
+	if dir == "" {
+		dir = imageConfig.WorkingDir
+	}
+	if dir == "" {
+		dir = "/"
+	}
+	opts := []oci.SpecOpts{
+		oci.WithRootFSPath(c.rootfs()),
+		oci.WithProcessArgs(cmd...),
+		oci.WithProcessCwd(dir),
+		oci.WithEnv(imageConfig.Env),
+		oci.WithEnv(c.env),
+		oci.WithMounts(runcMounts(c.config.ContainerConfig.Mounts)),
+		oci.WithAnnotations(c.config.ContainerConfig.Annotations),
+		withResources(c.config.ContainerConfig.Resources),
+	}
+	user := c.config.ContainerConfig.User
+	if user == "" {
+		user = imageConfig.User
+	}
+	if user != "" {
+		u, err := resolveUser(c.rootfs(), user)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, func(_ context.Context, _ oci.Client, _ *containers.Container, s *oci.Spec) error {
+			s.Process.User = u
+			return nil
+		})
+	}
+	opts = append(opts, c.config.ContainerConfig.Security.specOpts()...)
+	spec, err := oci.GenerateSpec(ctx, nil, &containers.Container{ID: c.id}, opts...)
+	if err != nil {
+		return fmt.Errorf("dexec: failed to generate runtime spec: %w", err)
+	}
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(c.bundle, "config.json"), b, 0600)
+}
+
+// withResources sets the limits of resources in the runtime spec
+func withResources(r Resources) oci.SpecOpts {
+	return func(_ context.Context, _ oci.Client, _ *containers.Container, s *oci.Spec) error {
+		if r == (Resources{}) {
+			return nil
+		}
+		if s.Linux == nil {
+			s.Linux = &specs.Linux{}
+		}
+		if s.Linux.Resources == nil {
+			s.Linux.Resources = &specs.LinuxResources{}
+		}
+		if r.Memory > 0 {
+			s.Linux.Resources.Memory = &specs.LinuxMemory{Limit: &r.Memory}
+		}
+		if r.CPUs > 0 {
+			quota := int64(r.CPUs * cpuPeriod)
+			period := uint64(cpuPeriod)
+			s.Linux.Resources.CPU = &specs.LinuxCPU{Quota: &quota, Period: &period}
+		}
+		if r.PidsLimit > 0 {
+			s.Linux.Resources.Pids = &specs.LinuxPids{Limit: r.PidsLimit}
+		}
+		return nil
+	}
+}
+
+// CopyIn extracts content into the root filesystem of the bundle
+func (c *runcContainer) CopyIn(dst string, content io.Reader) error {
+	if c.id == "" {
+		return errors.New("dexec: container is not created")
+	}
+	target, err := fs.RootPath(c.rootfs(), dst)
+	if err != nil {
+		return err
+	}
+	return untar(target, content)
+}
+
+// CopyOut writes a tar archive of src in the root filesystem of the bundle to w
+func (c *runcContainer) CopyOut(src string, w io.Writer) error {
+	if c.id == "" {
+		return errors.New("dexec: container is not created")
+	}
+	target, err := fs.RootPath(c.rootfs(), src)
+	if err != nil {
+		return err
+	}
+	if _, err = os.Lstat(target); err != nil {
+		return fmt.Errorf("error reading %s: %w", src, err)
+	}
+	return writeTar(w, target, path.Base(src))
+}
+
+// Start runs the container in the foreground of `runc run`, which passes the streams to the command and exits
+// with its exit code. It returns once runc has written the pid file of the started container, so that the
+// container can be killed.
+func (c *runcContainer) Start(stdin io.Reader, stdout, stderr io.Writer) error {
+	if c.id == "" {
+		return errors.New("dexec: container is not created")
+	}
+	pidFile := filepath.Join(c.bundle, "pid")
+	cmd := exec.Command(c.binary(), c.runc.command("run", "--bundle", c.bundle, "--pid-file", pidFile, c.id)...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("dexec: failed to start container: %w", err)
+	}
+	c.cmd = cmd
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		c.err = cmd.Wait()
+	}()
+
+	c.waitStarted(pidFile)
+	if timeout := c.config.TaskConfig.Timeout; timeout > 0 {
+		c.timer = time.AfterFunc(timeout, func() { c.Kill() })
+	}
+	return nil
+}
+
+// waitStarted waits until runc has written the pid file of the container or exited
+func (c *runcContainer) waitStarted(pidFile string) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if _, err := os.Stat(pidFile); err == nil {
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *runcContainer) Wait(beforeRemove func() error) (int, error) {
+	if c.cmd == nil {
+		return -1, errors.New("dexec: container is not started")
+	}
+	defer c.remove()
+	<-c.done
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	var ee *exec.ExitError
+	if c.err != nil && !errors.As(c.err, &ee) {
+		return -1, fmt.Errorf("dexec: cannot wait for container: %w", c.err)
+	}
+	ec := exitCode(c.cmd.ProcessState)
+	if beforeRemove != nil {
+		if err := beforeRemove(); err != nil {
+			return ec, err
+		}
+	}
+	if err := c.remove(); err != nil {
+		return -1, fmt.Errorf("dexec: error deleting container: %w", err)
+	}
+	return ec, nil
+}
+
+// runcCommand runs a runc command and reports whether it failed because the container does not exist
+func (c *runcContainer) runcCommand(args ...string) (notExist bool, err error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(c.binary(), c.runc.command(args...)...)
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		return strings.Contains(msg, "does not exist"), fmt.Errorf("runc %s: %w: %s", args[0], err, msg)
+	}
+	return false, nil
+}
+
+// remove deletes the container, which `runc run` usually did already, and its bundle
+func (c *runcContainer) remove() error {
+	if c.bundle == "" {
+		return nil
+	}
+	if notExist, err := c.runcCommand("delete", "--force", c.id); err != nil && !notExist {
+		return err
+	}
+	if err := os.RemoveAll(c.bundle); err != nil {
+		return err
+	}
+	c.bundle = ""
+	return nil
+}
+
+func (c *runcContainer) ID() string {
+	return c.id
+}
+
+func (c *runcContainer) ImageDigest() string {
+	return c.digest.String()
+}
+
+func (c *runcContainer) Kill() error {
+	if c.cmd == nil {
+		return nil
+	}
+	notExist, err := c.runcCommand("kill", c.id, "KILL")
+	// if container doesn't exist or already is killed
+	// do not return an error
+	if err == nil || notExist {
+		return nil
+	}
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+	return fmt.Errorf("error stopping container: %w", err)
+}
+
+func (c *runcContainer) Cleanup() error {
+	if err := c.Kill(); err != nil {
+		return err
+	}
+	if err := c.remove(); err != nil {
+		return fmt.Errorf("error removing container: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,398 @@
+package dexec
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRuncEnv makes the test binary behave as runc, see fakeRunc
+const fakeRuncEnv = "DEXEC_FAKE_RUNC"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeRuncEnv) == "1" {
+		os.Exit(fakeRunc(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+// fakeRunc implements the runc commands used by the runc backend by running the process of the bundle on the
+// host, in the root filesystem of the bundle if it has the working directory
+func fakeRunc(args []string) int {
+	root := os.TempDir()
+	if len(args) > 2 && args[0] == "--root" {
+		root, args = args[1], args[2:]
+	}
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: runc [--root dir] command [options] id")
+		return 2
+	}
+	command, args := args[0], args[1:]
+	flags := map[string]string{}
+	for len(args) > 1 && strings.HasPrefix(args[0], "--") {
+		if args[0] == "--force" {
+			flags["--force"], args = "true", args[1:]
+			continue
+		}
+		flags[args[0]], args = args[1], args[2:]
+	}
+	id := args[0]
+	state := filepath.Join(root, id)
+	pid := func() (*os.Process, bool) {
+		b, err := os.ReadFile(state)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "container %s does not exist\n", id)
+			return nil, false
+		}
+		n, _ := strconv.Atoi(string(b))
+		p, err := os.FindProcess(n)
+		return p, err == nil
+	}
+
+	switch command {
+	case "run":
+		b, err := os.ReadFile(filepath.Join(flags["--bundle"], "config.json"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		var spec specs.Spec
+		if err = json.Unmarshal(b, &spec); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		// bind mounts are emulated by links in the root filesystem, and fail without the bind or rbind option
+		// like they do with runc
+		for _, m := range spec.Mounts {
+			if m.Type != "bind" {
+				continue
+			}
+			bind := false
+			for _, opt := range m.Options {
+				bind = bind || opt == "bind" || opt == "rbind"
+			}
+			target := filepath.Join(spec.Root.Path, m.Destination)
+			if !bind {
+				fmt.Fprintf(os.Stderr, "mount %s: not a bind mount\n", m.Destination)
+				return 1
+			}
+			if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+				err = os.Symlink(m.Source, target)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+		cmd := exec.Command(spec.Process.Args[0], spec.Process.Args[1:]...)
+		cmd.Env = spec.Process.Env
+		if dir := filepath.Join(spec.Root.Path, spec.Process.Cwd); dirExists(dir) {
+			cmd.Dir = dir
+		}
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err = cmd.Start(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		os.WriteFile(state, []byte(strconv.Itoa(cmd.Process.Pid)), 0600)
+		os.WriteFile(flags["--pid-file"], []byte(strconv.Itoa(cmd.Process.Pid)), 0600)
+		cmd.Wait()
+		os.Remove(state)
+		return exitCode(cmd.ProcessState)
+	case "kill":
+		p, ok := pid()
+		if !ok {
+			return 1
+		}
+		p.Kill()
+	case "delete":
+		p, ok := pid()
+		if !ok {
+			return 1
+		}
+		if flags["--force"] == "true" {
+			p.Kill()
+		}
+		os.Remove(state)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", command)
+		return 2
+	}
+	return 0
+}
+
+func dirExists(dir string) bool {
+	info, err := os.Stat(dir)
+	return err == nil && info.IsDir()
+}
+
+// newFakeRunc returns a Runc running fakeRunc, with a layout containing the image busybox:1.36 built from the
+// files
+func newFakeRunc(t *testing.T, config ocispec.ImageConfig, files map[string]string) *Runc {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	exe, err := os.Executable()
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "runc")
+	script := fmt.Sprintf("#!/bin/sh\n%s=1 exec '%s' \"$@\"\n", fakeRuncEnv, exe)
+	assert.NoError(t, os.WriteFile(binary, []byte(script), 0755))
+	for _, d := range []string{"root", "bundles"} {
+		assert.NoError(t, os.Mkdir(filepath.Join(dir, d), 0755))
+	}
+	layout := filepath.Join(dir, "layout")
+	writeLayout(t, layout, "1.36", config, files)
+	return &Runc{Binary: binary, Root: filepath.Join(dir, "root"), Layout: layout, BundleDir: filepath.Join(dir, "bundles")}
+}
+
+// writeLayout writes an OCI image layout with a single image of one layer, and returns the digest of its manifest
+func writeLayout(t *testing.T, dir, ref string, config ocispec.ImageConfig, files map[string]string) digest.Digest {
+	writeBlob := func(mediaType string, b []byte) ocispec.Descriptor {
+		d := digest.FromBytes(b)
+		blobs := filepath.Join(dir, "blobs", d.Algorithm().String())
+		assert.NoError(t, os.MkdirAll(blobs, 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(blobs, d.Encoded()), b, 0644))
+		return ocispec.Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(b))}
+	}
+	writeJSON := func(mediaType string, v interface{}) ocispec.Descriptor {
+		b, err := json.Marshal(v)
+		assert.NoError(t, err)
+		return writeBlob(mediaType, b)
+	}
+
+	var layer bytes.Buffer
+	gz := gzip.NewWriter(&layer)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg,
+			Uid: os.Getuid(), Gid: os.Getgid(), ModTime: time.Now()}
+		assert.NoError(t, tw.WriteHeader(hdr))
+		_, err := tw.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+
+	configDesc := writeJSON(ocispec.MediaTypeImageConfig, ocispec.Image{
+		Architecture: runtime.GOARCH,
+		OS:           runtime.GOOS,
+		Config:       config,
+		RootFS:       ocispec.RootFS{Type: "layers"},
+	})
+	layerDesc := writeBlob(ocispec.MediaTypeImageLayerGzip, layer.Bytes())
+	manifest := ocispec.Manifest{Config: configDesc, Layers: []ocispec.Descriptor{layerDesc}}
+	manifest.SchemaVersion = 2
+	manifestDesc := writeJSON(ocispec.MediaTypeImageManifest, manifest)
+	manifestDesc.Annotations = map[string]string{ocispec.AnnotationRefName: ref}
+	index := ocispec.Index{Manifests: []ocispec.Descriptor{manifestDesc}}
+	index.SchemaVersion = 2
+	b, err := json.Marshal(index)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "index.json"), b, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644))
+	return manifestDesc.Digest
+}
+
+func newRuncCmd(r *Runc, config Config, script string) *BackendCmd {
+	config.ContainerConfig.Image = "busybox:1.36"
+	config.TaskConfig.Executable = "sh"
+	config.TaskConfig.Args = []string{"-c", script}
+	return Command(r, config).(*BackendCmd)
+}
+
+func Test_runcContainer_Create(t *testing.T) {
+	r := newFakeRunc(t, ocispec.ImageConfig{Env: []string{"PATH=/bin", "IMAGE=1"}, WorkingDir: "/app"}, map[string]string{
+		"etc/passwd":  "root:x:0:0:root:/root:/bin/sh\napp:x:1000:1001::/home/app:/bin/sh\n",
+		"app/run.sh":  "echo hi",
+		"etc/group":   "root:x:0:\napp:x:1001:\n",
+		"bin/.keep":   "",
+		"app/data.md": "data",
+	})
+	c := newRuncContainer(r, Config{
+		ContainerConfig: ContainerConfig{
+			Image:     "busybox:1.36",
+			User:      "app",
+			Env:       []string{"A=1", "IMAGE=2"},
+			Mounts:    []Mount{{Type: "bind", Source: "/src", Destination: "/dst", Options: []string{"rbind", "ro"}}},
+			Resources: Resources{Memory: 1 << 20, CPUs: 1.5, PidsLimit: 64},
+		},
+	})
+
+	assert.NoError(t, c.Create([]string{"sh", "run.sh"}))
+	assert.True(t, strings.HasPrefix(c.ID(), "dexec-"))
+	assert.Equal(t, filepath.Join(r.BundleDir, c.ID()), c.bundle)
+	b, err := os.ReadFile(filepath.Join(c.bundle, "rootfs", "app", "data.md"))
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(b))
+
+	var spec specs.Spec
+	b, err = os.ReadFile(filepath.Join(c.bundle, "config.json"))
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(b, &spec))
+	assert.Equal(t, filepath.Join(c.bundle, "rootfs"), spec.Root.Path)
+	assert.Equal(t, []string{"sh", "run.sh"}, spec.Process.Args)
+	assert.Equal(t, "/app", spec.Process.Cwd)
+	assert.Equal(t, []string{"PATH=/bin", "IMAGE=2", "A=1"}, spec.Process.Env)
+	assert.Equal(t, uint32(1000), spec.Process.User.UID)
+	assert.Equal(t, uint32(1001), spec.Process.User.GID)
+	assert.Contains(t, spec.Mounts, specs.Mount{Type: "bind", Source: "/src", Destination: "/dst", Options: []string{"rbind", "ro"}})
+	assert.Equal(t, int64(1<<20), *spec.Linux.Resources.Memory.Limit)
+	assert.Equal(t, int64(150000), *spec.Linux.Resources.CPU.Quota)
+	assert.Equal(t, uint64(100000), *spec.Linux.Resources.CPU.Period)
+	assert.Equal(t, int64(64), spec.Linux.Resources.Pids.Limit)
+
+	assert.NoError(t, c.Cleanup())
+	assert.NoDirExists(t, c.bundle)
+}
+
+func Test_runcContainer_Create_Image(t *testing.T) {
+	r := newFakeRunc(t, ocispec.ImageConfig{}, map[string]string{"bin/.keep": ""})
+	b, err := os.ReadFile(filepath.Join(r.Layout, "index.json"))
+	assert.NoError(t, err)
+	var index ocispec.Index
+	assert.NoError(t, json.Unmarshal(b, &index))
+	manifest := index.Manifests[0].Digest
+
+	tests := []struct {
+		name     string
+		image    string
+		pin      digest.Digest
+		notFound bool
+		mismatch bool
+	}{
+		{name: "tag", image: "busybox:1.36"},
+		{name: "ref name", image: "1.36"},
+		{name: "by digest", image: "busybox@" + manifest.String()},
+		{name: "pinned", image: "busybox:1.36", pin: manifest},
+		{name: "pin mismatch", image: "busybox:1.36", pin: digest.FromString("other"), mismatch: true},
+		{name: "missing", image: "busybox:1.35", notFound: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newRuncContainer(r, Config{ContainerConfig: ContainerConfig{Image: tt.image, ImageDigest: tt.pin}})
+			err := c.Create([]string{"true"})
+			var infe *ImageNotFoundError
+			var dme *DigestMismatchError
+			assert.Equal(t, tt.notFound, errors.As(err, &infe))
+			assert.Equal(t, tt.mismatch, errors.As(err, &dme))
+			if tt.notFound || tt.mismatch {
+				assert.Empty(t, c.ID())
+				return
+			}
+			assert.NoError(t, err)
+			assert.NoError(t, c.Cleanup())
+		})
+	}
+}
+
+func TestCommand_Runc(t *testing.T) {
+	r := newFakeRunc(t, ocispec.ImageConfig{}, map[string]string{"data/in.txt": "hello"})
+	var artifacts bytes.Buffer
+	cmd := newRuncCmd(r, Config{
+		ContainerConfig: ContainerConfig{Env: []string{"A=1"}},
+		TaskConfig:      TaskConfig{WorkingDir: "/data"},
+		Artifacts:       Artifacts{Paths: []string{"/data/out.txt"}, Output: &artifacts},
+	}, `printf '%s:' "$A"; cat in.txt copied.txt; cat >&2; cat in.txt copied.txt > out.txt; exit 3`)
+	assert.Equal(t, RuncBackend, cmd.client.Name)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader("input")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	assert.NoError(t, cmd.CopyIn("/data", tarOf(t, "copied.txt", " world")))
+
+	var ee *ExitError
+	assert.True(t, errors.As(cmd.Run(), &ee))
+	assert.Equal(t, 3, ee.ExitCode)
+	assert.Equal(t, "1:hello world", stdout.String())
+	assert.Equal(t, "input", stderr.String())
+
+	tr := tar.NewReader(&artifacts)
+	hdr, err := tr.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "out.txt", hdr.Name)
+
+	entries, err := os.ReadDir(r.BundleDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func Test_runcContainer_Kill(t *testing.T) {
+	r := newFakeRunc(t, ocispec.ImageConfig{}, map[string]string{"bin/.keep": ""})
+	cmd := newRuncCmd(r, Config{}, "exec sleep 3600")
+
+	assert.NoError(t, cmd.Start())
+	assert.NoError(t, cmd.Kill())
+	var ee *ExitError
+	assert.True(t, errors.As(cmd.Wait(), &ee))
+	assert.Equal(t, 137, ee.ExitCode)
+
+	// the container is gone, so there is nothing to kill or clean up
+	assert.NoError(t, cmd.Kill())
+	assert.NoError(t, cmd.Cleanup())
+}
+
+func Test_runcContainer_Timeout(t *testing.T) {
+	r := newFakeRunc(t, ocispec.ImageConfig{}, map[string]string{"bin/.keep": ""})
+	cmd := newRuncCmd(r, Config{TaskConfig: TaskConfig{Timeout: 100 * time.Millisecond}}, "exec sleep 3600")
+
+	var ee *ExitError
+	assert.True(t, errors.As(cmd.Run(), &ee))
+	assert.Equal(t, 137, ee.ExitCode)
+}
+
+func Test_runcMounts(t *testing.T) {
+	mounts := runcMounts([]Mount{
+		{Source: "/a", Destination: "/a"},
+		{Source: "/b", Destination: "/b", ReadOnly: true},
+		{Source: "/c", Destination: "/c", Options: []string{"bind"}},
+		{Type: MountTmpfs, Destination: "/tmp"},
+	})
+	assert.Equal(t, []specs.Mount{
+		{Type: "bind", Source: "/a", Destination: "/a", Options: []string{"rbind"}},
+		{Type: "bind", Source: "/b", Destination: "/b", Options: []string{"rbind", "ro"}},
+		{Type: "bind", Source: "/c", Destination: "/c", Options: []string{"bind"}},
+		{Type: "tmpfs", Source: "tmpfs", Destination: "/tmp"},
+	}, mounts)
+}
+
+func TestCommand_Runc_Mounts(t *testing.T) {
+	r := newFakeRunc(t, ocispec.ImageConfig{}, map[string]string{"bin/.keep": ""})
+	src := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "in.txt"), []byte("hello"), 0644))
+	cmd := newRuncCmd(r, Config{ContainerConfig: ContainerConfig{Mounts: []Mount{{Source: src, Destination: "/data"}}}},
+		"cat data/in.txt")
+	out, err := cmd.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(out))
+}
+
+func Test_runcContainer_Cleanup(t *testing.T) {
+	r := newFakeRunc(t, ocispec.ImageConfig{}, map[string]string{"bin/.keep": ""})
+	cmd := newRuncCmd(r, Config{}, "exec sleep 3600")
+
+	assert.NoError(t, cmd.Start())
+	assert.NoError(t, cmd.Cleanup())
+	entries, err := os.ReadDir(r.BundleDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+	entries, err = os.ReadDir(r.Root)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,28 @@
+package dexec
+
+import (
+	"errors"
+	"strings"
+)
+
+// RuntimeOptions are options of the OCI runtime of a container that are specific to the
+// runtime. They are applied by the containerd backend only; with Docker, runtimes are
+// configured in the daemon and creating a command with RuntimeOptions fails.
+type RuntimeOptions struct {
+	// ConfigPath is the configuration file of a runtime shim other than runc, e.g. the
+	// runsc.toml of gVisor or the configuration.toml of Kata Containers
+	ConfigPath string
+	// SystemdCgroup makes the runc shim use the systemd cgroup driver
+	SystemdCgroup bool
+}
+
+// errRuntimeUnsupported is returned by backends that run commands without an OCI runtime
+var errRuntimeUnsupported = errors.New("dexec: ContainerConfig.Runtime is not supported by this backend")
+
+// errRuntimeOptionsUnsupported is returned by backends that cannot apply RuntimeOptions
+var errRuntimeOptionsUnsupported = errors.New("dexec: ContainerConfig.RuntimeOptions are not supported by this backend")
+
+// isRuncShim reports whether the containerd runtime name is the runc shim
+func isRuncShim(name string) bool {
+	return strings.HasPrefix(name, "io.containerd.runc.")
+}
@@ -0,0 +1,146 @@
+package dexec
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/containers"
+	runtimeoptions "github.com/containerd/containerd/pkg/runtimeoptions/v1"
+	runcopts "github.com/containerd/containerd/runtime/v2/runc/options"
+	"github.com/containerd/typeurl"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRuntime_Docker(t *testing.T) {
+	e, err := getDockerExecution(Config{ContainerConfig: ContainerConfig{Image: "busybox", Runtime: "runsc"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "runsc", e.(*createContainer).opt.HostConfig.Runtime)
+
+	_, err = getDockerExecution(Config{ContainerConfig: ContainerConfig{Image: "busybox", RuntimeOptions: RuntimeOptions{SystemdCgroup: true}}})
+	assert.ErrorIs(t, err, errRuntimeOptionsUnsupported)
+}
+
+func Test_createTask_buildCreateContainerArgs_Runtime(t *testing.T) {
+	task := &createTask{opts: CreateTaskOptions{Image: "busybox", Runtime: "io.containerd.kata.v2"}}
+	args := task.buildCreateContainerArgs(Containerd{Namespace: "unit-test"})
+	assert.Equal(t, []string{"--runtime", "io.containerd.kata.v2", "busybox"}, args[len(args)-3:])
+
+	task.opts.Runtime = ""
+	assert.NotContains(t, task.buildCreateContainerArgs(Containerd{Namespace: "unit-test"}), "--runtime")
+}
+
+func Test_createTask_setRuntimeOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		runtime string
+		options interface{}
+		opts    RuntimeOptions
+		want    interface{}
+		wantErr string
+	}{
+		{
+			name:    "runc keeps the options of nerdctl",
+			runtime: "io.containerd.runc.v2",
+			options: &runcopts.Options{BinaryName: "crun"},
+			opts:    RuntimeOptions{SystemdCgroup: true},
+			want:    &runcopts.Options{BinaryName: "crun", SystemdCgroup: true},
+		},
+		{
+			name:    "runc without options",
+			runtime: "io.containerd.runc.v2",
+			opts:    RuntimeOptions{SystemdCgroup: true},
+			want:    &runcopts.Options{SystemdCgroup: true},
+		},
+		{
+			name:    "other shim",
+			runtime: "io.containerd.runsc.v1",
+			opts:    RuntimeOptions{ConfigPath: "/etc/containerd/runsc.toml"},
+			want:    &runtimeoptions.Options{ConfigPath: "/etc/containerd/runsc.toml"},
+		},
+		{
+			name:    "config path with runc",
+			runtime: "io.containerd.runc.v2",
+			opts:    RuntimeOptions{ConfigPath: "/etc/containerd/runc.toml"},
+			wantErr: "dexec: RuntimeOptions.ConfigPath is not supported by io.containerd.runc.v2",
+		},
+		{
+			name:    "systemd cgroup with another shim",
+			runtime: "io.containerd.kata.v2",
+			opts:    RuntimeOptions{SystemdCgroup: true},
+			wantErr: "dexec: RuntimeOptions.SystemdCgroup is not supported by io.containerd.kata.v2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := containers.Container{Runtime: containers.RuntimeInfo{Name: tt.runtime}}
+			if tt.options != nil {
+				v, err := typeurl.MarshalAny(tt.options)
+				assert.NoError(t, err)
+				info.Runtime.Options = v
+			}
+			var updated containers.Container
+			mockContainer := new(container)
+			mockContainer.On("Info", mock.Anything).Return(info, nil)
+			if tt.wantErr == "" {
+				mockContainer.On("Update", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+					for _, opt := range args.Get(1).([]containerd.UpdateContainerOpts) {
+						assert.NoError(t, opt(context.Background(), nil, &updated))
+					}
+				})
+			}
+			ct := &createTask{opts: CreateTaskOptions{RuntimeOptions: tt.opts}}
+
+			err := ct.setRuntimeOptions(mockContainer)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.runtime, updated.Runtime.Name)
+			v, err := typeurl.UnmarshalAny(updated.Runtime.Options)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, v)
+			mockContainer.AssertExpectations(t)
+		})
+	}
+
+	// the container is not updated without options
+	assert.NoError(t, (&createTask{}).setRuntimeOptions(new(container)))
+}
+
+func TestRuntime_Podman(t *testing.T) {
+	server := newFakePodmanServer(t, echoProcess)
+	cmd := newFakePodmanCmd(t, server, Config{ContainerConfig: ContainerConfig{Runtime: "crun"}}, "echo")
+
+	assert.NoError(t, cmd.Start())
+	spec := server.Spec(cmd.GetPID())
+	assert.NoError(t, cmd.Wait())
+	assert.Equal(t, "crun", spec.OCIRuntime)
+
+	_, err := newPodmanContainer(&Podman{}, Config{ContainerConfig: ContainerConfig{RuntimeOptions: RuntimeOptions{ConfigPath: "/etc/crun.toml"}}})
+	assert.ErrorIs(t, err, errRuntimeOptionsUnsupported)
+}
+
+func TestRuntime_Runc(t *testing.T) {
+	r := newFakeRunc(t, ocispec.ImageConfig{}, nil)
+	binary := r.Binary
+	r.Binary = "/nonexistent/runc"
+	cmd := newRuncCmd(r, Config{ContainerConfig: ContainerConfig{Runtime: binary}}, "printf hello")
+
+	out, err := cmd.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(out))
+
+	_, err = NewCommand(r, WithImage("busybox:1.36"), WithCommand("true"), func(c *Config) {
+		c.ContainerConfig.RuntimeOptions = RuntimeOptions{SystemdCgroup: true}
+	})
+	assert.ErrorIs(t, err, errRuntimeOptionsUnsupported)
+}
+
+func TestRuntime_Local(t *testing.T) {
+	_, err := NewCommand(&Local{}, WithCommand("true"), func(c *Config) { c.ContainerConfig.Runtime = "runsc" })
+	assert.ErrorIs(t, err, errRuntimeUnsupported)
+}
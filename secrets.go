@@ -0,0 +1,230 @@
+package dexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Secrets are secret values passed to the command as files instead of environment
+// variables, so that they do not show in the arguments of nerdctl or in `docker inspect`.
+// The files are written to a tmpfs on the host when the command starts and bind mounted
+// read-only into the container; they are wiped when Cmd.Wait returns and by Cmd.Cleanup.
+// The values are never logged nor put in labels. Since the files must be on the host of
+// the container, commands with secrets fail to be created with a remote Docker daemon.
+type Secrets struct {
+	// Files are the secrets and the paths of their files in the container
+	Files []Secret
+	// Source provides the values of the secrets
+	Source SecretSource
+	// Dir is the host directory the files are written to. It should be on a tmpfs so
+	// that secrets are never written to disk. If empty, /dev/shm is used when it exists
+	// and os.TempDir() otherwise.
+	Dir string
+}
+
+// Secret is a secret value materialized as a file in the container
+type Secret struct {
+	// Name is the name of the secret in the SecretSource
+	Name string
+	// Path is the absolute path of the file in the container
+	Path string
+	// Mode is the mode of the file, 0400 if zero
+	Mode os.FileMode
+	// UID and GID own the file if either is not zero, so that a command run by another
+	// user than the one running dexec can read it
+	UID, GID int
+}
+
+// SecretSource provides the values of secrets, e.g. from a vault
+type SecretSource interface {
+	// Secret returns the value of the secret name
+	Secret(ctx context.Context, name string) ([]byte, error)
+}
+
+// SecretSourceFunc is a function used as a SecretSource
+type SecretSourceFunc func(ctx context.Context, name string) ([]byte, error)
+
+func (f SecretSourceFunc) Secret(ctx context.Context, name string) ([]byte, error) {
+	return f(ctx, name)
+}
+
+// StaticSecrets is a SecretSource of fixed values by name
+type StaticSecrets map[string][]byte
+
+func (s StaticSecrets) Secret(_ context.Context, name string) ([]byte, error) {
+	value, ok := s[name]
+	if !ok {
+		return nil, ErrSecretNotFound
+	}
+	return value, nil
+}
+
+// EnvSecrets is a SecretSource reading secrets from the environment variables of the
+// host, named Prefix followed by the name of the secret
+type EnvSecrets struct {
+	Prefix string
+}
+
+func (s EnvSecrets) Secret(_ context.Context, name string) ([]byte, error) {
+	value, ok := os.LookupEnv(s.Prefix + name)
+	if !ok {
+		return nil, ErrSecretNotFound
+	}
+	return []byte(value), nil
+}
+
+// ErrSecretNotFound is returned by a SecretSource that does not have a secret
+var ErrSecretNotFound = errors.New("secret not found")
+
+var errRemoteDockerSecrets = errors.New("dexec: Secrets are not supported with a remote Docker daemon")
+
+// isLocalEndpoint returns whether a Docker endpoint is a socket or a pipe of the host or
+// a TCP address of the loopback interface, where the files of the secrets can be mounted
+// from
+func isLocalEndpoint(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "unix", "npipe":
+		return true
+	case "tcp", "http", "https":
+		host := u.Hostname()
+		if host == "localhost" {
+			return true
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	}
+	return false
+}
+
+// secretFiles are the files of the secrets of a command on the host
+type secretFiles struct {
+	secrets Secrets
+	dir     string
+	mu      sync.Mutex
+	written bool
+}
+
+// newSecretFiles returns the files of secrets and the mounts of the files in the container.
+// The files are not written until write is called.
+func newSecretFiles(secrets Secrets) (*secretFiles, []Mount) {
+	if len(secrets.Files) == 0 {
+		return nil, nil
+	}
+	base := secrets.Dir
+	if base == "" {
+		base = os.TempDir()
+		if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+			base = "/dev/shm"
+		}
+	}
+	s := &secretFiles{secrets: secrets, dir: filepath.Join(base, "dexec-secrets-"+RandomString(2*randomSuffixLength))}
+	mounts := make([]Mount, len(secrets.Files))
+	for i, secret := range secrets.Files {
+		mounts[i] = Mount{Type: "bind", Source: s.file(i), Destination: secret.Path, Options: []string{"ro"}}
+	}
+	return s, mounts
+}
+
+// file is the path of the file of the i-th secret on the host
+func (s *secretFiles) file(i int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("secret-%d", i))
+}
+
+// write gets the values of the secrets from their source and writes them to their files
+func (s *secretFiles) write(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.secrets.Source == nil {
+		return errors.New("dexec: Secrets.Source is not set")
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return fmt.Errorf("dexec: failed to create secrets directory: %w", err)
+	}
+	s.written = true
+	for i, secret := range s.secrets.Files {
+		value, err := s.secrets.Source.Secret(ctx, secret.Name)
+		if err != nil {
+			return fmt.Errorf("dexec: failed to get secret %s: %w", secret.Name, err)
+		}
+		if err = writeSecret(s.file(i), secret, value); err != nil {
+			return fmt.Errorf("dexec: failed to write secret %s: %w", secret.Name, err)
+		}
+	}
+	return nil
+}
+
+func writeSecret(name string, secret Secret, value []byte) error {
+	mode := secret.Mode
+	if mode == 0 {
+		mode = 0400
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(value)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if secret.UID != 0 || secret.GID != 0 {
+		return os.Chown(name, secret.UID, secret.GID)
+	}
+	return nil
+}
+
+// wipe overwrites the files of the secrets with zeros and removes them
+func (s *secretFiles) wipe() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.written {
+		return nil
+	}
+	for i := range s.secrets.Files {
+		if err := zeroFile(s.file(i)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("dexec: failed to wipe secret: %w", err)
+		}
+	}
+	if err := os.RemoveAll(s.dir); err != nil {
+		return fmt.Errorf("dexec: failed to wipe secrets: %w", err)
+	}
+	s.written = false
+	return nil
+}
+
+func zeroFile(name string) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(name, 0600); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(make([]byte, info.Size()))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
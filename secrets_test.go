@@ -0,0 +1,153 @@
+package dexec
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_secretFiles(t *testing.T) {
+	dir := t.TempDir()
+	s, mounts := newSecretFiles(Secrets{
+		Files: []Secret{
+			{Name: "token", Path: "/run/secrets/token"},
+			{Name: "key", Path: "/etc/app/key", Mode: 0440},
+		},
+		Source: StaticSecrets{"token": []byte("t0ken"), "key": []byte("k3y")},
+		Dir:    dir,
+	})
+	assert.Equal(t, []Mount{
+		{Type: "bind", Source: s.file(0), Destination: "/run/secrets/token", Options: []string{"ro"}},
+		{Type: "bind", Source: s.file(1), Destination: "/etc/app/key", Options: []string{"ro"}},
+	}, mounts)
+	assert.Equal(t, dir, filepath.Dir(s.dir))
+
+	assert.NoError(t, s.write(context.Background()))
+	b, err := os.ReadFile(s.file(0))
+	assert.NoError(t, err)
+	assert.Equal(t, "t0ken", string(b))
+	info, err := os.Stat(s.file(1))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0440), info.Mode().Perm())
+	info, err = os.Stat(s.file(0))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0400), info.Mode().Perm())
+
+	assert.NoError(t, s.wipe())
+	_, err = os.Stat(s.dir)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	assert.NoError(t, s.wipe())
+}
+
+func Test_secretFiles_NotFound(t *testing.T) {
+	s, _ := newSecretFiles(Secrets{
+		Files:  []Secret{{Name: "missing", Path: "/run/secrets/missing"}},
+		Source: EnvSecrets{Prefix: "DEXEC_TEST_SECRET_"},
+		Dir:    t.TempDir(),
+	})
+	assert.EqualError(t, s.write(context.Background()), "dexec: failed to get secret missing: secret not found")
+	assert.NoError(t, s.wipe())
+
+	t.Setenv("DEXEC_TEST_SECRET_missing", "found")
+	assert.NoError(t, s.write(context.Background()))
+	b, err := os.ReadFile(s.file(0))
+	assert.NoError(t, err)
+	assert.Equal(t, "found", string(b))
+	assert.NoError(t, s.wipe())
+}
+
+func Test_newSecretFiles_Empty(t *testing.T) {
+	s, mounts := newSecretFiles(Secrets{Source: StaticSecrets{}})
+	assert.Nil(t, s)
+	assert.Empty(t, mounts)
+	assert.NoError(t, s.write(context.Background()))
+	assert.NoError(t, s.wipe())
+}
+
+func TestCommand_Secrets(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{Secrets: Secrets{
+		Files:  []Secret{{Name: "token", Path: "/run/secrets/token"}},
+		Source: StaticSecrets{"token": []byte("t0ken")},
+		Dir:    dir,
+	}}
+	cmd := newLocalCmd(t, &Local{MapMounts: true}, config, `cat "$1"`, "/run/secrets/token")
+	assert.NotContains(t, cmd.Args, "t0ken")
+
+	out, err := cmd.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "t0ken", string(out))
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// the secrets are wiped when the command fails to start
+	config.Secrets.Source = SecretSourceFunc(func(_ context.Context, name string) ([]byte, error) {
+		return []byte("t0ken"), nil
+	})
+	config.TaskConfig.Executable = "dexec-no-such-executable"
+	cmd = Command(&Local{MapMounts: true}, config).(*BackendCmd)
+	assert.ErrorContains(t, cmd.Start(), "dexec: failed to create process")
+	entries, err = os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestCommand_Secrets_Runc(t *testing.T) {
+	r := newFakeRunc(t, ocispec.ImageConfig{}, map[string]string{"bin/.keep": ""})
+	dir := t.TempDir()
+	cmd := newRuncCmd(r, Config{Secrets: Secrets{
+		Files:  []Secret{{Name: "token", Path: "/run/secrets/token"}},
+		Source: StaticSecrets{"token": []byte("t0ken")},
+		Dir:    dir,
+	}}, "cat run/secrets/token")
+
+	out, err := cmd.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "t0ken", string(out))
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func Test_isLocalEndpoint(t *testing.T) {
+	for endpoint, local := range map[string]bool{
+		"unix:///var/run/docker.sock":    true,
+		"npipe:////./pipe/docker_engine": true,
+		"tcp://127.0.0.1:2375":           true,
+		"http://localhost:2375":          true,
+		"tcp://[::1]:2375":               true,
+		"tcp://docker.example.com:2376":  false,
+		"tcp://10.0.0.2:2376":            false,
+		"ssh://docker.example.com":       false,
+	} {
+		assert.Equal(t, local, isLocalEndpoint(endpoint), endpoint)
+	}
+}
+
+func TestNewCommand_RemoteDockerSecrets(t *testing.T) {
+	config := Config{
+		ContainerConfig: ContainerConfig{Image: "busybox"},
+		TaskConfig:      TaskConfig{Executable: "cat", Args: []string{"/run/secrets/token"}},
+		Secrets: Secrets{
+			Files:  []Secret{{Name: "token", Path: "/run/secrets/token"}},
+			Source: StaticSecrets{"token": []byte("t0ken")},
+			Dir:    t.TempDir(),
+		},
+	}
+	client, err := docker.NewClient("tcp://docker.example.com:2376")
+	assert.NoError(t, err)
+	_, err = NewCommand(client, WithConfig(config))
+	assert.ErrorIs(t, err, errRemoteDockerSecrets)
+
+	// the fake server listens on the loopback interface
+	server := newFakeDockerServer(t, echoProcess)
+	_, err = NewCommand(server.Client(t).Client, WithConfig(config))
+	assert.NoError(t, err)
+}
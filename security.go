@@ -0,0 +1,203 @@
+package dexec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/oci"
+)
+
+// Unconfined disables seccomp or AppArmor when used as Security.SeccompProfile or
+// Security.AppArmorProfile
+const Unconfined = "unconfined"
+
+// Security hardens the container of a command. The zero value keeps the defaults of
+// the runtime. It is applied by the Docker, containerd, Podman and runc backends; the
+// local backend fails to create commands that set it.
+type Security struct {
+	// CapAdd and CapDrop add and drop capabilities, e.g. CAP_NET_RAW or NET_RAW. "ALL"
+	// in CapDrop drops every capability except the ones of CapAdd.
+	CapAdd  []string
+	CapDrop []string
+	// SeccompProfile is a seccomp profile in the JSON format of Docker, or Unconfined.
+	// If empty, the default profile of the runtime is used.
+	SeccompProfile string
+	// AppArmorProfile is the name of a loaded AppArmor profile, or Unconfined. If empty,
+	// the default profile of the runtime is used.
+	AppArmorProfile string
+	// NoNewPrivileges prevents the command from gaining privileges, e.g. with setuid binaries
+	NoNewPrivileges bool
+	// ReadOnlyRootfs mounts the root filesystem of the container read-only. Mounts and
+	// secrets are still writable according to their options.
+	ReadOnlyRootfs bool
+	// MaskedPaths are paths in the container made inaccessible, in addition to the paths
+	// masked by default
+	MaskedPaths []string
+}
+
+// SandboxedSecurity returns the security settings recommended for untrusted commands:
+// every capability is dropped, privileges cannot be gained and the root filesystem is
+// read-only, so the command can only write to mounts.
+func SandboxedSecurity() Security {
+	return Security{
+		CapDrop:         []string{"ALL"},
+		NoNewPrivileges: true,
+		ReadOnlyRootfs:  true,
+		MaskedPaths:     []string{"/proc/kallsyms", "/sys/kernel"},
+	}
+}
+
+// defaultMaskedPaths are the paths masked by Docker and containerd, which are replaced
+// by the masked paths of the container when they are set
+var defaultMaskedPaths = []string{
+	"/proc/acpi",
+	"/proc/asound",
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/latency_stats",
+	"/proc/timer_list",
+	"/proc/timer_stats",
+	"/proc/sched_debug",
+	"/proc/scsi",
+	"/sys/firmware",
+	"/sys/devices/virtual/powercap",
+}
+
+func (s Security) isZero() bool {
+	return len(s.CapAdd) == 0 && len(s.CapDrop) == 0 && s.SeccompProfile == "" && s.AppArmorProfile == "" &&
+		!s.NoNewPrivileges && !s.ReadOnlyRootfs && len(s.MaskedPaths) == 0
+}
+
+// maskedPaths returns the default masked paths followed by the masked paths of s, or nil
+// if s has none so that the defaults of the runtime apply
+func (s Security) maskedPaths() []string {
+	if len(s.MaskedPaths) == 0 {
+		return nil
+	}
+	return append(append([]string(nil), defaultMaskedPaths...), s.MaskedPaths...)
+}
+
+// dockerSecurityOpt returns the security options of a Docker container
+func (s Security) dockerSecurityOpt() []string {
+	var opts []string
+	if s.SeccompProfile != "" {
+		opts = append(opts, "seccomp="+s.SeccompProfile)
+	}
+	if s.AppArmorProfile != "" {
+		opts = append(opts, "apparmor="+s.AppArmorProfile)
+	}
+	if s.NoNewPrivileges {
+		opts = append(opts, "no-new-privileges:true")
+	}
+	return opts
+}
+
+// nerdctlArgs returns the flags of `nerdctl create`. seccompFile is the path of the file
+// SeccompProfile is written to, since nerdctl reads profiles from files.
+func (s Security) nerdctlArgs(seccompFile string) []string {
+	var args []string
+	for _, c := range s.CapDrop {
+		args = append(args, "--cap-drop", c)
+	}
+	for _, c := range s.CapAdd {
+		args = append(args, "--cap-add", c)
+	}
+	if s.SeccompProfile == Unconfined {
+		args = append(args, "--security-opt", "seccomp="+Unconfined)
+	} else if seccompFile != "" {
+		args = append(args, "--security-opt", "seccomp="+seccompFile)
+	}
+	if s.AppArmorProfile != "" {
+		args = append(args, "--security-opt", "apparmor="+s.AppArmorProfile)
+	}
+	if s.NoNewPrivileges {
+		args = append(args, "--security-opt", "no-new-privileges")
+	}
+	if s.ReadOnlyRootfs {
+		args = append(args, "--read-only")
+	}
+	return args
+}
+
+// writeSeccompProfile writes SeccompProfile to a temporary file for runtimes reading
+// profiles from files, and returns its path and a function removing it. The path is
+// empty if there is no profile to write.
+func (s Security) writeSeccompProfile() (string, func(), error) {
+	if s.SeccompProfile == "" || s.SeccompProfile == Unconfined {
+		return "", func() {}, nil
+	}
+	f, err := os.CreateTemp("", "dexec-seccomp-*.json")
+	if err != nil {
+		return "", nil, fmt.Errorf("dexec: failed to write seccomp profile: %w", err)
+	}
+	remove := func() { os.Remove(f.Name()) }
+	_, err = f.WriteString(s.SeccompProfile)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		remove()
+		return "", nil, fmt.Errorf("dexec: failed to write seccomp profile: %w", err)
+	}
+	return f.Name(), remove, nil
+}
+
+// specOpts returns the options applying s to an OCI runtime spec
+func (s Security) specOpts() []oci.SpecOpts {
+	var opts []oci.SpecOpts
+	for _, c := range s.CapDrop {
+		if c == "ALL" {
+			opts = append(opts, oci.WithCapabilities(nil))
+			break
+		}
+	}
+	opts = append(opts, oci.WithDroppedCapabilities(capNames(s.CapDrop)), oci.WithAddedCapabilities(capNames(s.CapAdd)))
+	if s.NoNewPrivileges {
+		opts = append(opts, oci.WithNoNewPrivileges)
+	}
+	if s.ReadOnlyRootfs {
+		opts = append(opts, oci.WithRootFSReadonly())
+	}
+	if paths := s.maskedPaths(); paths != nil {
+		opts = append(opts, oci.WithMaskedPaths(paths))
+	}
+	if s.AppArmorProfile != "" && s.AppArmorProfile != Unconfined {
+		opts = append(opts, func(_ context.Context, _ oci.Client, _ *containers.Container, spec *oci.Spec) error {
+			spec.Process.ApparmorProfile = s.AppArmorProfile
+			return nil
+		})
+	}
+	if s.SeccompProfile != "" && s.SeccompProfile != Unconfined {
+		opts = append(opts, withSeccompProfile(s.SeccompProfile))
+	}
+	return opts
+}
+
+// capNames returns the names of capabilities in the CAP_ form of the runtime spec, without "ALL"
+func capNames(caps []string) []string {
+	var names []string
+	for _, c := range caps {
+		if c == "ALL" {
+			continue
+		}
+		if len(c) < 4 || c[:4] != "CAP_" {
+			c = "CAP_" + c
+		}
+		names = append(names, c)
+	}
+	return names
+}
+
+// validate checks the fields of s, reporting invalid fields with invalid
+func (s Security) validate(invalid func(field, format string, args ...interface{})) {
+	if p := s.SeccompProfile; p != "" && p != Unconfined && !json.Valid([]byte(p)) {
+		invalid("ContainerConfig.Security.SeccompProfile", "profile is not valid JSON")
+	}
+}
+
+// errSecurityUnsupported is returned by backends that cannot apply Security
+var errSecurityUnsupported = errors.New("dexec: ContainerConfig.Security is not supported by this backend")
@@ -0,0 +1,27 @@
+package dexec
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/oci"
+	"github.com/docker/docker/profiles/seccomp"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// withSeccompProfile sets the seccomp filter of the spec from a profile in the JSON format of Docker.
+// The rules of the profile depend on the capabilities, so it must be applied after them.
+func withSeccompProfile(profile string) oci.SpecOpts {
+	return func(_ context.Context, _ oci.Client, _ *containers.Container, s *oci.Spec) error {
+		if s.Linux == nil {
+			s.Linux = &specs.Linux{}
+		}
+		filter, err := seccomp.LoadProfile(profile, s)
+		if err != nil {
+			return fmt.Errorf("dexec: invalid seccomp profile: %w", err)
+		}
+		s.Linux.Seccomp = filter
+		return nil
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package dexec
+
+import (
+	"context"
+	"errors"
+
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/oci"
+)
+
+// withSeccompProfile fails, since seccomp filters only exist on Linux
+func withSeccompProfile(string) oci.SpecOpts {
+	return func(context.Context, oci.Client, *containers.Container, *oci.Spec) error {
+		return errors.New("dexec: seccomp profiles are only supported on linux")
+	}
+}
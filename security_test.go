@@ -0,0 +1,155 @@
+package dexec
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/oci"
+	"github.com/containerd/typeurl"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const testSeccompProfile = `{"defaultAction": "SCMP_ACT_ERRNO", "syscalls": [{"names": ["read", "write"], "action": "SCMP_ACT_ALLOW"}]}`
+
+func TestSecurity_Docker(t *testing.T) {
+	security := SandboxedSecurity()
+	security.CapAdd = []string{"NET_BIND_SERVICE"}
+	security.SeccompProfile = testSeccompProfile
+	security.AppArmorProfile = "docker-default"
+	e, err := getDockerExecution(Config{ContainerConfig: ContainerConfig{Image: "busybox", Security: security}})
+	assert.NoError(t, err)
+
+	hc := e.(*createContainer).opt.HostConfig
+	assert.Equal(t, []string{"NET_BIND_SERVICE"}, hc.CapAdd)
+	assert.Equal(t, []string{"ALL"}, hc.CapDrop)
+	assert.Equal(t, []string{"seccomp=" + testSeccompProfile, "apparmor=docker-default", "no-new-privileges:true"}, hc.SecurityOpt)
+	assert.True(t, hc.ReadonlyRootfs)
+	assert.Equal(t, append(append([]string(nil), defaultMaskedPaths...), "/proc/kallsyms", "/sys/kernel"), hc.MaskedPaths)
+
+	// the defaults of Docker apply when nothing is set
+	e, err = getDockerExecution(Config{ContainerConfig: ContainerConfig{Image: "busybox"}})
+	assert.NoError(t, err)
+	assert.Equal(t, docker.HostConfig{Mounts: []docker.HostMount{}}, *e.(*createContainer).opt.HostConfig)
+}
+
+func TestSecurity_nerdctlArgs(t *testing.T) {
+	security := SandboxedSecurity()
+	security.CapAdd = []string{"NET_BIND_SERVICE"}
+	security.AppArmorProfile = Unconfined
+	assert.Equal(t, []string{
+		"--cap-drop", "ALL",
+		"--cap-add", "NET_BIND_SERVICE",
+		"--security-opt", "seccomp=/tmp/profile.json",
+		"--security-opt", "apparmor=unconfined",
+		"--security-opt", "no-new-privileges",
+		"--read-only",
+	}, security.nerdctlArgs("/tmp/profile.json"))
+	assert.Equal(t, []string{"--security-opt", "seccomp=unconfined"}, Security{SeccompProfile: Unconfined}.nerdctlArgs(""))
+	assert.Empty(t, Security{}.nerdctlArgs(""))
+}
+
+func TestSecurity_writeSeccompProfile(t *testing.T) {
+	name, remove, err := Security{SeccompProfile: testSeccompProfile}.writeSeccompProfile()
+	assert.NoError(t, err)
+	b, err := os.ReadFile(name)
+	assert.NoError(t, err)
+	assert.Equal(t, testSeccompProfile, string(b))
+	remove()
+	_, err = os.Stat(name)
+	assert.True(t, os.IsNotExist(err))
+
+	for _, profile := range []string{"", Unconfined} {
+		name, remove, err = Security{SeccompProfile: profile}.writeSeccompProfile()
+		assert.NoError(t, err)
+		assert.Empty(t, name)
+		remove()
+	}
+}
+
+func TestSecurity_specOpts(t *testing.T) {
+	security := SandboxedSecurity()
+	security.CapAdd = []string{"NET_BIND_SERVICE", "CAP_CHOWN"}
+	security.AppArmorProfile = "dexec"
+	if runtime.GOOS == "linux" {
+		security.SeccompProfile = testSeccompProfile
+	}
+	ctx := namespaces.WithNamespace(context.Background(), "unit-test")
+	spec, err := oci.GenerateSpec(ctx, nil, &containers.Container{ID: "unit-test"}, security.specOpts()...)
+	assert.NoError(t, err)
+
+	caps := []string{"CAP_NET_BIND_SERVICE", "CAP_CHOWN"}
+	assert.Equal(t, caps, spec.Process.Capabilities.Bounding)
+	assert.Equal(t, caps, spec.Process.Capabilities.Effective)
+	assert.Equal(t, caps, spec.Process.Capabilities.Permitted)
+	assert.True(t, spec.Process.NoNewPrivileges)
+	assert.True(t, spec.Root.Readonly)
+	assert.Equal(t, "dexec", spec.Process.ApparmorProfile)
+	assert.Contains(t, spec.Linux.MaskedPaths, "/proc/kcore")
+	assert.Contains(t, spec.Linux.MaskedPaths, "/sys/kernel")
+	if runtime.GOOS == "linux" {
+		assert.Equal(t, specs.ActErrno, spec.Linux.Seccomp.DefaultAction)
+		assert.Equal(t, []string{"read", "write"}, spec.Linux.Seccomp.Syscalls[0].Names)
+	}
+
+	// dropping a capability keeps the others of the default spec
+	spec, err = oci.GenerateSpec(ctx, nil, &containers.Container{ID: "unit-test"}, Security{CapDrop: []string{"NET_RAW"}}.specOpts()...)
+	assert.NoError(t, err)
+	assert.NotContains(t, spec.Process.Capabilities.Bounding, "CAP_NET_RAW")
+	assert.Contains(t, spec.Process.Capabilities.Bounding, "CAP_CHOWN")
+}
+
+func Test_createTask_maskPaths(t *testing.T) {
+	mockContainer := new(container)
+	spec := &oci.Spec{}
+	var updated *oci.Spec
+	mockContainer.
+		On("Spec", mock.Anything).Return(spec, nil).
+		On("Update", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		var c containers.Container
+		for _, opt := range args.Get(1).([]containerd.UpdateContainerOpts) {
+			assert.NoError(t, opt(context.Background(), nil, &c))
+		}
+		v, err := typeurl.UnmarshalAny(c.Spec)
+		assert.NoError(t, err)
+		updated = v.(*oci.Spec)
+	})
+	ct := &createTask{opts: CreateTaskOptions{Security: Security{MaskedPaths: []string{"/data/private"}}}}
+
+	assert.NoError(t, ct.maskPaths(mockContainer))
+	assert.Equal(t, append(append([]string(nil), defaultMaskedPaths...), "/data/private"), updated.Linux.MaskedPaths)
+	mockContainer.AssertExpectations(t)
+
+	// the container is not updated without masked paths
+	ct.opts.Security = SandboxedSecurity()
+	ct.opts.Security.MaskedPaths = nil
+	assert.NoError(t, ct.maskPaths(new(container)))
+}
+
+func TestSecurity_Podman(t *testing.T) {
+	server := newFakePodmanServer(t, echoProcess)
+	security := SandboxedSecurity()
+	security.SeccompProfile = Unconfined
+	cmd := newFakePodmanCmd(t, server, Config{ContainerConfig: ContainerConfig{Security: security}}, "echo")
+
+	assert.NoError(t, cmd.Start())
+	spec := server.Spec(cmd.GetPID())
+	assert.NoError(t, cmd.Wait())
+	assert.Equal(t, []string{"ALL"}, spec.CapDrop)
+	assert.Equal(t, Unconfined, spec.SeccompProfile)
+	assert.True(t, spec.NoNewPrivileges)
+	assert.True(t, spec.ReadOnlyRootfs)
+	assert.Equal(t, []string{"/proc/kallsyms", "/sys/kernel"}, spec.Mask)
+}
+
+func TestSecurity_Local(t *testing.T) {
+	_, err := NewCommand(&Local{}, WithConfig(Config{ContainerConfig: ContainerConfig{Security: SandboxedSecurity()}}), WithCommand("true"))
+	assert.ErrorIs(t, err, errSecurityUnsupported)
+}
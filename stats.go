@@ -1,5 +1,11 @@
 package dexec
 
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
 type Stats struct {
 	Running          int
 	Created          int
@@ -15,7 +21,37 @@ func GetStats(client interface{}) (Stats, error) {
 	switch c := client.(type) {
 	case Containerd:
 		return getContainerdStats(c)
+	case Docker:
+		return getDockerStats(c)
 	default:
 		return Stats{}, nil
 	}
 }
+
+// GetStatsBy returns the Stats of the containers grouped by the value of their Config.Metadata
+// key. Containers without the key are counted in the "" group.
+func GetStatsBy(client interface{}, key string) (map[string]Stats, error) {
+	switch c := client.(type) {
+	case Containerd:
+		return getContainerdStatsBy(c, key)
+	case Docker:
+		return getDockerStatsBy(c, key)
+	default:
+		return map[string]Stats{}, nil
+	}
+}
+
+// addDeadline counts the container in DeadlineExceeded if the deadline of its labels has passed, and in Errors if
+// the deadline cannot be parsed
+func (s *Stats) addDeadline(labels map[string]string) {
+	deadline, ok := labels[deadlineLabel]
+	if !ok {
+		return
+	}
+	if deadlineTime, err := time.Parse(time.RFC3339, deadline); err != nil {
+		logrus.Warnf("stats: error parsing time: %v", err)
+		s.Errors += 1
+	} else if time.Now().After(deadlineTime) {
+		s.DeadlineExceeded += 1
+	}
+}
@@ -0,0 +1,41 @@
+package dexec
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containerd/continuity/fs"
+	"github.com/opencontainers/runc/libcontainer/user"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// resolveUser resolves ContainerConfig.User against the /etc/passwd and /etc/group of the
+// root filesystem root the way Docker does. The user and the group are names or numeric
+// IDs; names must exist in the files while IDs need not. The group defaults to the group
+// of the user in /etc/passwd, and when it is not set the groups listing the user as a
+// member in /etc/group are added as supplementary groups.
+func resolveUser(root, spec string) (specs.User, error) {
+	passwd, err := fs.RootPath(root, "/etc/passwd")
+	if err != nil {
+		return specs.User{}, fmt.Errorf("dexec: failed to resolve user %q: %w", spec, err)
+	}
+	group, err := fs.RootPath(root, "/etc/group")
+	if err != nil {
+		return specs.User{}, fmt.Errorf("dexec: failed to resolve user %q: %w", spec, err)
+	}
+	u, err := user.GetExecUserPath(spec, nil, passwd, group)
+	if err != nil {
+		return specs.User{}, fmt.Errorf("dexec: failed to resolve user %q: %w", spec, err)
+	}
+	resolved := specs.User{UID: uint32(u.Uid), GID: uint32(u.Gid)}
+	for _, gid := range u.Sgids {
+		resolved.AdditionalGids = append(resolved.AdditionalGids, uint32(gid))
+	}
+	return resolved, nil
+}
+
+// validUser reports whether u is in the user[:group] format
+func validUser(u string) bool {
+	name, group, ok := strings.Cut(u, ":")
+	return name != "" && (!ok || group != "" && !strings.Contains(group, ":"))
+}
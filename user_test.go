@@ -0,0 +1,83 @@
+package dexec
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeFiles writes files, by path relative to root, under root
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		assert.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+}
+
+func Test_resolveUser(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"etc/passwd": "root:x:0:0:root:/root:/bin/sh\napp:x:1000:1000::/home/app:/bin/sh\nweb:x:1001:1001::/home/web:/bin/sh\n",
+		"etc/group":  "root:x:0:\napp:x:1000:\nweb:x:1001:\ndocker:x:999:app,web\naudio:x:29:app\n",
+	})
+	tests := []struct {
+		user    string
+		want    specs.User
+		wantErr string
+	}{
+		{user: "app", want: specs.User{UID: 1000, GID: 1000, AdditionalGids: []uint32{999, 29}}},
+		{user: "1000", want: specs.User{UID: 1000, GID: 1000, AdditionalGids: []uint32{999, 29}}},
+		{user: "app:web", want: specs.User{UID: 1000, GID: 1001}},
+		{user: "app:999", want: specs.User{UID: 1000, GID: 999}},
+		{user: "1000:1000", want: specs.User{UID: 1000, GID: 1000}},
+		{user: "61000", want: specs.User{UID: 61000}},
+		{user: "61000:61000", want: specs.User{UID: 61000, GID: 61000}},
+		{user: "nobody", wantErr: `dexec: failed to resolve user "nobody": unable to find user nobody: no matching entries in passwd file`},
+		{user: "app:nogroup", wantErr: `dexec: failed to resolve user "app:nogroup": unable to find group nogroup: no matching entries in group file`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.user, func(t *testing.T) {
+			u, err := resolveUser(root, tt.user)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, u)
+		})
+	}
+}
+
+func Test_resolveUser_NoFiles(t *testing.T) {
+	u, err := resolveUser(t.TempDir(), "1000:1000")
+	assert.NoError(t, err)
+	assert.Equal(t, specs.User{UID: 1000, GID: 1000}, u)
+
+	_, err = resolveUser(t.TempDir(), "app")
+	assert.Error(t, err)
+}
+
+func Test_resolveUser_SymlinkInRoot(t *testing.T) {
+	// a link to an absolute path is resolved in the root filesystem, not on the host
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"data/passwd": "app:x:1000:1000::/home/app:/bin/sh\n"})
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "etc"), 0755))
+	assert.NoError(t, os.Symlink("/data/passwd", filepath.Join(root, "etc", "passwd")))
+
+	u, err := resolveUser(root, "app")
+	assert.NoError(t, err)
+	assert.Equal(t, specs.User{UID: 1000, GID: 1000}, u)
+}
+
+func Test_validUser(t *testing.T) {
+	for _, u := range []string{"app", "1000", "app:app", "1000:1000"} {
+		assert.True(t, validUser(u), u)
+	}
+	for _, u := range []string{":app", "app:", "app:app:app", ":"} {
+		assert.False(t, validUser(u), u)
+	}
+}
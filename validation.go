@@ -0,0 +1,124 @@
+package dexec
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/containerd/containerd"
+)
+
+// ValidationError reports an invalid field of a Config.
+type ValidationError struct {
+	// Field is the path of the field in Config, e.g. ContainerConfig.Mounts[0].Destination
+	Field string
+	// Message describes why the value is invalid
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationErrors lists every invalid field of a Config. It is returned by NewCommand
+// and Config.Validate.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return "dexec: invalid config: " + strings.Join(msgs, "; ")
+}
+
+// Validate checks the fields of the config that do not depend on the client running
+// the command, and returns ValidationErrors listing every invalid field.
+func (c Config) Validate() error {
+	return c.validate(nil)
+}
+
+// validate checks the config of a command run with client, which may be nil
+func (c Config) validate(client interface{}) error {
+	var errs ValidationErrors
+	invalid := func(field, format string, args ...interface{}) {
+		errs = append(errs, &ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// the local backend runs commands on the host, without an image and with host paths
+	_, local := client.(*Local)
+	local = local || c.Backend == LocalBackend
+	if !local && c.ContainerConfig.Image == "" {
+		invalid("ContainerConfig.Image", "image is required")
+	}
+	if u := c.ContainerConfig.User; u != "" && !validUser(u) {
+		invalid("ContainerConfig.User", "%q is not in the user[:group] format", u)
+	}
+	for i, e := range c.ContainerConfig.Env {
+		if kv := strings.SplitN(e, "=", 2); len(kv) != 2 || kv[0] == "" {
+			invalid(fmt.Sprintf("ContainerConfig.Env[%d]", i), "%q is not in the KEY=VALUE format", e)
+		}
+	}
+	if !c.ContainerConfig.EnvMerge.valid() {
+		invalid("ContainerConfig.EnvMerge", "unknown merge mode %q", c.ContainerConfig.EnvMerge)
+	}
+	validateLabels("ContainerConfig.Labels", c.ContainerConfig.Labels, reservedLabels, invalid)
+	validateLabels("ContainerConfig.Annotations", c.ContainerConfig.Annotations, nil, invalid)
+	c.ContainerConfig.Security.validate(invalid)
+	for i, m := range c.ContainerConfig.Mounts {
+		field := fmt.Sprintf("ContainerConfig.Mounts[%d]", i)
+		if !path.IsAbs(m.Destination) {
+			invalid(field+".Destination", "%q is not an absolute path", m.Destination)
+		}
+		m.validate(field, invalid)
+	}
+	if c.TaskConfig.Executable == "" {
+		invalid("TaskConfig.Executable", "executable is required")
+	}
+	if c.TaskConfig.Timeout < 0 {
+		invalid("TaskConfig.Timeout", "timeout %s is negative", c.TaskConfig.Timeout)
+	}
+	if dir := c.TaskConfig.WorkingDir; dir != "" && !local && !path.IsAbs(dir) {
+		invalid("TaskConfig.WorkingDir", "%q is not an absolute path", dir)
+	}
+	if !c.TaskConfig.DirMerge.valid() {
+		invalid("TaskConfig.DirMerge", "unknown merge mode %q", c.TaskConfig.DirMerge)
+	}
+	paths := make(map[string]bool, len(c.Secrets.Files))
+	for i, s := range c.Secrets.Files {
+		field := fmt.Sprintf("Secrets.Files[%d]", i)
+		if s.Name == "" {
+			invalid(field+".Name", "name is required")
+		}
+		if !path.IsAbs(s.Path) {
+			invalid(field+".Path", "%q is not an absolute path", s.Path)
+		} else if paths[path.Clean(s.Path)] {
+			invalid(field+".Path", "%q is the path of another secret", s.Path)
+		}
+		paths[path.Clean(s.Path)] = true
+	}
+	if len(c.Secrets.Files) > 0 && c.Secrets.Source == nil {
+		invalid("Secrets.Source", "source is required")
+	}
+	c.Workspace.validate(invalid)
+	validateLabels("Metadata", c.Metadata, nil, invalid)
+	if _, err := namePrefix(c.NameTemplate, c.Metadata, c.CommandDetails); err != nil {
+		invalid("NameTemplate", "%v", err)
+	}
+	if _, ok := client.(*containerd.Client); ok && c.Namespace == "" {
+		invalid("Namespace", "namespace is required with containerd")
+	}
+	if c.Backend != "" {
+		backendsMu.RLock()
+		_, ok := backends[c.Backend]
+		backendsMu.RUnlock()
+		if !ok {
+			invalid("Backend", "unknown backend %q", c.Backend)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
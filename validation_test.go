@@ -0,0 +1,94 @@
+package dexec
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	valid := Config{
+		ContainerConfig: ContainerConfig{
+			Image:  "busybox",
+			Env:    []string{"A=1", "B="},
+			Mounts: []Mount{{Type: "bind", Source: "/src", Destination: "/dst"}, {Type: "tmpfs", Destination: "/tmp"}},
+		},
+		TaskConfig: TaskConfig{Executable: "echo", WorkingDir: "/work"},
+	}
+	assert.NoError(t, valid.Validate())
+
+	invalid := Config{
+		ContainerConfig: ContainerConfig{
+			User:        "app:",
+			Env:         []string{"A=1", "NOVALUE", "=1"},
+			EnvMerge:    "merge",
+			Labels:      map[string]string{"team": "builds", ownerLabel: "spoofed"},
+			Annotations: map[string]string{"": "empty"},
+			Security:    Security{SeccompProfile: "{"},
+			Mounts: []Mount{
+				{Type: "bind", Destination: "dst"},
+				{Type: "volume", Destination: "/cache", Propagation: "shared"},
+				{Type: "tmpfs", Source: "/src", Destination: "/tmp", NoCopy: true, SELinuxRelabel: "z"},
+				{Type: "bind", Source: "/src", Destination: "/dst", Options: []string{"size=1m"}, TmpfsMode: 0700},
+				{Type: "overlay", Destination: "/overlay"},
+			},
+		},
+		TaskConfig: TaskConfig{Timeout: -time.Second, WorkingDir: "work"},
+		Secrets:    Secrets{Files: []Secret{{Name: "a", Path: "/run/a"}, {Path: "/run/a/"}}},
+		Backend:    "no-such-backend",
+	}
+	err := invalid.Validate()
+	var verrs ValidationErrors
+	assert.True(t, errors.As(err, &verrs))
+	var fields []string
+	for _, e := range verrs {
+		fields = append(fields, e.Field)
+	}
+	assert.Equal(t, []string{
+		"ContainerConfig.Image",
+		"ContainerConfig.User",
+		"ContainerConfig.Env[1]",
+		"ContainerConfig.Env[2]",
+		"ContainerConfig.EnvMerge",
+		"ContainerConfig.Labels",
+		"ContainerConfig.Annotations",
+		"ContainerConfig.Security.SeccompProfile",
+		"ContainerConfig.Mounts[0].Destination",
+		"ContainerConfig.Mounts[0].Source",
+		"ContainerConfig.Mounts[1].Source",
+		"ContainerConfig.Mounts[1].Propagation",
+		"ContainerConfig.Mounts[2].Source",
+		"ContainerConfig.Mounts[2].SELinuxRelabel",
+		"ContainerConfig.Mounts[2].NoCopy",
+		"ContainerConfig.Mounts[3].TmpfsSize",
+		"ContainerConfig.Mounts[4].Type",
+		"TaskConfig.Executable",
+		"TaskConfig.Timeout",
+		"TaskConfig.WorkingDir",
+		"Secrets.Files[1].Name",
+		"Secrets.Files[1].Path",
+		"Secrets.Source",
+		"Backend",
+	}, fields)
+	assert.Contains(t, err.Error(), `dexec: invalid config: ContainerConfig.Image: image is required; ContainerConfig.User: "app:" is not in the user[:group] format; ContainerConfig.Env[1]: "NOVALUE" is not in the KEY=VALUE format;`)
+}
+
+func TestConfig_validate_Client(t *testing.T) {
+	config := Config{TaskConfig: TaskConfig{Executable: "echo", WorkingDir: "."}}
+	assert.NoError(t, config.validate(&Local{}))
+
+	config.Backend = LocalBackend
+	assert.NoError(t, config.Validate())
+
+	config = Config{ContainerConfig: ContainerConfig{Image: "busybox"}, TaskConfig: TaskConfig{Executable: "echo"}, Namespace: "unit-test"}
+	assert.NoError(t, config.validate(&containerd.Client{}))
+	config.Namespace = ""
+	err := config.validate(&containerd.Client{})
+	var verrs ValidationErrors
+	assert.True(t, errors.As(err, &verrs))
+	assert.Len(t, verrs, 1)
+	assert.Equal(t, &ValidationError{Field: "Namespace", Message: "namespace is required with containerd"}, verrs[0])
+}
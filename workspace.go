@@ -0,0 +1,62 @@
+package dexec
+
+import (
+	"errors"
+	"io"
+	"path"
+)
+
+// Workspace is a private scratch directory of a command. It is created with the container
+// of the command and deleted with it, by Cmd.Wait or by Cmd.Cleanup when the command
+// fails. Docker backs it with a named volume and containerd with a dedicated snapshot, or
+// a tmpfs when Size is set; the other backends fail to create commands with a workspace.
+type Workspace struct {
+	// Path is the absolute path of the workspace in the container
+	Path string
+	// Size limits the size of the workspace in bytes, making it a tmpfs. Unlimited if zero.
+	Size int64
+	// Source is a host directory whose content is copied into the workspace before the
+	// command starts
+	Source string
+	// Content is a tar archive extracted into the workspace before the command starts,
+	// after Source
+	Content io.Reader
+}
+
+// copies returns the copies populating the workspace
+func (w *Workspace) copies() []pendingCopy {
+	if w == nil {
+		return nil
+	}
+	var copies []pendingCopy
+	if w.Source != "" {
+		source := w.Source
+		copies = append(copies, pendingCopy{dst: w.Path, open: func() io.Reader { return tarPath(source, ".") }})
+	}
+	if w.Content != nil {
+		content := w.Content
+		copies = append(copies, pendingCopy{dst: w.Path, open: func() io.Reader { return content }})
+	}
+	return copies
+}
+
+// validate checks the fields of the workspace, reporting invalid fields with invalid
+func (w *Workspace) validate(invalid func(field, format string, args ...interface{})) {
+	if w == nil {
+		return
+	}
+	if !path.IsAbs(w.Path) {
+		invalid("Workspace.Path", "%q is not an absolute path", w.Path)
+	}
+	if w.Size < 0 {
+		invalid("Workspace.Size", "size %d is negative", w.Size)
+	}
+}
+
+// workspaceName returns a new name for the volume or the snapshot of a workspace
+func workspaceName() string {
+	return "dexec-workspace-" + RandomString(2*randomSuffixLength)
+}
+
+// errWorkspaceUnsupported is returned by backends that cannot create a Workspace
+var errWorkspaceUnsupported = errors.New("dexec: Workspace is not supported by this backend")
@@ -0,0 +1,159 @@
+package dexec
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/mount"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestWorkspace_Docker(t *testing.T) {
+	server := newFakeDockerServer(t, echoProcess)
+	src := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "in.txt"), []byte("hello"), 0644))
+	cmd, err := NewCommand(server.Client(t).Client, WithImage("busybox"), WithCommand("echo", "hi"),
+		WithWorkspace(Workspace{Path: "/work", Size: 1 << 20, Source: src, Content: tarOf(t, "extra.txt", "world")}))
+	assert.NoError(t, err)
+
+	assert.NoError(t, cmd.Start())
+	volumes := server.Volumes()
+	assert.Len(t, volumes, 1)
+	assert.True(t, strings.HasPrefix(volumes[0], "dexec-workspace-"))
+	assert.Equal(t, map[string]string{"type": "tmpfs", "device": "tmpfs", "o": "size=1048576"}, server.VolumeOptions(volumes[0]).DriverOpts)
+	hc := server.HostConfig(cmd.GetPID())
+	assert.Equal(t, []docker.HostMount{{Type: "volume", Source: volumes[0], Target: "/work", VolumeOptions: &docker.VolumeOptions{NoCopy: true}}}, hc.Mounts)
+	assert.Equal(t, []string{"/work", "/work/in.txt", "/work/extra.txt"}, server.Uploads(cmd.GetPID()))
+
+	assert.NoError(t, cmd.Wait())
+	assert.Empty(t, server.Volumes())
+	assert.Empty(t, server.Containers())
+}
+
+func TestWorkspace_Docker_Cleanup(t *testing.T) {
+	server := newFakeDockerServer(t, echoProcess)
+	server.Fail("create", http.StatusInternalServerError)
+	cmd, err := NewCommand(server.Client(t).Client, WithImage("busybox"), WithCommand("echo"), WithWorkspace(Workspace{Path: "/work"}))
+	assert.NoError(t, err)
+
+	assert.Error(t, cmd.Start())
+	assert.Len(t, server.Volumes(), 1)
+	assert.NoError(t, cmd.Cleanup())
+	assert.Empty(t, server.Volumes())
+	assert.NoError(t, cmd.Cleanup())
+}
+
+func TestWorkspace_Docker_NilHostConfig(t *testing.T) {
+	server := newFakeDockerServer(t, echoProcess)
+	method, err := ByCreatingContainer(docker.CreateContainerOptions{Config: &docker.Config{Image: "busybox"}},
+		WithWorkspaceVolume(&Workspace{Path: "/work"}))
+	assert.NoError(t, err)
+	cmd := server.Client(t).Command(method, "echo", "hi")
+
+	assert.NoError(t, cmd.Start())
+	volumes := server.Volumes()
+	assert.Len(t, volumes, 1)
+	assert.Equal(t, []docker.HostMount{{Type: "volume", Source: volumes[0], Target: "/work", VolumeOptions: &docker.VolumeOptions{NoCopy: true}}},
+		server.HostConfig(cmd.GetPID()).Mounts)
+	assert.NoError(t, cmd.Wait())
+	assert.Empty(t, server.Volumes())
+}
+
+// mockWorkspaceMounts replaces the mounting of workspaces for the test and returns the mounts of the workspaces by
+// their directory
+func mockWorkspaceMounts(t *testing.T) map[string][]mount.Mount {
+	mounted := map[string][]mount.Mount{}
+	origMount, origUnmount := mountWorkspace, unmountWorkspace
+	t.Cleanup(func() { mountWorkspace, unmountWorkspace = origMount, origUnmount })
+	mountWorkspace = func(mounts []mount.Mount, target string) error {
+		mounted[target] = mounts
+		return nil
+	}
+	unmountWorkspace = func(target string) error {
+		delete(mounted, target)
+		return nil
+	}
+	return mounted
+}
+
+func Test_createTask_createWorkspace(t *testing.T) {
+	mounted := mockWorkspaceMounts(t)
+	mounts := []mount.Mount{{Type: "bind", Source: "/var/lib/containerd/snapshots/1/fs", Options: []string{"rbind", "rw"}}}
+	mockSnapshotter := new(snapshotter)
+	mockSnapshotter.
+		On("Prepare", mock.Anything, mock.MatchedBy(func(key string) bool { return strings.HasPrefix(key, "dexec-workspace-") }), "").Return(mounts, nil).
+		On("Remove", mock.Anything, mock.Anything).Return(errdefs.ErrNotFound)
+	client := new(client)
+	client.On("SnapshotService", containerd.DefaultSnapshotter).Return(mockSnapshotter)
+	c := Containerd{ContainerdClient: client}
+
+	ct := &createTask{opts: CreateTaskOptions{Workspace: &Workspace{Path: "/work/"}}}
+	assert.NoError(t, ct.createWorkspace(c))
+	dir := ct.workspace.dir
+	assert.Equal(t, map[string][]mount.Mount{dir: mounts}, mounted)
+	assert.Equal(t, []specs.Mount{{Type: "bind", Source: dir, Destination: "/work/"}}, ct.opts.Mounts)
+	info, err := os.Stat(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, os.ModeDir|os.ModeSticky|0777, info.Mode())
+
+	// copies to the workspace go to the directory on the host instead of the root filesystem
+	assert.NoError(t, ct.copyIn(c, "/work/data", tarOf(t, "in.txt", "hello")))
+	b, err := os.ReadFile(filepath.Join(dir, "data", "in.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+	var out bytes.Buffer
+	assert.NoError(t, ct.copyOut(c, "/work/data/in.txt", &out))
+	assert.NotZero(t, out.Len())
+
+	// the container failed to be created, so there is no task nor container to delete
+	assert.NoError(t, ct.cleanup(c))
+	assert.Empty(t, mounted)
+	assert.NoDirExists(t, dir)
+	assert.Nil(t, ct.workspace)
+	mockSnapshotter.AssertExpectations(t)
+}
+
+func Test_createTask_createWorkspace_Tmpfs(t *testing.T) {
+	mounted := mockWorkspaceMounts(t)
+	ct := &createTask{opts: CreateTaskOptions{Workspace: &Workspace{Path: "/work", Size: 1 << 20}}}
+	assert.NoError(t, ct.createWorkspace(Containerd{}))
+	dir := ct.workspace.dir
+	assert.Equal(t, map[string][]mount.Mount{dir: {{Type: "tmpfs", Source: "tmpfs", Options: []string{"size=1048576"}}}}, mounted)
+
+	assert.NoError(t, ct.removeWorkspace(Containerd{}))
+	assert.Empty(t, mounted)
+	assert.NoDirExists(t, dir)
+}
+
+func Test_taskWorkspace_root(t *testing.T) {
+	w := &taskWorkspace{path: "/work", dir: "/tmp/ws"}
+	for p, rel := range map[string]string{"/work": "/", "/work/": "/", "/work/a/b": "a/b"} {
+		dir, r, ok := w.root(p)
+		assert.True(t, ok, p)
+		assert.Equal(t, "/tmp/ws", dir)
+		assert.Equal(t, rel, r)
+	}
+	for _, p := range []string{"/", "/workspace", "/data/work"} {
+		_, _, ok := w.root(p)
+		assert.False(t, ok, p)
+	}
+	_, _, ok := (*taskWorkspace)(nil).root("/work")
+	assert.False(t, ok)
+}
+
+func TestWorkspace_Unsupported(t *testing.T) {
+	_, err := NewCommand(&Local{}, WithCommand("true"), WithWorkspace(Workspace{Path: "/work"}))
+	assert.ErrorIs(t, err, errWorkspaceUnsupported)
+
+	_, err = NewCommand(&Local{}, WithCommand("true"), WithWorkspace(Workspace{Path: "work", Size: -1}))
+	assert.EqualError(t, err, `dexec: invalid config: Workspace.Path: "work" is not an absolute path; Workspace.Size: size -1 is negative`)
+}